@@ -4,31 +4,56 @@ package api
 import (
 	"github.com/casbin/casbin/v2"
 	"github.com/ezzddinne/api/app"
+	"github.com/ezzddinne/api/app/audit"
+	"github.com/ezzddinne/api/file"
+	"github.com/ezzddinne/api/form"
+	"github.com/ezzddinne/api/match"
 	"github.com/ezzddinne/api/squad"
 	"github.com/ezzddinne/api/user"
+	"github.com/ezzddinne/config"
 	"github.com/ezzddinne/middleware"
+	"github.com/ezzddinne/scanner"
+	"github.com/ezzddinne/storage"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
-func RoutesApis(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.Enforcer) {
+func RoutesApis(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.SyncedEnforcer, cfg *config.Config, store storage.Storage, sc scanner.Scanner) {
 
 	// auth routes
-	user.RoutesAuth(router.Group("/user"), db, enforcer)
+	user.RoutesAuth(router.Group("/user"), db, enforcer, cfg)
 
 	// reset password routes
-	user.RoutesUserPassword(router.Group("/user/reset"), db, enforcer)
+	user.RoutesUserPassword(router.Group("/user/reset"), db, enforcer, cfg)
 
 	// user route
-	user.RoutesUsersJWT(router.Group("/user/jwt", middleware.AuthorizeJWT()), db, enforcer)
+	user.RoutesUsersJWT(router.Group("/user/jwt", middleware.AuthorizeJWT(db), audit.Audit(db)), db, enforcer, cfg)
 
 	// paiment status route
-	user.RouteAdmin(router.Group("/user/paiment", middleware.AuthorizeJWT()), db, enforcer)
+	user.RouteAdmin(router.Group("/user/paiment", middleware.AuthorizeJWT(db), audit.Audit(db)), db, enforcer, cfg)
 
 	// auth jwt routes
-	squad.RoutesAuthJWT(router.Group("/auth/jwt", middleware.AuthorizeJWT()), db, enforcer)
+	squad.RoutesAuthJWT(router.Group("/auth/jwt", middleware.AuthorizeJWT(db), audit.Audit(db)), db, enforcer, cfg, store, sc)
+
+	// squad directory routes
+	squad.RoutesSquads(router.Group("/squads"), db, enforcer, cfg)
+
+	// signed file urls routes
+	file.RoutesFilesJWT(router.Group("/files", middleware.AuthorizeJWT(db), audit.Audit(db)), db, enforcer, cfg, store, sc)
+
+	// file download routes
+	file.RoutesFiles(router.Group("/files"), db, enforcer, cfg, store)
+
+	// registration form routes
+	form.RoutesForms(router.Group("/form"), db, enforcer, cfg)
+
+	// form builder and answers routes
+	form.RoutesFormsJWT(router.Group("/form", middleware.AuthorizeJWT(db), audit.Audit(db)), db, enforcer, cfg)
+
+	// looking for team routes
+	match.RoutesMatchJWT(router.Group("/match", middleware.AuthorizeJWT(db), audit.Audit(db)), db, enforcer, cfg)
 
 	// app routes
-	app.RoutesApps(router.Group("/app", middleware.AuthorizeJWT()), db, enforcer)
+	app.RoutesApps(router.Group("/app", middleware.AuthorizeJWT(db), audit.Audit(db)), db, enforcer, cfg)
 
 }
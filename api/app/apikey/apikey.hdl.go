@@ -0,0 +1,218 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/ezzddinne/api/app/role"
+	"github.com/ezzddinne/config"
+	"github.com/ezzddinne/middleware"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type Database struct {
+	DB       *gorm.DB
+	Enforcer *casbin.SyncedEnforcer
+	Config   *config.Config
+}
+
+// default api key lifetime
+const defaultExpiry = 90 * 24 * time.Hour
+
+// the root role and the roles inheriting it are never bound to a key
+// the other roles only by root or by a caller holding the role in the domain of the key
+func canGrantRole(enforcer *casbin.SyncedEnforcer, root, subject, role_name, domain string) error {
+
+	if role_name == root {
+		return errors.New("the root role can't be bound to an api key")
+	}
+
+	inherits, err := enforcer.GetImplicitRolesForUser(role_name, domain)
+	if err != nil {
+		return err
+	}
+	for _, name := range inherits {
+		if name == root {
+			return errors.New("a role inheriting the root role can't be bound to an api key")
+		}
+	}
+
+	held, err := enforcer.GetImplicitRolesForUser(subject, domain)
+	if err != nil {
+		return err
+	}
+	for _, name := range held {
+		if name == role_name || name == root {
+			return nil
+		}
+	}
+
+	return errors.New("you can only bind a role you hold in the domain of the key")
+}
+
+// create new api key
+// @Security bearerAuth
+// @Summary Create API key
+// @Description This method creates an API key for a machine client, the key is only returned once. The root role is refused and the caller must hold the role in the domain of the key.
+// @Tags App
+// @Accept json
+// @Produce json
+// @Param request body APIKeyInput true "API key required fields"
+// @Schemes
+// @Success 200 {object} apikey.APIKeyCreated
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /app/apikey/new [post]
+func (db Database) NewAPIKey(ctx *gin.Context) {
+
+	//init vars
+	var input APIKeyInput
+	empty_reg := db.Config.EmptyRegexp()
+
+	//unmarshal sent json
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// check fields
+	if empty_reg.MatchString(input.Name) || empty_reg.MatchString(input.Role) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "invalid fields"})
+		return
+	}
+
+	// check role exists
+	if _, err := role.GetRoleByName(db.DB, input.Role); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "role name is invalid"})
+		return
+	}
+
+	// keys work in every domain if none is given
+	if input.Domain == "" {
+		input.Domain = middleware.AllDomains
+	}
+
+	// the key can't carry more than its creator
+	if err := canGrantRole(db.Enforcer, db.Config.RBAC.DefaultRoot, ctx.GetString("subject"), input.Role, input.Domain); err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{"message": err.Error()})
+		return
+	}
+
+	// default expiry
+	if input.ExpiresAt.IsZero() {
+		input.ExpiresAt = time.Now().Add(defaultExpiry)
+	}
+	if input.ExpiresAt.Before(time.Now()) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "expiry date is invalid"})
+		return
+	}
+
+	// generate the key
+	prefix, secret, err := generateKey()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+	key := "cmc_" + prefix + "_" + secret
+
+	// get values from session
+	session := middleware.ExtractTokenValues(ctx)
+
+	//init new api key
+	new_api_key := APIKey{
+		Name:      input.Name,
+		Prefix:    prefix,
+		Hash:      middleware.HashAPIKey(key),
+		Subject:   "apikey:" + prefix,
+		Role:      input.Role,
+		Domain:    input.Domain,
+		ExpiresAt: input.ExpiresAt,
+		CreatedBy: session.UserID,
+	}
+
+	//create api key
+	new_api_key_created, err := NewAPIKey(db.DB, new_api_key)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// the key subject inherits the role policies
+	if _, err := db.Enforcer.AddGroupingPolicy(new_api_key_created.Subject, new_api_key_created.Role, new_api_key_created.Domain); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	//api key created successfully
+	ctx.JSON(http.StatusOK, APIKeyCreated{APIKey: new_api_key_created, Key: key})
+}
+
+// get all api keys
+func (db Database) GetAllAPIKeys(ctx *gin.Context) {
+
+	api_keys, err := GetAllAPIKeys(db.DB)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	//return api keys
+	ctx.JSON(http.StatusOK, api_keys)
+}
+
+// revoke api key
+func (db Database) DeleteAPIKey(ctx *gin.Context) {
+
+	// get id value from path
+	api_key_id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// get the api key
+	api_key, err := GetAPIKeyByID(db.DB, uint(api_key_id))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// delete the api key
+	if err := DeleteAPIKey(db.DB, api_key.ID); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// remove the key subject from casbin
+	if _, err := db.Enforcer.RemoveGroupingPolicy(api_key.Subject, api_key.Role, api_key.Domain); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
+
+// generate the key prefix and secret
+func generateKey() (string, string, error) {
+
+	prefix := make([]byte, 4)
+	if _, err := rand.Read(prefix); err != nil {
+		return "", "", err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+
+	return hex.EncodeToString(prefix), base64.RawURLEncoding.EncodeToString(secret), nil
+}
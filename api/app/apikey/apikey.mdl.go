@@ -0,0 +1,53 @@
+package apikey
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type APIKey struct {
+	ID         uint       `gorm:"column:id;autoIncrement;primaryKey" json:"id"`
+	Name       string     `gorm:"column:name;not null" json:"name"`
+	Prefix     string     `gorm:"column:prefix;not null;unique" json:"prefix"`
+	Hash       string     `gorm:"column:hash;not null;unique" json:"-"`
+	Subject    string     `gorm:"column:subject;not null" json:"subject"`
+	Role       string     `gorm:"column:role;not null" json:"role"`
+	Domain     string     `gorm:"column:domain;not null;default:*" json:"domain"`
+	ExpiresAt  time.Time  `gorm:"column:expires_at;not null" json:"expires_at"`
+	LastUsedAt *time.Time `gorm:"column:last_used_at" json:"last_used_at"`
+	CreatedBy  uint       `gorm:"column:created_by" json:"created_by"`
+	gorm.Model
+}
+
+type APIKeyInput struct {
+	Name      string    `json:"name" binding:"required"`
+	Role      string    `json:"role" binding:"required"`
+	Domain    string    `json:"domain"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type APIKeyCreated struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// create new api key
+func NewAPIKey(db *gorm.DB, api_key APIKey) (APIKey, error) {
+	return api_key, db.Create(&api_key).Error
+}
+
+// get all api keys
+func GetAllAPIKeys(db *gorm.DB) (api_keys []APIKey, err error) {
+	return api_keys, db.Find(&api_keys).Error
+}
+
+// get api key by id
+func GetAPIKeyByID(db *gorm.DB, id uint) (api_key APIKey, err error) {
+	return api_key, db.Where("id = ?", id).First(&api_key).Error
+}
+
+// delete api key
+func DeleteAPIKey(db *gorm.DB, id uint) error {
+	return db.Where("id = ?", id).Delete(&APIKey{}).Error
+}
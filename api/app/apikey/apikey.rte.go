@@ -0,0 +1,23 @@
+package apikey
+
+import (
+	"github.com/casbin/casbin/v2"
+	"github.com/ezzddinne/config"
+	"github.com/ezzddinne/middleware"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func RoutesAPIKeys(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.SyncedEnforcer, cfg *config.Config) {
+
+	baseInstance := Database{DB: db, Enforcer: enforcer, Config: cfg}
+
+	// create api key route
+	router.POST("/new", middleware.Authorize("apikeys", "write", enforcer), middleware.DenyImpersonation(), baseInstance.NewAPIKey)
+
+	// get all api keys route
+	router.GET("/all", middleware.Authorize("apikeys", "read", enforcer), baseInstance.GetAllAPIKeys)
+
+	// revoke api key route
+	router.DELETE("/:id", middleware.Authorize("apikeys", "delete", enforcer), baseInstance.DeleteAPIKey)
+}
@@ -2,19 +2,34 @@ package app
 
 import (
 	"github.com/casbin/casbin/v2"
+	"github.com/ezzddinne/api/app/apikey"
+	"github.com/ezzddinne/api/app/audit"
 	"github.com/ezzddinne/api/app/permission"
 	"github.com/ezzddinne/api/app/role"
+	"github.com/ezzddinne/config"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 // declare app routes
-func RoutesApps(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.Enforcer) {
+func RoutesApps(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.SyncedEnforcer, cfg *config.Config) {
 
 	// role routes
-	role.RoutesRoles(router.Group("/role"), db, enforcer)
+	role.RoutesRoles(router.Group("/role"), db, enforcer, cfg)
+
+	// user roles routes
+	role.RoutesUserRoles(router.Group("/users"), db, enforcer, cfg)
 
 	// permission routes
-	permission.RoutesPermissions(router.Group("/permission"), db, enforcer)
+	permission.RoutesPermissions(router.Group("/permission"), db, enforcer, cfg)
+
+	// my permissions routes
+	permission.RoutesMyPermissions(router.Group("/me"), db, enforcer, cfg)
+
+	// api key routes
+	apikey.RoutesAPIKeys(router.Group("/apikey"), db, enforcer, cfg)
+
+	// audit routes
+	audit.RoutesAudit(router.Group("/audit"), db, enforcer, cfg)
 
 }
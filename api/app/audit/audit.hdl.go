@@ -0,0 +1,81 @@
+package audit
+
+import (
+	"math"
+	"net/http"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/ezzddinne/config"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type Database struct {
+	DB       *gorm.DB
+	Enforcer *casbin.SyncedEnforcer
+	Config   *config.Config
+}
+
+// events by page of the audit log
+const (
+	defaultPageSize int = 50
+	maxPageSize     int = 100
+)
+
+// get audit events
+// @Security bearerAuth
+// @Summary Audit log
+// @Description This method lists a page of the audit events, newest first, filterable by actor, target and time range.
+// @Tags App
+// @Produce json
+// @Param actor query uint false "Actor user ID"
+// @Param target query string false "Target"
+// @Param from query string false "From (RFC3339)"
+// @Param to query string false "To (RFC3339)"
+// @Param page query int false "Page, from 1"
+// @Param limit query int false "Events by page, 100 at most"
+// @Schemes
+// @Success 200 {object} audit.AuditPage
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /app/audit/all [get]
+func (db Database) GetAuditEvents(ctx *gin.Context) {
+
+	//init vars
+	var filter AuditFilter
+
+	// bind the query
+	if err := ctx.ShouldBindQuery(&filter); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.Limit < 1 {
+		filter.Limit = defaultPageSize
+	}
+	if filter.Limit > maxPageSize {
+		filter.Limit = maxPageSize
+	}
+
+	// the pages past the largest offset are empty
+	offset := math.MaxInt32
+	if filter.Page-1 <= math.MaxInt32/filter.Limit {
+		offset = (filter.Page - 1) * filter.Limit
+	}
+
+	events, total, err := GetAuditEvents(db.DB, filter, offset, filter.Limit)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	response := AuditPage{Events: append([]AuditEvent{}, events...), Page: filter.Page, Limit: filter.Limit, Total: total}
+
+	//return events
+	ctx.JSON(http.StatusOK, response)
+}
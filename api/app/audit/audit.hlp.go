@@ -0,0 +1,99 @@
+package audit
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/ezzddinne/middleware"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// record a privileged action with the state before and after it
+func Record(db *gorm.DB, ctx *gin.Context, action, target string, before, after interface{}) {
+
+	// get values from session
+	session := middleware.ExtractTokenValues(ctx)
+
+	event := AuditEvent{
+		ActorID:      session.UserID,
+		ActorSubject: ctx.GetString("role_name"),
+		Action:       action,
+		Target:       target,
+		Before:       marshal(before),
+		After:        marshal(after),
+		Status:       ctx.Writer.Status(),
+		IP:           ctx.ClientIP(),
+		RequestID:    ctx.GetString("request_id"),
+	}
+
+	// the admin is the actor while impersonating
+	if session.ActorID != 0 {
+		event.ActorID = session.ActorID
+		event.OnBehalfOf = session.UserID
+	}
+
+	if err := NewAuditEvent(db, event); err != nil {
+		log.Println("[WARNING] failed to write audit event", action, ":", err)
+		return
+	}
+
+	// the route is audited, skip the generic record
+	ctx.Set("audited", true)
+}
+
+// ReadOnly -> the route only reads even if it is not a GET, e.g. POST /permission/explain
+func ReadOnly() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		SkipAudit(ctx)
+		ctx.Next()
+	}
+}
+
+// the request changed nothing, it isn't recorded unless impersonating
+func SkipAudit(ctx *gin.Context) {
+	ctx.Set("read_only", true)
+}
+
+// Audit -> record every write request not already audited by its handler
+// every request made while impersonating is recorded
+func Audit(db *gorm.DB) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		ctx.Next()
+
+		// read requests are not audited
+		_, impersonating := ctx.Get("act")
+		if !impersonating && (ctx.Request.Method == http.MethodGet || ctx.Request.Method == http.MethodHead || ctx.Request.Method == http.MethodOptions || ctx.GetBool("read_only")) {
+			return
+		}
+
+		if ctx.GetBool("audited") {
+			return
+		}
+
+		// target built from the path params
+		var params []string
+		for _, param := range ctx.Params {
+			params = append(params, param.Key+"="+param.Value)
+		}
+
+		Record(db, ctx, ctx.Request.Method+" "+ctx.FullPath(), strings.Join(params, ","), nil, nil)
+	}
+}
+
+// marshal the state, nil stays null
+func marshal(value interface{}) json.RawMessage {
+
+	if value == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	return data
+}
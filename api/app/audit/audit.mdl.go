@@ -0,0 +1,83 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type AuditEvent struct {
+	ID           uint            `gorm:"column:id;autoIncrement;primaryKey" json:"id"`
+	ActorID      uint            `gorm:"column:actor_id;index" json:"actor_id"`
+	ActorSubject string          `gorm:"column:actor_subject" json:"actor_subject"`
+	OnBehalfOf   uint            `gorm:"column:on_behalf_of;index" json:"on_behalf_of"`
+	Action       string          `gorm:"column:action;not null" json:"action"`
+	Target       string          `gorm:"column:target;index" json:"target"`
+	Before       json.RawMessage `gorm:"column:before;type:jsonb" json:"before"`
+	After        json.RawMessage `gorm:"column:after;type:jsonb" json:"after"`
+	Status       int             `gorm:"column:status" json:"status"`
+	IP           string          `gorm:"column:ip" json:"ip"`
+	RequestID    string          `gorm:"column:request_id;index" json:"request_id"`
+	CreatedAt    time.Time       `gorm:"column:created_at;index" json:"created_at"`
+}
+
+type AuditFilter struct {
+	Actor  uint      `form:"actor"`
+	Target string    `form:"target"`
+	From   time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To     time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Page   int       `form:"page"`
+	Limit  int       `form:"limit"`
+}
+
+// page of the audit log
+type AuditPage struct {
+	Events []AuditEvent `json:"events"`
+	Page   int          `json:"page"`
+	Limit  int          `json:"limit"`
+	Total  int64        `json:"total"`
+}
+
+// audit events are append only
+var ErrAppendOnly = errors.New("audit events are append only")
+
+func (AuditEvent) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAppendOnly
+}
+
+func (AuditEvent) BeforeDelete(tx *gorm.DB) error {
+	return ErrAppendOnly
+}
+
+// create new audit event
+func NewAuditEvent(db *gorm.DB, event AuditEvent) error {
+	return db.Create(&event).Error
+}
+
+// get a page of the audit events matching the filter and their count
+func GetAuditEvents(db *gorm.DB, filter AuditFilter, offset, limit int) (events []AuditEvent, total int64, err error) {
+
+	query := db.Model(&AuditEvent{})
+
+	if filter.Actor != 0 {
+		query = query.Where("actor_id = ? OR on_behalf_of = ?", filter.Actor, filter.Actor)
+	}
+	if filter.Target != "" {
+		query = query.Where("target = ?", filter.Target)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at <= ?", filter.To)
+	}
+
+	query = query.Session(&gorm.Session{})
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return events, total, query.Order("created_at desc").Order("id desc").Offset(offset).Limit(limit).Find(&events).Error
+}
@@ -0,0 +1,17 @@
+package audit
+
+import (
+	"github.com/casbin/casbin/v2"
+	"github.com/ezzddinne/config"
+	"github.com/ezzddinne/middleware"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func RoutesAudit(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.SyncedEnforcer, cfg *config.Config) {
+
+	baseInstance := Database{DB: db, Enforcer: enforcer, Config: cfg}
+
+	// get audit events route
+	router.GET("/all", middleware.Authorize("audit", "read", enforcer), baseInstance.GetAuditEvents)
+}
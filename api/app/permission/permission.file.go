@@ -0,0 +1,534 @@
+package permission
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/ezzddinne/api/app/role"
+	"github.com/ezzddinne/middleware"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+// formats of the policy file
+const (
+	FormatYAML string = "yaml"
+	FormatCSV  string = "csv"
+)
+
+// version controlled list of roles, inheritance and permissions
+type PolicyFile struct {
+	Roles       []PolicyRole       `yaml:"roles" json:"roles"`
+	Permissions []PolicyPermission `yaml:"permissions" json:"permissions"`
+}
+
+// role and the roles it inherits in the domain
+type PolicyRole struct {
+	Name     string   `yaml:"name" json:"name"`
+	Domain   string   `yaml:"domain,omitempty" json:"domain,omitempty"`
+	Inherits []string `yaml:"inherits,omitempty" json:"inherits,omitempty"`
+}
+
+// actions granted to a role on an object in the domain
+type PolicyPermission struct {
+	Role    string   `yaml:"role" json:"role"`
+	Domain  string   `yaml:"domain,omitempty" json:"domain,omitempty"`
+	Object  string   `yaml:"object" json:"object"`
+	Actions []string `yaml:"actions" json:"actions"`
+}
+
+// changes needed to apply a policy file
+type PolicyDiff struct {
+	Roles           []string   `json:"roles"`
+	AddPolicies     [][]string `json:"add_policies"`
+	RemovePolicies  [][]string `json:"remove_policies"`
+	AddGroupings    [][]string `json:"add_groupings"`
+	RemoveGroupings [][]string `json:"remove_groupings"`
+}
+
+// format of the file from its extension
+func FormatOf(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return FormatCSV
+	}
+	return FormatYAML
+}
+
+// read a policy file
+func LoadPolicyFile(path string) (file PolicyFile, err error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return file, err
+	}
+
+	return ParsePolicyFile(data, FormatOf(path))
+}
+
+// parse a yaml or csv policy file
+func ParsePolicyFile(data []byte, format string) (file PolicyFile, err error) {
+
+	switch format {
+	case FormatYAML:
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return file, err
+		}
+	case FormatCSV:
+		if file, err = parsePolicyCSV(data); err != nil {
+			return file, err
+		}
+	default:
+		return file, fmt.Errorf("unknown policy format: %s", format)
+	}
+
+	return file, file.Validate()
+}
+
+// casbin csv lines: p, role, domain, object, action and g, role, parent, domain
+func parsePolicyCSV(data []byte) (file PolicyFile, err error) {
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return file, err
+	}
+
+	// the actions of a role on an object are grouped like the yaml file
+	index := map[string]int{}
+
+	for i, record := range records {
+		for j := range record {
+			record[j] = strings.TrimSpace(record[j])
+		}
+
+		switch {
+		case record[0] == "p" && len(record) == 5:
+			key := strings.Join(record[1:4], "\x00")
+			if j, ok := index[key]; ok {
+				file.Permissions[j].Actions = append(file.Permissions[j].Actions, record[4])
+				continue
+			}
+			index[key] = len(file.Permissions)
+			file.Permissions = append(file.Permissions, PolicyPermission{Role: record[1], Domain: record[2], Object: record[3], Actions: []string{record[4]}})
+		case record[0] == "g" && len(record) == 4:
+			file.Roles = append(file.Roles, PolicyRole{Name: record[1], Domain: record[3], Inherits: []string{record[2]}})
+		case record[0] == "r" && len(record) == 2:
+			file.Roles = append(file.Roles, PolicyRole{Name: record[1]})
+		default:
+			return file, fmt.Errorf("invalid policy line %d: %s", i+1, strings.Join(record, ", "))
+		}
+	}
+
+	return file, nil
+}
+
+// check the fields of the file
+func (file PolicyFile) Validate() error {
+
+	for _, policy_role := range file.Roles {
+		if policy_role.Name == "" {
+			return fmt.Errorf("role without name")
+		}
+		for _, parent := range policy_role.Inherits {
+			if parent == "" || parent == policy_role.Name {
+				return fmt.Errorf("invalid parent for role %s", policy_role.Name)
+			}
+		}
+	}
+
+	for _, permission := range file.Permissions {
+		if permission.Role == "" || permission.Object == "" || len(permission.Actions) == 0 {
+			return fmt.Errorf("invalid permission for role %s on %s", permission.Role, permission.Object)
+		}
+		for _, action := range permission.Actions {
+			if !middleware.IsAction(action) {
+				return fmt.Errorf("invalid action %s for role %s on %s", action, permission.Role, permission.Object)
+			}
+		}
+	}
+
+	return nil
+}
+
+// roles, casbin policies and groupings described by the file
+func (file PolicyFile) rules() (roles []string, policies [][]string, groupings [][]string) {
+
+	seen := map[string]bool{}
+	add_role := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			roles = append(roles, name)
+		}
+	}
+
+	for _, policy_role := range file.Roles {
+		add_role(policy_role.Name)
+
+		// inheritance without domain applies to every event
+		domain := policy_role.Domain
+		if domain == "" {
+			domain = middleware.AllDomains
+		}
+		for _, parent := range policy_role.Inherits {
+			add_role(parent)
+			groupings = append(groupings, []string{policy_role.Name, parent, domain})
+		}
+	}
+
+	for _, permission := range file.Permissions {
+		add_role(permission.Role)
+
+		// permissions without domain belong to the default domain
+		domain := permission.Domain
+		if domain == "" {
+			domain = middleware.DefaultDomain()
+		}
+		for _, action := range permission.Actions {
+			policies = append(policies, []string{permission.Role, domain, permission.Object, action})
+		}
+	}
+
+	return roles, policies, groupings
+}
+
+// current roles, permissions and inheritance
+func ExportPolicies(db *gorm.DB, enforcer *casbin.SyncedEnforcer) (file PolicyFile, err error) {
+
+	roles, err := role.GetAllRoles(db)
+	if err != nil {
+		return file, err
+	}
+
+	// the users and api keys groupings are not part of the file
+	inherits := map[string]map[string][]string{}
+	for _, rule := range roleGroupings(enforcer, roles) {
+		if inherits[rule[0]] == nil {
+			inherits[rule[0]] = map[string][]string{}
+		}
+		inherits[rule[0]][rule[2]] = append(inherits[rule[0]][rule[2]], rule[1])
+	}
+
+	for _, db_role := range roles {
+		if len(inherits[db_role.Name]) == 0 {
+			file.Roles = append(file.Roles, PolicyRole{Name: db_role.Name})
+			continue
+		}
+		for _, domain := range sortedKeys(inherits[db_role.Name]) {
+			file.Roles = append(file.Roles, PolicyRole{Name: db_role.Name, Domain: domain, Inherits: inherits[db_role.Name][domain]})
+		}
+	}
+
+	// group the actions by role, domain and object
+	index := map[string]int{}
+	for _, rule := range enforcer.GetPolicy() {
+		if len(rule) < 4 {
+			continue
+		}
+		key := strings.Join(rule[:3], "\x00")
+		if i, ok := index[key]; ok {
+			file.Permissions[i].Actions = append(file.Permissions[i].Actions, rule[3])
+			continue
+		}
+		index[key] = len(file.Permissions)
+		file.Permissions = append(file.Permissions, PolicyPermission{Role: rule[0], Domain: rule[1], Object: rule[2], Actions: []string{rule[3]}})
+	}
+
+	sort.SliceStable(file.Roles, func(i, j int) bool { return file.Roles[i].Name < file.Roles[j].Name })
+	sort.SliceStable(file.Permissions, func(i, j int) bool {
+		a, b := file.Permissions[i], file.Permissions[j]
+		if a.Role != b.Role {
+			return a.Role < b.Role
+		}
+		if a.Domain != b.Domain {
+			return a.Domain < b.Domain
+		}
+		return a.Object < b.Object
+	})
+
+	return file, nil
+}
+
+// write the policy file in the format
+func WritePolicyFile(writer io.Writer, file PolicyFile, format string) error {
+
+	switch format {
+	case FormatYAML:
+		encoder := yaml.NewEncoder(writer)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(file); err != nil {
+			return err
+		}
+		return encoder.Close()
+	case FormatCSV:
+		roles, policies, groupings := file.rules()
+		inherited := map[string]bool{}
+		for _, rule := range groupings {
+			inherited[rule[0]] = true
+		}
+		for _, name := range roles {
+			if !inherited[name] {
+				fmt.Fprintln(writer, policyLine("r", []string{name}))
+			}
+		}
+		for _, rule := range policies {
+			fmt.Fprintln(writer, policyLine("p", rule))
+		}
+		for _, rule := range groupings {
+			fmt.Fprintln(writer, policyLine("g", rule))
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown policy format: %s", format)
+	}
+}
+
+// compare the file with the current policies, extra rules are removed only when pruning
+func DiffPolicies(db *gorm.DB, enforcer *casbin.SyncedEnforcer, file PolicyFile, prune bool) (diff PolicyDiff, err error) {
+
+	roles, err := role.GetAllRoles(db)
+	if err != nil {
+		return diff, err
+	}
+
+	existing_roles := map[string]bool{}
+	for _, db_role := range roles {
+		existing_roles[db_role.Name] = true
+	}
+
+	file_roles, file_policies, file_groupings := file.rules()
+
+	for _, name := range file_roles {
+		if !existing_roles[name] {
+			diff.Roles = append(diff.Roles, name)
+		}
+	}
+
+	diff.AddPolicies, diff.RemovePolicies = diffRules(enforcer.GetPolicy(), file_policies)
+	diff.AddGroupings, diff.RemoveGroupings = diffRules(roleGroupings(enforcer, roles), file_groupings)
+
+	if !prune {
+		diff.RemovePolicies = nil
+		diff.RemoveGroupings = nil
+	}
+
+	return diff, nil
+}
+
+// check the whole diff before applying it: the actions and the inheritance cycles once applied
+func ValidatePolicies(enforcer *casbin.SyncedEnforcer, diff PolicyDiff) error {
+
+	for _, rule := range diff.AddPolicies {
+		if !middleware.IsAction(rule[3]) {
+			return fmt.Errorf("invalid action %s for role %s on %s", rule[3], rule[0], rule[2])
+		}
+	}
+
+	// groupings once the diff is applied
+	removed := map[string]bool{}
+	for _, rule := range diff.RemoveGroupings {
+		removed[strings.Join(rule, "\x00")] = true
+	}
+
+	var groupings [][]string
+	for _, rule := range enforcer.GetGroupingPolicy() {
+		if len(rule) >= 3 && !removed[strings.Join(rule[:3], "\x00")] {
+			groupings = append(groupings, rule[:3])
+		}
+	}
+	groupings = append(groupings, diff.AddGroupings...)
+
+	for _, rule := range diff.AddGroupings {
+		if role.CreatesCycle(groupings, rule[0], rule[1], rule[2]) {
+			return fmt.Errorf("role inheritance cycle detected: %s inherits %s in %s", rule[0], rule[1], rule[2])
+		}
+	}
+
+	return nil
+}
+
+// apply the diff to the roles table and the enforcer
+// the diff is validated first, the changes are applied by batch and undone if a batch fails
+func ApplyPolicies(db *gorm.DB, enforcer *casbin.SyncedEnforcer, diff PolicyDiff) error {
+
+	if err := ValidatePolicies(enforcer, diff); err != nil {
+		return err
+	}
+
+	// the new roles are created together
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		for _, name := range diff.Roles {
+			if err := role.NewRole(tx, role.Role{Name: name}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// undo the applied batches in reverse order
+	undo := []func() error{func() error {
+		if len(diff.Roles) == 0 {
+			return nil
+		}
+		return db.Unscoped().Where("name IN ?", diff.Roles).Delete(&role.Role{}).Error
+	}}
+	restore := func(err error) error {
+		for i := len(undo) - 1; i >= 0; i-- {
+			if undo_err := undo[i](); undo_err != nil {
+				log.Println("[WARNING] failed to restore the policies:", undo_err)
+			}
+		}
+		return err
+	}
+
+	if len(diff.RemoveGroupings) > 0 {
+		if _, err := enforcer.RemoveGroupingPolicies(diff.RemoveGroupings); err != nil {
+			return restore(err)
+		}
+		undo = append(undo, func() error {
+			_, err := enforcer.AddGroupingPoliciesEx(diff.RemoveGroupings)
+			return err
+		})
+	}
+
+	if len(diff.RemovePolicies) > 0 {
+		if _, err := enforcer.RemovePolicies(diff.RemovePolicies); err != nil {
+			return restore(err)
+		}
+		undo = append(undo, func() error {
+			_, err := enforcer.AddPoliciesEx(diff.RemovePolicies)
+			return err
+		})
+	}
+
+	if len(diff.AddPolicies) > 0 {
+		if _, err := enforcer.AddPoliciesEx(diff.AddPolicies); err != nil {
+			return restore(err)
+		}
+		undo = append(undo, func() error {
+			_, err := enforcer.RemovePolicies(diff.AddPolicies)
+			return err
+		})
+	}
+
+	if len(diff.AddGroupings) > 0 {
+		if _, err := enforcer.AddGroupingPoliciesEx(diff.AddGroupings); err != nil {
+			return restore(err)
+		}
+	}
+
+	return nil
+}
+
+// check if the diff has no change
+func (diff PolicyDiff) Empty() bool {
+	return len(diff.Roles) == 0 && len(diff.AddPolicies) == 0 && len(diff.RemovePolicies) == 0 && len(diff.AddGroupings) == 0 && len(diff.RemoveGroupings) == 0
+}
+
+// domains of the policies and groupings changed by the diff
+func (diff PolicyDiff) Domains() (domains []string) {
+
+	seen := map[string]bool{}
+	add_domain := func(domain string) {
+		if !seen[domain] {
+			seen[domain] = true
+			domains = append(domains, domain)
+		}
+	}
+
+	for _, rule := range append(diff.AddPolicies, diff.RemovePolicies...) {
+		add_domain(rule[1])
+	}
+	for _, rule := range append(diff.AddGroupings, diff.RemoveGroupings...) {
+		add_domain(rule[2])
+	}
+
+	return domains
+}
+
+// preview of the diff, one change per line
+func (diff PolicyDiff) Lines() (lines []string) {
+
+	for _, name := range diff.Roles {
+		lines = append(lines, "+ "+policyLine("r", []string{name}))
+	}
+	for _, rule := range diff.AddPolicies {
+		lines = append(lines, "+ "+policyLine("p", rule))
+	}
+	for _, rule := range diff.AddGroupings {
+		lines = append(lines, "+ "+policyLine("g", rule))
+	}
+	for _, rule := range diff.RemovePolicies {
+		lines = append(lines, "- "+policyLine("p", rule))
+	}
+	for _, rule := range diff.RemoveGroupings {
+		lines = append(lines, "- "+policyLine("g", rule))
+	}
+
+	return lines
+}
+
+// groupings between two roles
+func roleGroupings(enforcer *casbin.SyncedEnforcer, roles []role.Role) (groupings [][]string) {
+
+	names := map[string]bool{}
+	for _, db_role := range roles {
+		names[db_role.Name] = true
+	}
+
+	for _, rule := range enforcer.GetGroupingPolicy() {
+		if len(rule) >= 3 && names[rule[0]] {
+			groupings = append(groupings, rule[:3])
+		}
+	}
+
+	return groupings
+}
+
+// rules to add and to remove to go from current to wanted
+func diffRules(current, wanted [][]string) (add, remove [][]string) {
+
+	current_set := map[string]bool{}
+	for _, rule := range current {
+		current_set[strings.Join(rule, "\x00")] = true
+	}
+
+	wanted_set := map[string]bool{}
+	for _, rule := range wanted {
+		key := strings.Join(rule, "\x00")
+		if !current_set[key] && !wanted_set[key] {
+			add = append(add, rule)
+		}
+		wanted_set[key] = true
+	}
+
+	for _, rule := range current {
+		if !wanted_set[strings.Join(rule, "\x00")] {
+			remove = append(remove, rule)
+		}
+	}
+
+	return add, remove
+}
+
+// sorted keys of the map
+func sortedKeys(values map[string][]string) (keys []string) {
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
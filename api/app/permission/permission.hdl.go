@@ -1,19 +1,22 @@
 package permission
 
 import (
+	"bytes"
 	"net/http"
-	"os"
-	"regexp"
 	"strconv"
 
 	"github.com/casbin/casbin/v2"
+	"github.com/ezzddinne/api/app/audit"
+	"github.com/ezzddinne/config"
+	"github.com/ezzddinne/middleware"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type Database struct {
 	DB       *gorm.DB
-	Enforcer *casbin.Enforcer
+	Enforcer *casbin.SyncedEnforcer
+	Config   *config.Config
 }
 
 // create new permission
@@ -21,7 +24,7 @@ func (db Database) NewPermission(ctx *gin.Context) {
 
 	//init vars
 	var permission CasbinRule
-	empty_reg, _ := regexp.Compile(os.Getenv("EMPTY_REGEX"))
+	empty_reg := db.Config.EmptyRegexp()
 
 	// check if the sent content is compatible with CasbinRule
 	if err := ctx.ShouldBindJSON(&permission); err != nil {
@@ -31,13 +34,16 @@ func (db Database) NewPermission(ctx *gin.Context) {
 
 	//unmarshall sent json
 	// check fields
-	if empty_reg.MatchString(permission.V0) || empty_reg.MatchString(permission.V1) || empty_reg.MatchString(permission.V2) {
+	if empty_reg.MatchString(permission.V0) || empty_reg.MatchString(permission.V2) || empty_reg.MatchString(permission.V3) {
 		ctx.JSON(http.StatusBadRequest, gin.H{"message": "invalid fields"})
 		return
 	}
 
+	// permissions without domain belong to the default domain, where the request is authorized
+	permission.V1 = middleware.ResourceDomain(ctx)
+
 	//check the action passed by the user
-	if permission.V2 != "read" && permission.V2 != "write" {
+	if !middleware.IsAction(permission.V3) {
 		ctx.JSON(http.StatusBadRequest, gin.H{"message": "permission is invalid"})
 		return
 	}
@@ -56,20 +62,31 @@ func (db Database) NewPermission(ctx *gin.Context) {
 
 	//Check the role has the policy or not
 	//if not add a policy to it
-	if hasPolicy := db.Enforcer.HasPolicy(permission.V0, permission.V1, permission.V2); !hasPolicy {
-		db.Enforcer.AddPolicy(permission.V0, permission.V1, permission.V2)
+	if hasPolicy := db.Enforcer.HasPolicy(permission.V0, permission.V1, permission.V2, permission.V3); !hasPolicy {
+		db.Enforcer.AddPolicy(permission.V0, permission.V1, permission.V2, permission.V3)
 	}
 
 	//permission created successfully
 	ctx.JSON(http.StatusOK, gin.H{"message": "Permission Created successfuly"})
 
+	// audit the change
+	audit.Record(db.DB, ctx, "permission.create", "role:"+permission.V0, nil, permission)
+
 }
 
 // Get all permissions
 func (db Database) GetAllPermissions(ctx *gin.Context) {
 
-	//Get the permessions
-	permissions, err := GetAllPermissions(db.DB)
+	//init vars
+	var permissions []CasbinRule
+	var err error
+
+	//Get the permessions, filtered by domain if asked
+	if domain := ctx.Query("domain"); domain != "" {
+		permissions, err = GetDomainPermissions(db.DB, domain)
+	} else {
+		permissions, err = GetAllPermissions(db.DB)
+	}
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 		return
@@ -97,7 +114,7 @@ func (db Database) GetPermissionByID(ctx *gin.Context) {
 	}
 
 	//check if the permission is not completed
-	if permission.V0 == "" || permission.V1 == "" || permission.V2 == "" {
+	if permission.V0 == "" || permission.V1 == "" || permission.V2 == "" || permission.V3 == "" {
 		ctx.JSON(http.StatusBadRequest, gin.H{"message": "please complete all fields"})
 		return
 	}
@@ -112,15 +129,23 @@ func (db Database) UpdatePermission(ctx *gin.Context) {
 
 	//init vars
 	var permission CasbinRule
-	empty_reg, _ := regexp.Compile(os.Getenv("EMPTY_REGEX"))
+	empty_reg := db.Config.EmptyRegexp()
 
 	//unmarshall sent json
+	if err := ctx.ShouldBindJSON(&permission); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
 	// check fields
-	if empty_reg.MatchString(permission.V0) || empty_reg.MatchString(permission.V1) || empty_reg.MatchString(permission.V2) {
+	if empty_reg.MatchString(permission.V0) || empty_reg.MatchString(permission.V2) || empty_reg.MatchString(permission.V3) {
 		ctx.JSON(http.StatusBadRequest, gin.H{"message": "invalid fields"})
 		return
 	}
 
+	// permissions without domain belong to the default domain, where the request is authorized
+	permission.V1 = middleware.ResourceDomain(ctx)
+
 	//check role exists
 	role, err := CheckRoleExists(db.DB, permission.V0)
 	if err != nil {
@@ -129,7 +154,7 @@ func (db Database) UpdatePermission(ctx *gin.Context) {
 	}
 
 	//check the action passed by the user
-	if permission.V2 != "read" && permission.V2 != "write" {
+	if !middleware.IsAction(permission.V3) {
 		ctx.JSON(http.StatusBadRequest, gin.H{"message": "permission is invalid"})
 		return
 	}
@@ -156,16 +181,29 @@ func (db Database) UpdatePermission(ctx *gin.Context) {
 
 	//Check the permission it's a policy role
 	// check if it is a policy role
-	if permission.V0 == "" || permission.V1 == "" {
+	if permission.V0 == "" || permission.V2 == "" {
 		ctx.JSON(http.StatusBadRequest, gin.H{"message": "permission not found"})
 		return
 	}
 
+	// the permission is moved out of its current domain too
+	if allowed, err := middleware.AuthorizedIn(ctx, db.Enforcer, db_permission.V1, "permissions", "write"); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Error occurred when authorizing user"})
+		return
+	} else if !allowed {
+		ctx.JSON(http.StatusForbidden, gin.H{"message": "You are not authorized"})
+		return
+	}
+
 	//update the policy
 	//check if they have the same object
-	if permission.V1 == db_permission.V1 {
-		db.Enforcer.UpdatePolicy([]string{db_permission.V0, db_permission.V1, db_permission.V2}, []string{permission.V0, permission.V1, permission.V2})
+	if permission.V2 == db_permission.V2 {
+		db.Enforcer.UpdatePolicy([]string{db_permission.V0, db_permission.V1, db_permission.V2, db_permission.V3}, []string{permission.V0, permission.V1, permission.V2, permission.V3})
 		ctx.JSON(http.StatusOK, gin.H{"message": "permission updated successfully"})
+
+		// audit the change
+		permission.ID = db_permission.ID
+		audit.Record(db.DB, ctx, "permission.update", "role:"+permission.V0, db_permission, permission)
 	} else {
 		ctx.JSON(http.StatusBadRequest, gin.H{"message": "invalid edit"})
 	}
@@ -189,17 +227,210 @@ func (db Database) DeletePermission(ctx *gin.Context) {
 	}
 
 	// check if it is a policy role
-	if permission.V0 == "" || permission.V1 == "" {
+	if permission.V0 == "" || permission.V2 == "" {
 		ctx.JSON(http.StatusBadRequest, gin.H{"message": "permission not found"})
 		return
 	}
 
 	// delete the row in the table
-	_, err = db.Enforcer.RemovePolicy(permission.V0, permission.V1, permission.V2)
+	_, err = db.Enforcer.RemovePolicy(permission.V0, permission.V1, permission.V2, permission.V3)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{"message": "permission removed successfully"})
+
+	// audit the change
+	audit.Record(db.DB, ctx, "permission.delete", "role:"+permission.V0, permission, nil)
+}
+
+// Get the permissions of the caller
+// @Security bearerAuth
+// @Summary My permissions
+// @Description This method returns the object/action pairs allowed to the caller in the domain of the request.
+// @Tags App
+// @Produce json
+// @Param X-Event header string false "Domain"
+// @Schemes
+// @Success 200 {object} permission.EffectivePermissions
+// @Failure 401 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /app/me/permissions [get]
+func (db Database) GetMyPermissions(ctx *gin.Context) {
+
+	// get the subject of the caller
+	subject := ctx.GetString("subject")
+	if subject == "" {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"message": "You are not authorized"})
+		return
+	}
+
+	// get the effective permissions
+	effective, err := GetEffectivePermissions(db.Enforcer, db.Config.RBAC.DefaultRoot, subject, middleware.RequestDomain(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, effective)
+}
+
+// Explain an authorization decision
+// @Security bearerAuth
+// @Summary Explain permission
+// @Description This method returns the decision for the subject, object and action with the matching or missing policy lines.
+// @Tags App
+// @Accept json
+// @Produce json
+// @Param request body ExplainInput true "Request to explain"
+// @Schemes
+// @Success 200 {object} permission.Explanation
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /app/permission/explain [post]
+func (db Database) ExplainPermission(ctx *gin.Context) {
+
+	//init vars
+	var input ExplainInput
+
+	//Unmarshal sent json
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// requests without domain are checked in the default domain
+	input.Domain = middleware.ResourceDomain(ctx)
+
+	//check the action passed by the user
+	if !middleware.IsAction(input.Action) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "action is invalid"})
+		return
+	}
+
+	// explain the decision
+	explanation, err := ExplainDecision(db.Enforcer, db.Config.RBAC.DefaultRoot, input)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, explanation)
+}
+
+// Export the policies
+// @Security bearerAuth
+// @Summary Export permissions
+// @Description This method exports the roles, inheritance and permissions as a yaml or csv policy file.
+// @Tags App
+// @Produce plain
+// @Param format query string false "yaml or csv"
+// @Schemes
+// @Success 200 {string} string "Policy file"
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /app/permission/export [get]
+func (db Database) ExportPermissions(ctx *gin.Context) {
+
+	// get the format
+	format := ctx.DefaultQuery("format", FormatYAML)
+	if format != FormatYAML && format != FormatCSV {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "format is invalid"})
+		return
+	}
+
+	// export the current policies
+	file, err := ExportPolicies(db.DB, db.Enforcer)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	var buffer bytes.Buffer
+	if err := WritePolicyFile(&buffer, file, format); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.Header("Content-Disposition", "attachment; filename=policies."+format)
+	ctx.Data(http.StatusOK, "text/"+format+"; charset=utf-8", buffer.Bytes())
+}
+
+// Import a policy file
+// @Security bearerAuth
+// @Summary Import permissions
+// @Description This method applies a yaml or csv policy file, the changes are only previewed with dry_run and the rules missing from the file are removed with prune.
+// @Tags App
+// @Accept plain
+// @Produce json
+// @Param format query string false "yaml or csv"
+// @Param dry_run query bool false "Preview the changes"
+// @Param prune query bool false "Remove the rules missing from the file"
+// @Schemes
+// @Success 200 {object} gin.H
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /app/permission/import [post]
+func (db Database) ImportPermissions(ctx *gin.Context) {
+
+	// get the sent file
+	data, err := ctx.GetRawData()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	file, err := ParsePolicyFile(data, ctx.DefaultQuery("format", FormatYAML))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// compare with the current policies
+	diff, err := DiffPolicies(db.DB, db.Enforcer, file, ctx.Query("prune") == "true")
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	// the whole file is refused if a change is invalid
+	if err := ValidatePolicies(db.Enforcer, diff); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// the caller writes the permissions of every domain changed by the file
+	for _, domain := range diff.Domains() {
+		if allowed, err := middleware.AuthorizedIn(ctx, db.Enforcer, domain, "permissions", "write"); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Error occurred when authorizing user"})
+			return
+		} else if !allowed {
+			ctx.JSON(http.StatusForbidden, gin.H{"message": "You are not authorized in the domain " + domain})
+			return
+		}
+	}
+
+	// preview only
+	if ctx.Query("dry_run") == "true" || diff.Empty() {
+		audit.SkipAudit(ctx)
+		ctx.JSON(http.StatusOK, gin.H{"applied": false, "changes": diff.Lines()})
+		return
+	}
+
+	if err := ApplyPolicies(db.DB, db.Enforcer, diff); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"applied": true, "changes": diff.Lines()})
+
+	// audit the change
+	audit.Record(db.DB, ctx, "permission.import", "policies", nil, diff)
 }
@@ -0,0 +1,159 @@
+package permission
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/ezzddinne/middleware"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// format a rule as a line of the policy file
+func policyLine(ptype string, rule []string) string {
+	return strings.Join(append([]string{ptype}, rule...), ", ")
+}
+
+// the permission of the route belongs to its domain
+func PermissionDomain(db *gorm.DB) middleware.DomainResolver {
+	return func(ctx *gin.Context) (string, error) {
+
+		permission_id, err := strconv.Atoi(ctx.Param("id"))
+		if err != nil {
+			return "", gorm.ErrRecordNotFound
+		}
+
+		permission, err := GetPermissionByID(db, uint(permission_id))
+		if err != nil {
+			return "", err
+		}
+		if permission.ID == 0 {
+			return "", gorm.ErrRecordNotFound
+		}
+		return permission.V1, nil
+	}
+}
+
+// check if the subject inherits the root role in the domain
+func isRoot(enforcer *casbin.SyncedEnforcer, root, subject, domain string) (bool, []string, error) {
+
+	roles, err := enforcer.GetImplicitRolesForUser(subject, domain)
+	if err != nil {
+		return false, nil, err
+	}
+
+	for _, role := range roles {
+		if role == root {
+			return true, roles, nil
+		}
+	}
+
+	return false, roles, nil
+}
+
+// every object/action pair of the policies allowed to the subject in the domain
+func GetEffectivePermissions(enforcer *casbin.SyncedEnforcer, root, subject, domain string) (effective EffectivePermissions, err error) {
+
+	//init vars
+	effective = EffectivePermissions{Subject: subject, Domain: domain, Permissions: []Permission{}}
+	seen := map[Permission]bool{}
+
+	if effective.Root, _, err = isRoot(enforcer, root, subject, domain); err != nil {
+		return effective, err
+	}
+
+	// policies are p, role, domain, object, action
+	for _, rule := range enforcer.GetPolicy() {
+		if len(rule) < 4 {
+			continue
+		}
+
+		permission := Permission{Object: rule[2], Action: rule[3]}
+		if seen[permission] {
+			continue
+		}
+		seen[permission] = true
+
+		allowed, err := enforcer.Enforce(subject, domain, permission.Object, permission.Action)
+		if err != nil {
+			return effective, err
+		}
+		if allowed {
+			effective.Permissions = append(effective.Permissions, permission)
+		}
+	}
+
+	sort.Slice(effective.Permissions, func(i, j int) bool {
+		if effective.Permissions[i].Object != effective.Permissions[j].Object {
+			return effective.Permissions[i].Object < effective.Permissions[j].Object
+		}
+		return effective.Permissions[i].Action < effective.Permissions[j].Action
+	})
+
+	return effective, nil
+}
+
+// explain the decision of the enforcer for the request
+func ExplainDecision(enforcer *casbin.SyncedEnforcer, root_role string, input ExplainInput) (explanation Explanation, err error) {
+
+	//init vars
+	explanation = Explanation{
+		Subject:   input.Subject,
+		Domain:    input.Domain,
+		Object:    input.Object,
+		Action:    input.Action,
+		Groupings: []string{},
+		Matched:   []string{},
+		Missing:   []string{},
+	}
+
+	root, roles, err := isRoot(enforcer, root_role, input.Subject, input.Domain)
+	if err != nil {
+		return explanation, err
+	}
+	explanation.Roles = roles
+	if explanation.Roles == nil {
+		explanation.Roles = []string{}
+	}
+
+	// direct roles of the subject, the root ones are kept to explain the decision
+	var root_lines []string
+	for _, rule := range enforcer.GetFilteredGroupingPolicy(0, input.Subject) {
+		explanation.Groupings = append(explanation.Groupings, policyLine("g", rule))
+		if len(rule) > 1 && rule[1] == root_role {
+			root_lines = append(root_lines, policyLine("g", rule))
+		}
+	}
+
+	allowed, matched, err := enforcer.EnforceEx(input.Subject, input.Domain, input.Object, input.Action)
+	if err != nil {
+		return explanation, err
+	}
+	explanation.Allowed = allowed
+
+	// root is allowed by the matcher, not by a policy line
+	if root {
+		if len(root_lines) == 0 {
+			root_lines = []string{policyLine("g", []string{input.Subject, root_role, input.Domain})}
+		}
+		explanation.Matched = root_lines
+		return explanation, nil
+	}
+
+	if allowed {
+		explanation.Matched = append(explanation.Matched, policyLine("p", matched))
+		return explanation, nil
+	}
+
+	// any of these lines would allow the request
+	if len(roles) == 0 {
+		roles = []string{input.Subject}
+	}
+	for _, role := range roles {
+		explanation.Missing = append(explanation.Missing, policyLine("p", []string{role, input.Domain, input.Object, input.Action}))
+	}
+
+	return explanation, nil
+}
@@ -6,10 +6,12 @@ import (
 )
 
 type CasbinRule struct {
-	ID uint   `gorm:"column:id" json:"id"`
-	V0 string `gorm:"column:role" json:"role"`
-	V1 string `gorm:"column:object" json:"object"`
-	V2 string `gorm:"column:action" json:"action"`
+	ID    uint   `gorm:"column:id" json:"id"`
+	Ptype string `gorm:"column:ptype;size:100" json:"-"`
+	V0    string `gorm:"column:v0;size:100" json:"role"`
+	V1    string `gorm:"column:v1;size:100" json:"domain"`
+	V2    string `gorm:"column:v2;size:100" json:"object"`
+	V3    string `gorm:"column:v3;size:100" json:"action"`
 }
 
 // Get all permissions
@@ -17,6 +19,11 @@ func GetAllPermissions(db *gorm.DB) (permissions []CasbinRule, err error) {
 	return permissions,db.Table("casbin_rule").Find(&permissions, "ptype = ?", "p").Error
 }
 
+// Get the permissions of a domain
+func GetDomainPermissions(db *gorm.DB, domain string) (permissions []CasbinRule, err error) {
+	return permissions, db.Table("casbin_rule").Find(&permissions, "ptype = ? AND v1 = ?", "p", domain).Error
+}
+
 // Get premission by id
 func GetPermissionByID(db *gorm.DB, id uint) (permission CasbinRule, err error) {
 	return permission, db.Table("casbin_rule").Where("id = ? AND ptype ='p'", id).Find(&permission).Error
@@ -24,10 +31,45 @@ func GetPermissionByID(db *gorm.DB, id uint) (permission CasbinRule, err error)
 
 // Check role exist in permissions
 func CheckRoleInPermissions(db *gorm.DB, role_name string) (role role.Role, err error) {
-	return role, db.Table("casbin_rule").Where("role = ?", role_name).Find(&role).Error
+	return role, db.Table("casbin_rule").Where("v0 = ?", role_name).Find(&role).Error
 }
 
 // Check role exist
 func CheckRoleExists(db *gorm.DB, name string) (role role.Role, err error) {
 	return role, db.Table("roles").Where("name = ?", name).Find(&role).Error
 }
+
+// object/action pair granted to a subject
+type Permission struct {
+	Object string `json:"object"`
+	Action string `json:"action"`
+}
+
+// permissions of a subject in a domain
+type EffectivePermissions struct {
+	Subject     string       `json:"subject"`
+	Domain      string       `json:"domain"`
+	Root        bool         `json:"root"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// authorization request to explain
+type ExplainInput struct {
+	Subject string `json:"subject" binding:"required"`
+	Domain  string `json:"domain"`
+	Object  string `json:"object" binding:"required"`
+	Action  string `json:"action" binding:"required"`
+}
+
+// decision and the policy lines behind it
+type Explanation struct {
+	Allowed   bool     `json:"allowed"`
+	Subject   string   `json:"subject"`
+	Domain    string   `json:"domain"`
+	Object    string   `json:"object"`
+	Action    string   `json:"action"`
+	Roles     []string `json:"roles"`
+	Groupings []string `json:"groupings"`
+	Matched   []string `json:"matched"`
+	Missing   []string `json:"missing"`
+}
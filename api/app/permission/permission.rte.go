@@ -2,27 +2,46 @@ package permission
 
 import (
 	"github.com/casbin/casbin/v2"
+	"github.com/ezzddinne/api/app/audit"
+	"github.com/ezzddinne/config"
 	"github.com/ezzddinne/middleware"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
-func RoutesPermissions(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.Enforcer) {
+func RoutesPermissions(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.SyncedEnforcer, cfg *config.Config) {
 
-	baseInstance := Database{DB: db, Enforcer: enforcer}
+	baseInstance := Database{DB: db, Enforcer: enforcer, Config: cfg}
 
-	// create permission route
-	router.POST("/new", middleware.Authorize("permissions", "write", enforcer), baseInstance.NewPermission)
+	// create permission route, authorized in the domain of the permission
+	router.POST("/new", middleware.InDomain(middleware.BodyDomain(middleware.DefaultDomain())), middleware.Authorize("permissions", "write", enforcer), baseInstance.NewPermission)
+
+	// explain permission route
+	router.POST("/explain", middleware.InDomain(middleware.BodyDomain(middleware.DefaultDomain())), middleware.Authorize("permissions", "read", enforcer), audit.ReadOnly(), baseInstance.ExplainPermission)
+
+	// export permissions route
+	router.GET("/export", middleware.Authorize("permissions", "read", enforcer), baseInstance.ExportPermissions)
+
+	// import permissions route, every domain of the file is checked by the handler
+	router.POST("/import", middleware.Authorize("permissions", "write", enforcer), middleware.DenyImpersonation(), baseInstance.ImportPermissions)
 
 	// get all permission route
 	router.GET("/all", middleware.Authorize("permissions", "read", enforcer), baseInstance.GetAllPermissions)
 
 	// get permission by id route
-	router.GET("/:id", middleware.Authorize("permissions", "read", enforcer), baseInstance.GetPermissionByID)
+	router.GET("/:id", middleware.InDomain(PermissionDomain(db)), middleware.Authorize("permissions", "read", enforcer), baseInstance.GetPermissionByID)
 
-	// update permission route
-	router.PUT("/:id", middleware.Authorize("permissions", "write", enforcer), baseInstance.UpdatePermission)
+	// update permission route, the current domain is checked by the handler
+	router.PUT("/:id", middleware.InDomain(middleware.BodyDomain(middleware.DefaultDomain())), middleware.Authorize("permissions", "write", enforcer), baseInstance.UpdatePermission)
 
 	// delete permission route
-	router.DELETE("/:id", middleware.Authorize("permissions", "write", enforcer), baseInstance.DeletePermission)
+	router.DELETE("/:id", middleware.InDomain(PermissionDomain(db)), middleware.Authorize("permissions", "delete", enforcer), baseInstance.DeletePermission)
+}
+
+func RoutesMyPermissions(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.SyncedEnforcer, cfg *config.Config) {
+
+	baseInstance := Database{DB: db, Enforcer: enforcer, Config: cfg}
+
+	// get my permissions route
+	router.GET("/permissions", baseInstance.GetMyPermissions)
 }
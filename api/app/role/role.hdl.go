@@ -1,19 +1,24 @@
 package role
 
 import (
+	"errors"
+	"log"
 	"net/http"
-	"os"
-	"regexp"
 	"strconv"
 
 	"github.com/casbin/casbin/v2"
+	"github.com/ezzddinne/api/app/audit"
+	"github.com/ezzddinne/api/user"
+	"github.com/ezzddinne/config"
+	"github.com/ezzddinne/middleware"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type Database struct {
 	DB       *gorm.DB
-	Enforcer *casbin.Enforcer
+	Enforcer *casbin.SyncedEnforcer
+	Config   *config.Config
 }
 
 // Create Role
@@ -21,7 +26,7 @@ func (db Database) NewRole(ctx *gin.Context) {
 
 	//init vars
 	var role Role
-	empty_reg, _ := regexp.Compile(os.Getenv("EMPTY_REGEX"))
+	empty_reg := db.Config.EmptyRegexp()
 
 	//Unmarshal sent json
 	if err := ctx.ShouldBindJSON(&role); err != nil {
@@ -49,6 +54,9 @@ func (db Database) NewRole(ctx *gin.Context) {
 
 	//Role Created successfully
 	ctx.JSON(http.StatusOK, gin.H{"message": "Role Created successfully"})
+
+	// audit the change
+	audit.Record(db.DB, ctx, "role.create", "role:"+new_role.Name, nil, new_role)
 }
 
 // Get all Roles
@@ -71,7 +79,7 @@ func (db Database) UpdateRole(ctx *gin.Context) {
 
 	//init vars
 	var role Role
-	empty_reg, _ := regexp.Compile(os.Getenv("EMPTY_REGEX"))
+	empty_reg := db.Config.EmptyRegexp()
 
 	//Unmarshal sent json
 	if err := ctx.ShouldBindJSON(&role); err != nil {
@@ -89,10 +97,15 @@ func (db Database) UpdateRole(ctx *gin.Context) {
 	role_id, err := strconv.Atoi(ctx.Param("id"))
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
 	}
 
-	//session information
-	//extract the user id from the session
+	//get the role before the change
+	db_role, err := GetRoleByID(db.DB, uint(role_id))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
 
 	//Create new role instance
 	updated_role := Role{
@@ -109,6 +122,9 @@ func (db Database) UpdateRole(ctx *gin.Context) {
 
 	//Role updated successfully
 	ctx.JSON(http.StatusOK, gin.H{"message": "Role updated successfully"})
+
+	// audit the change
+	audit.Record(db.DB, ctx, "role.update", "role:"+db_role.Name, db_role, updated_role)
 }
 
 // Delete the Role
@@ -122,6 +138,13 @@ func (db Database) DeleteRole(ctx *gin.Context) {
 		return
 	}
 
+	//get the role before the change
+	db_role, err := GetRoleByID(db.DB, uint(role_id))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
 	//Check the role deleted successfully
 	if err = DeleteRole(db.DB, uint(role_id)); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
@@ -131,4 +154,428 @@ func (db Database) DeleteRole(ctx *gin.Context) {
 	//Role Deleted successfully
 	ctx.JSON(http.StatusOK, gin.H{"message": "Role deleted successfully"})
 
+	// audit the change
+	audit.Record(db.DB, ctx, "role.delete", "role:"+db_role.Name, db_role, nil)
+
+}
+
+// Assign roles to user
+// @Security bearerAuth
+// @Summary Assign user roles
+// @Description This method replaces the roles of the user in the domain, its sessions are revoked so the next token carries the new roles. The main role of the user follows the default domain only. The root role and the roles inheriting it are assigned by root only, the other roles by a caller holding them in the domain.
+// @Tags App
+// @Accept json
+// @Produce json
+// @Param id path uint true "User ID"
+// @Param request body RolesInput true "Roles"
+// @Schemes
+// @Success 200 {string} string "Roles assigned"
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /app/users/{id}/roles [put]
+func (db Database) AssignUserRoles(ctx *gin.Context) {
+
+	//init vars
+	var input RolesInput
+
+	//Unmarshal sent json
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// get the user id
+	user_id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// get the user
+	dbUser, err := user.GetUserByID(db.DB, uint(user_id))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// check the roles exist
+	var roles []string
+	seen := map[string]bool{}
+	for _, name := range input.Roles {
+		if seen[name] {
+			continue
+		}
+		if _, err := GetRoleByName(db.DB, name); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"message": "role " + name + " not found"})
+			return
+		}
+		seen[name] = true
+		roles = append(roles, name)
+	}
+
+	if len(roles) == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "please select at least one role"})
+		return
+	}
+
+	// roles are assigned in the domain the request is authorized in, the default domain if none is given
+	input.Domain = middleware.ResourceDomain(ctx)
+
+	// the user can't get more than the caller carries
+	for _, name := range roles {
+		if err := canAssignRole(db.Enforcer, db.Config.RBAC.DefaultRoot, ctx.GetString("subject"), name, input.Domain); err != nil {
+			ctx.JSON(http.StatusForbidden, gin.H{"message": err.Error()})
+			return
+		}
+	}
+
+	// roles before the change
+	subject := middleware.Subject(dbUser.ID)
+	var old_roles []string
+	for _, rule := range db.Enforcer.GetFilteredGroupingPolicy(0, subject, "", input.Domain) {
+		old_roles = append(old_roles, rule[1])
+	}
+
+	// update the user, its sessions and the casbin groupings together
+	// the groupings are written last, they are restored if the transaction fails to commit
+	replaced := false
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+
+		// the first role of the default domain is the main role of the user
+		if input.Domain == middleware.DefaultDomain() {
+			if err := tx.Model(&user.User{}).Where("id = ?", dbUser.ID).Update("role", roles[0]).Error; err != nil {
+				return err
+			}
+		}
+
+		// force the user to get a new token
+		if err := user.RevokeAllSessions(tx, dbUser.ID); err != nil {
+			return err
+		}
+
+		if err := replaceGroupings(db.Enforcer, subject, input.Domain, old_roles, roles); err != nil {
+			return err
+		}
+		replaced = true
+		return nil
+	})
+	if err != nil {
+		if replaced {
+			if err := replaceGroupings(db.Enforcer, subject, input.Domain, roles, old_roles); err != nil {
+				log.Println("[WARNING] failed to restore the roles of user", dbUser.ID, ":", err)
+			}
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Roles assigned successfully"})
+
+	// audit the change
+	audit.Record(db.DB, ctx, "user.roles", "user:"+strconv.Itoa(user_id), gin.H{"domain": input.Domain, "roles": old_roles}, gin.H{"domain": input.Domain, "roles": roles})
+}
+
+// replace the roles of the subject in the domain, the old roles are restored on failure
+func replaceGroupings(enforcer *casbin.SyncedEnforcer, subject, domain string, old_roles, roles []string) error {
+
+	//init vars
+	var rules [][]string
+	for _, name := range roles {
+		rules = append(rules, []string{subject, name, domain})
+	}
+
+	if _, err := enforcer.RemoveFilteredGroupingPolicy(0, subject, "", domain); err != nil {
+		return err
+	}
+
+	if len(rules) == 0 {
+		return nil
+	}
+
+	if _, err := enforcer.AddGroupingPolicies(rules); err != nil {
+
+		// restore the old roles
+		enforcer.RemoveFilteredGroupingPolicy(0, subject, "", domain)
+		for _, name := range old_roles {
+			enforcer.AddGroupingPolicy(subject, name, domain)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Get role parents
+// @Security bearerAuth
+// @Summary Role parents
+// @Description This method returns the direct parents of the role and every role it inherits.
+// @Tags App
+// @Produce json
+// @Param id path uint true "Role ID"
+// @Schemes
+// @Success 200 {object} role.RoleHierarchy
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /app/role/{id}/parents [get]
+func (db Database) GetRoleParents(ctx *gin.Context) {
+
+	// get the role id
+	role_id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// get the role
+	db_role, err := GetRoleByID(db.DB, uint(role_id))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// inheritance applies to every domain if none is given
+	domain := middleware.ResourceDomain(ctx)
+
+	parents, err := db.Enforcer.GetRolesForUser(db_role.Name, domain)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	inherits, err := db.Enforcer.GetImplicitRolesForUser(db_role.Name, domain)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, RoleHierarchy{Role: db_role.Name, Domain: domain, Parents: parents, Inherits: inherits})
+}
+
+// Add role parent
+// @Security bearerAuth
+// @Summary Add role parent
+// @Description This method makes the role inherit the permissions of the parent role.
+// @Tags App
+// @Accept json
+// @Produce json
+// @Param id path uint true "Role ID"
+// @Param request body ParentInput true "Parent role"
+// @Schemes
+// @Success 200 {string} string "Parent added"
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /app/role/{id}/parents [post]
+func (db Database) AddRoleParent(ctx *gin.Context) {
+
+	//init vars
+	var input ParentInput
+
+	//Unmarshal sent json
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// get the role id
+	role_id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// get the role and its parent
+	db_role, err := GetRoleByID(db.DB, uint(role_id))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	parent, err := GetRoleByName(db.DB, input.Parent)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "parent role not found"})
+		return
+	}
+
+	// inheritance applies to every domain if none is given
+	input.Domain = middleware.ResourceDomain(ctx)
+
+	// the parent can't already inherit the role
+	if cycle, err := createsCycle(db.Enforcer, db_role.Name, parent.Name, input.Domain); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	} else if cycle {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "role inheritance cycle detected"})
+		return
+	}
+
+	if _, err := db.Enforcer.AddGroupingPolicy(db_role.Name, parent.Name, input.Domain); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Role parent added successfully"})
+
+	// audit the change
+	audit.Record(db.DB, ctx, "role.parent.add", "role:"+db_role.Name, nil, gin.H{"parent": parent.Name, "domain": input.Domain})
+}
+
+// Remove role parent
+// @Security bearerAuth
+// @Summary Remove role parent
+// @Description This method stops the role from inheriting the parent role.
+// @Tags App
+// @Produce json
+// @Param id path uint true "Role ID"
+// @Param parent path string true "Parent role name"
+// @Schemes
+// @Success 200 {string} string "Parent removed"
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /app/role/{id}/parents/{parent} [delete]
+func (db Database) DeleteRoleParent(ctx *gin.Context) {
+
+	// get the role id
+	role_id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// get the role
+	db_role, err := GetRoleByID(db.DB, uint(role_id))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// inheritance applies to every domain if none is given
+	domain := middleware.ResourceDomain(ctx)
+
+	removed, err := db.Enforcer.RemoveGroupingPolicy(db_role.Name, ctx.Param("parent"), domain)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	if !removed {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "parent role not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Role parent removed successfully"})
+
+	// audit the change
+	audit.Record(db.DB, ctx, "role.parent.delete", "role:"+db_role.Name, gin.H{"parent": ctx.Param("parent"), "domain": domain}, nil)
+}
+
+// check if the role inheriting the parent creates a cycle
+func createsCycle(enforcer *casbin.SyncedEnforcer, role_name, parent, domain string) (bool, error) {
+	return CreatesCycle(enforcer.GetGroupingPolicy(), role_name, parent, domain), nil
+}
+
+// check if the role inheriting the parent creates a cycle in the groupings
+// the inheritance granted in "*" applies in every domain, so it is walked with the domain
+// and an inheritance added in "*" is checked in every domain
+func CreatesCycle(groupings [][]string, role_name, parent, domain string) bool {
+
+	if role_name == parent {
+		return true
+	}
+
+	domains := []string{domain}
+	if domain == middleware.AllDomains {
+		seen := map[string]bool{domain: true}
+		for _, rule := range groupings {
+			if len(rule) >= 3 && !seen[rule[2]] {
+				seen[rule[2]] = true
+				domains = append(domains, rule[2])
+			}
+		}
+	}
+
+	for _, checked := range domains {
+		if inheritsRole(groupings, parent, role_name, checked) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// check if the role already inherits the other role in the domain, through the domain and "*"
+func inheritsRole(groupings [][]string, role_name, inherited, domain string) bool {
+
+	parents := map[string][]string{}
+	for _, rule := range groupings {
+		if len(rule) >= 3 && (rule[2] == domain || rule[2] == middleware.AllDomains) {
+			parents[rule[0]] = append(parents[rule[0]], rule[1])
+		}
+	}
+
+	seen := map[string]bool{role_name: true}
+	queue := []string{role_name}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, parent := range parents[name] {
+			if parent == inherited {
+				return true
+			}
+			if !seen[parent] {
+				seen[parent] = true
+				queue = append(queue, parent)
+			}
+		}
+	}
+
+	return false
+}
+
+// the root role and the roles inheriting it are only assigned by root in every domain
+// the other roles only by root or by a caller holding the role in the domain
+func canAssignRole(enforcer *casbin.SyncedEnforcer, root, subject, role_name, domain string) error {
+
+	everywhere, err := enforcer.GetImplicitRolesForUser(subject, middleware.AllDomains)
+	if err != nil {
+		return err
+	}
+	for _, name := range everywhere {
+		if name == root {
+			return nil
+		}
+	}
+
+	if role_name == root {
+		return errors.New("only root can assign the root role")
+	}
+
+	inherits, err := enforcer.GetImplicitRolesForUser(role_name, domain)
+	if err != nil {
+		return err
+	}
+	for _, name := range inherits {
+		if name == root {
+			return errors.New("only root can assign a role inheriting the root role")
+		}
+	}
+
+	held, err := enforcer.GetImplicitRolesForUser(subject, domain)
+	if err != nil {
+		return err
+	}
+	for _, name := range held {
+		if name == role_name || name == root {
+			return nil
+		}
+	}
+
+	return errors.New("you can only assign a role you hold in the domain")
 }
@@ -10,6 +10,23 @@ type Role struct {
 	gorm.Model
 }
 
+type ParentInput struct {
+	Parent string `json:"parent" binding:"required"`
+	Domain string `json:"domain"`
+}
+
+type RoleHierarchy struct {
+	Role     string   `json:"role"`
+	Domain   string   `json:"domain"`
+	Parents  []string `json:"parents"`
+	Inherits []string `json:"inherits"`
+}
+
+type RolesInput struct {
+	Roles  []string `json:"roles" binding:"required"`
+	Domain string   `json:"domain"`
+}
+
 //Create new role
 func NewRole(db *gorm.DB, role Role) error {
 	return db.Create(&role).Error
@@ -25,13 +42,18 @@ func GetRoleByName(db *gorm.DB, name string) (role Role, err error) {
 	return role, db.Where("name = ?",name).First(&role).Error
 }
 
+//Get Role By id
+func GetRoleByID(db *gorm.DB, role_id uint) (role Role, err error) {
+	return role, db.Where("id = ?", role_id).First(&role).Error
+}
+
 //Update role
 func UpdateRole(db *gorm.DB, role Role) error {
-	return db.Where("role_id = ?",role.ID).Updates(&role).Error
+	return db.Where("id = ?",role.ID).Updates(&role).Error
 }
 
 //Delete role
 func DeleteRole(db *gorm.DB, role_id uint) error {
-	return db.Where("role_id = ?",role_id).Delete(&Role{}).Error
+	return db.Where("id = ?",role_id).Delete(&Role{}).Error
 }
 
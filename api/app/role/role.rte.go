@@ -2,14 +2,15 @@ package role
 
 import (
 	"github.com/casbin/casbin/v2"
+	"github.com/ezzddinne/config"
 	"github.com/ezzddinne/middleware"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
-func RoutesRoles(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.Enforcer) {
+func RoutesRoles(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.SyncedEnforcer, cfg *config.Config) {
 
-	baseInstance := Database{DB: db, Enforcer: enforcer}
+	baseInstance := Database{DB: db, Enforcer: enforcer, Config: cfg}
 
 	// create role route
 	router.POST("/new", middleware.Authorize("roles", "write", enforcer), baseInstance.NewRole)
@@ -21,6 +22,23 @@ func RoutesRoles(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.Enforcer
 	router.PUT("/:id", middleware.Authorize("roles", "write", enforcer), baseInstance.UpdateRole)
 
 	// delete role route
-	router.DELETE("/:id", middleware.Authorize("roles", "write", enforcer), baseInstance.DeleteRole)
+	router.DELETE("/:id", middleware.Authorize("roles", "delete", enforcer), baseInstance.DeleteRole)
 
+	// get role parents route
+	router.GET("/:id/parents", middleware.InDomain(middleware.QueryDomain(middleware.AllDomains)), middleware.Authorize("roles", "read", enforcer), baseInstance.GetRoleParents)
+
+	// add role parent route, authorized in the domain of the inheritance
+	router.POST("/:id/parents", middleware.InDomain(middleware.BodyDomain(middleware.AllDomains)), middleware.Authorize("roles", "write", enforcer), baseInstance.AddRoleParent)
+
+	// remove role parent route
+	router.DELETE("/:id/parents/:parent", middleware.InDomain(middleware.QueryDomain(middleware.AllDomains)), middleware.Authorize("roles", "write", enforcer), baseInstance.DeleteRoleParent)
+
+}
+
+func RoutesUserRoles(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.SyncedEnforcer, cfg *config.Config) {
+
+	baseInstance := Database{DB: db, Enforcer: enforcer, Config: cfg}
+
+	// assign user roles route, authorized in the domain of the roles
+	router.PUT("/:id/roles", middleware.InDomain(middleware.BodyDomain(middleware.DefaultDomain())), middleware.Authorize("roles", "write", enforcer), middleware.DenyImpersonation(), baseInstance.AssignUserRoles)
 }
@@ -0,0 +1,93 @@
+package file
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/ezzddinne/api/user"
+	"github.com/ezzddinne/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// content types of the files answering the registration form
+var answerContentTypes = []string{"application/pdf", "image/png", "image/jpeg"}
+
+// Upload answer file
+// @Security bearerAuth
+// @Summary Upload a file answering the registration form
+// @Description This method uploads a pdf, png or jpeg file, its id is the answer of a file field.
+// @Tags File
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "Answer file"
+// @Schemes
+// @Success 200 {object} file.File
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 413 {object} gin.H
+// @Failure 422 {object} gin.H
+// @Router /files/answers [post]
+func (db Database) UploadAnswerFile(ctx *gin.Context) {
+
+	// the answer files are limited as the cvs
+	max_size := db.Config.Upload.CVMaxBytes()
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, max_size+MultipartOverhead)
+
+	formFile, formHeader, err := ctx.Request.FormFile("file")
+	if err != nil {
+		if UploadErrorStatus(err) == http.StatusRequestEntityTooLarge {
+			ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{"message": ErrTooLarge.Error()})
+			return
+		}
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Select a file to upload"})
+		return
+	}
+	defer formFile.Close()
+
+	data, err := ReadLimited(formFile, max_size)
+	if err != nil {
+		ctx.JSON(UploadErrorStatus(err), gin.H{"message": err.Error()})
+		return
+	}
+
+	if SniffContentType(data) == "application/pdf" {
+		if err := CheckPDF(bytes.NewReader(data), int64(len(data))); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+	}
+
+	//get values
+	session := middleware.ExtractTokenValues(ctx)
+
+	dbUser, err := user.GetUserByID(db.DB, session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	uploaded, err := Store(ctx.Request.Context(), db.DB, db.Storage, Upload{
+		Kind:         KindAnswer,
+		Name:         formHeader.Filename,
+		SquadID:      dbUser.SquadID,
+		UploadedBy:   dbUser.ID,
+		Content:      bytes.NewReader(data),
+		ContentTypes: answerContentTypes,
+		MaxSize:      max_size,
+		Scanner:      db.Scanner,
+	})
+	if err != nil {
+		ctx.JSON(UploadErrorStatus(err), gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, uploaded)
+}
+
+// the file being uploaded will be owned by the user
+func OwnsNewAnswer() middleware.OwnerResolver {
+	return func(ctx *gin.Context, user_id uint) (bool, error) {
+		return user_id != 0, nil
+	}
+}
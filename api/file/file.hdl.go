@@ -0,0 +1,224 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/ezzddinne/config"
+	"github.com/ezzddinne/middleware"
+	"github.com/ezzddinne/scanner"
+	"github.com/ezzddinne/storage"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type Database struct {
+	DB       *gorm.DB
+	Enforcer *casbin.SyncedEnforcer
+	Config   *config.Config
+	Storage  storage.Storage
+	Scanner  scanner.Scanner
+}
+
+// Get file url
+// @Security bearerAuth
+// @Summary Signed download url of a file
+// @Description This method returns a short lived signed url to download the logo or the CV.
+// @Tags File
+// @Produce json
+// @Param id path int true "File ID"
+// @Schemes
+// @Success 200 {object} file.DownloadURL
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Failure 409 {object} gin.H
+// @Router /files/{id}/url [get]
+func (db Database) GetFileURL(ctx *gin.Context) {
+
+	// file loaded by AuthorizeFile
+	dbFile := ctx.MustGet("file").(File)
+
+	if dbFile.ScanStatus != ScanClean {
+		ctx.JSON(http.StatusConflict, gin.H{"message": ErrQuarantined.Error()})
+		return
+	}
+
+	//get values
+	session := middleware.ExtractTokenValues(ctx)
+
+	// log the access
+	if err := NewFileAccess(db.DB, FileAccess{FileID: dbFile.ID, UserID: session.UserID, Action: ActionSign, IP: ctx.ClientIP(), UserAgent: ctx.Request.UserAgent()}); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, SignedURL(db.Config.Storage, dbFile, session.UserID))
+}
+
+// Get file accesses
+// @Security bearerAuth
+// @Summary Accesses of a file
+// @Description This method lists the signed urls handed out and the downloads of a file.
+// @Tags File
+// @Produce json
+// @Param id path int true "File ID"
+// @Schemes
+// @Success 200 {array} file.FileAccess
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Router /files/{id}/accesses [get]
+func (db Database) GetFileAccesses(ctx *gin.Context) {
+
+	// get id value from path
+	file_id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	accesses, err := GetFileAccesses(db.DB, uint(file_id))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, accesses)
+}
+
+// Download file
+// @Summary Download a file
+// @Description This method streams the file of a signed url.
+// @Tags File
+// @Produce octet-stream
+// @Param id path int true "File ID"
+// @Param user query int true "User the url was signed for"
+// @Param expires query int true "Expiration (unix time)"
+// @Param signature query string true "Signature"
+// @Schemes
+// @Success 200 {file} file
+// @Failure 400 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Failure 409 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /files/{id}/download [get]
+func (db Database) DownloadFile(ctx *gin.Context) {
+
+	// get values from path and query
+	file_id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "invalid file id"})
+		return
+	}
+	user_id, err := strconv.ParseUint(ctx.Query("user"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "invalid user"})
+		return
+	}
+	expires, err := strconv.ParseInt(ctx.Query("expires"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "invalid expiration"})
+		return
+	}
+
+	// check the signature
+	if err := VerifySignature(db.Config.Storage.URLSecret, uint(file_id), uint(user_id), expires, ctx.Query("signature")); err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{"message": err.Error()})
+		return
+	}
+
+	// get file by id
+	dbFile, err := GetFileByID(db.DB, uint(file_id))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "file not found"})
+		return
+	}
+
+	if dbFile.ScanStatus != ScanClean {
+		ctx.JSON(http.StatusConflict, gin.H{"message": ErrQuarantined.Error()})
+		return
+	}
+
+	content, err := db.Storage.Open(ctx.Request.Context(), dbFile.Key)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+	defer content.Close()
+
+	// log the access
+	if err := NewFileAccess(db.DB, FileAccess{FileID: dbFile.ID, UserID: uint(user_id), Action: ActionDownload, IP: ctx.ClientIP(), UserAgent: ctx.Request.UserAgent()}); err != nil {
+		log.Printf("[WARNING] file access %d: %v", dbFile.ID, err)
+	}
+
+	// the cvs are downloaded, the logos displayed
+	disposition := "inline"
+	if dbFile.Kind == KindCV {
+		disposition = "attachment"
+	}
+
+	ctx.DataFromReader(http.StatusOK, dbFile.Size, dbFile.ContentType, content, map[string]string{
+		"Content-Disposition": fmt.Sprintf("%s; filename=%q", disposition, dbFile.Name),
+		"Cache-Control":       "private, no-store",
+	})
+}
+
+// Rescan file
+// @Security bearerAuth
+// @Summary Scan a file again
+// @Description This method scans a quarantined file and saves the outcome.
+// @Tags File
+// @Produce json
+// @Param id path int true "File ID"
+// @Schemes
+// @Success 200 {object} file.File
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /files/{id}/scan [post]
+func (db Database) RescanFile(ctx *gin.Context) {
+
+	// get id value from path
+	file_id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// get file by id
+	dbFile, err := GetFileByID(db.DB, uint(file_id))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "file not found"})
+		return
+	}
+
+	if dbFile.ScanStatus == ScanInfected {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "the infected files are deleted from the storage"})
+		return
+	}
+
+	content, err := db.Storage.Open(ctx.Request.Context(), dbFile.Key)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+	defer content.Close()
+
+	// the infected file is returned with its scan result
+	dbFile, err = ScanFile(ctx.Request.Context(), db.DB, db.Storage, db.Scanner, dbFile, content)
+	if err != nil && !errors.Is(err, ErrInfected) {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dbFile)
+}
@@ -0,0 +1,261 @@
+package file
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/ezzddinne/api/user"
+	"github.com/ezzddinne/config"
+	"github.com/ezzddinne/middleware"
+	"github.com/ezzddinne/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// path of the download endpoint, the query carries the signature
+const downloadPath string = "/api/files/%d/download"
+
+// casbin object checked for each kind of file
+var objects = map[string]string{
+	KindLogo:   "squads",
+	KindCV:     "cvs",
+	KindAnswer: "answers",
+}
+
+// extensions of the accepted content types
+var extensions = map[string]string{
+	"image/png":       ".png",
+	"image/jpeg":      ".jpg",
+	"application/pdf": ".pdf",
+}
+
+// store the content and save its record, the object is removed if the record can't be saved
+func Store(ctx context.Context, db *gorm.DB, store storage.Storage, upload Upload) (File, error) {
+
+	// size and checksum of the content
+	hash := sha256.New()
+	size, err := io.Copy(hash, upload.Content)
+	if err != nil {
+		return File{}, err
+	}
+	if upload.MaxSize > 0 && size > upload.MaxSize {
+		return File{}, ErrTooLarge
+	}
+
+	content_type, err := detectContentType(upload.Content)
+	if err != nil {
+		return File{}, err
+	}
+
+	if len(upload.ContentTypes) > 0 && !contains(upload.ContentTypes, content_type) {
+		return File{}, fmt.Errorf("file type %s is not allowed", content_type)
+	}
+
+	if _, err := upload.Content.Seek(0, io.SeekStart); err != nil {
+		return File{}, err
+	}
+
+	ext, ok := extensions[content_type]
+	if !ok {
+		ext = strings.ToLower(filepath.Ext(upload.Name))
+	}
+	name := uuid.NewString()
+	if upload.Variant != "" {
+		name += "_" + upload.Variant
+	}
+	key := fmt.Sprintf("%ss/%d/%s%s", upload.Kind, upload.SquadID, name, ext)
+
+	url, err := store.Put(ctx, key, upload.Content, size, content_type)
+	if err != nil {
+		return File{}, err
+	}
+
+	file, err := NewFile(db, File{
+		Key:         key,
+		Backend:     store.Name(),
+		URL:         url,
+		Kind:        upload.Kind,
+		Name:        filepath.Base(upload.Name),
+		ContentType: content_type,
+		Size:        size,
+		ScanStatus:  ScanPending,
+		Checksum:    hex.EncodeToString(hash.Sum(nil)),
+		SquadID:     upload.SquadID,
+		UploadedBy:  upload.UploadedBy,
+		Variant:     upload.Variant,
+		ParentID:    upload.ParentID,
+	})
+	if err != nil {
+		store.Delete(ctx, key)
+		return File{}, err
+	}
+
+	// the file is in quarantine until it is scanned clean
+	if upload.Scanner == nil {
+		return file, nil
+	}
+	if _, err := upload.Content.Seek(0, io.SeekStart); err != nil {
+		return file, err
+	}
+
+	return ScanFile(ctx, db, store, upload.Scanner, file, upload.Content)
+}
+
+// remove the stored content and its record, the variants included
+func Remove(ctx context.Context, db *gorm.DB, store storage.Storage, file File) error {
+
+	variants, err := GetFileVariants(db, file.ID)
+	if err != nil {
+		return err
+	}
+	for _, variant := range variants {
+		if err := Remove(ctx, db, store, variant); err != nil {
+			return err
+		}
+	}
+
+	if err := store.Delete(ctx, file.Key); err != nil {
+		return err
+	}
+
+	return DeleteFile(db, file.ID)
+}
+
+// signed download url of the file for the user, valid for the configured ttl
+func SignedURL(cfg config.StorageConfig, file File, user_id uint) DownloadURL {
+
+	expires_at := time.Now().Add(cfg.URLTTL()).Truncate(time.Second)
+	signature := sign(cfg.URLSecret, file.ID, user_id, expires_at.Unix())
+
+	return DownloadURL{
+		URL:       fmt.Sprintf(downloadPath+"?user=%d&expires=%d&signature=%s", file.ID, user_id, expires_at.Unix(), signature),
+		ExpiresAt: expires_at,
+	}
+}
+
+// check the signature and the expiration of a download url
+func VerifySignature(secret string, file_id, user_id uint, expires int64, signature string) error {
+
+	if !hmac.Equal([]byte(sign(secret, file_id, user_id, expires)), []byte(signature)) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	if time.Now().Unix() > expires {
+		return fmt.Errorf("download url expired")
+	}
+
+	return nil
+}
+
+// hmac of the file, the user and the expiration
+func sign(secret string, file_id, user_id uint, expires int64) string {
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d:%d:%d", file_id, user_id, expires)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// AuthorizeFile -> load the file in the path and authorize on the object of its kind:
+// squads for the logos, cvs for the cvs, or on the owned object when the user owns the file
+func AuthorizeFile(act string, db *gorm.DB, enforcer *casbin.SyncedEnforcer) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		file_id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "invalid file id"})
+			return
+		}
+
+		dbFile, err := GetFileByID(db, uint(file_id))
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "file not found"})
+			return
+		}
+
+		object, ok := objects[dbFile.Kind]
+		if !ok {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "You are not authorized"})
+			return
+		}
+
+		ctx.Set("file", dbFile)
+		middleware.AuthorizeResource(object, act, enforcer, OwnsFile(db, dbFile))(ctx)
+	}
+}
+
+// the user owns the file: a logo of his squad, his cv, a cv of the squad he leads or a file he answered with
+func OwnsFile(db *gorm.DB, file File) middleware.OwnerResolver {
+	return func(ctx *gin.Context, user_id uint) (bool, error) {
+
+		if user_id == 0 {
+			return false, nil
+		}
+
+		dbUser, err := user.GetUserByID(db, user_id)
+		if err != nil {
+			return false, nil
+		}
+
+		switch file.Kind {
+		case KindAnswer:
+			return file.UploadedBy == user_id, nil
+
+		case KindLogo:
+			return dbUser.SquadID != 0 && dbUser.SquadID == file.SquadID, nil
+
+		case KindCV:
+			if dbUser.CvFileID != nil && *dbUser.CvFileID == file.ID {
+				return true, nil
+			}
+			if file.SquadID == 0 || dbUser.SquadID != file.SquadID {
+				return false, nil
+			}
+
+			// the leader created the squad
+			var count int64
+			if err := db.Table("squads").Where("id = ? AND created_by = ? AND deleted_at IS NULL", file.SquadID, user_id).Count(&count).Error; err != nil {
+				return false, err
+			}
+			return count > 0, nil
+		}
+
+		return false, nil
+	}
+}
+
+// content type of the magic bytes
+func detectContentType(content io.ReadSeeker) (string, error) {
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(content, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	return SniffContentType(head[:n]), nil
+}
+
+func contains(values []string, value string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package file
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ezzddinne/config"
+)
+
+func TestSignedURL(t *testing.T) {
+
+	cfg := config.StorageConfig{URLSecret: "0123456789abcdef0123456789abcdef", URLDuration: 5}
+
+	download := SignedURL(cfg, File{ID: 7}, 3)
+	if download.ExpiresAt.Before(time.Now()) {
+		t.Errorf("url expires at %v", download.ExpiresAt)
+	}
+
+	link, err := url.Parse(download.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := link.Query()
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := query.Get("signature")
+
+	if err := VerifySignature(cfg.URLSecret, 7, 3, expires, signature); err != nil {
+		t.Errorf("valid url refused: %v", err)
+	}
+
+	// the url is bound to the file, the user, the expiration and the secret
+	if err := VerifySignature(cfg.URLSecret, 8, 3, expires, signature); err == nil {
+		t.Error("url accepted for another file")
+	}
+	if err := VerifySignature(cfg.URLSecret, 7, 4, expires, signature); err == nil {
+		t.Error("url accepted for another user")
+	}
+	if err := VerifySignature(cfg.URLSecret, 7, 3, expires+60, signature); err == nil {
+		t.Error("url accepted with another expiration")
+	}
+	if err := VerifySignature("another secret of 32 characters!", 7, 3, expires, signature); err == nil {
+		t.Error("url accepted with another secret")
+	}
+
+	// an expired url is refused even with its signature
+	past := time.Now().Add(-time.Minute).Unix()
+	if err := VerifySignature(cfg.URLSecret, 7, 3, past, sign(cfg.URLSecret, 7, 3, past)); err == nil {
+		t.Error("expired url accepted")
+	}
+}
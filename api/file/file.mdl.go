@@ -0,0 +1,139 @@
+package file
+
+import (
+	"io"
+	"time"
+
+	"github.com/ezzddinne/scanner"
+	"gorm.io/gorm"
+)
+
+// actions logged on the files
+const (
+	ActionSign     string = "sign"
+	ActionDownload string = "download"
+)
+
+// scan status of the files, only the clean files are served
+const (
+	ScanPending  string = "pending"
+	ScanClean    string = "clean"
+	ScanInfected string = "infected"
+)
+
+// kinds of the stored files
+const (
+	KindLogo   string = "logo"
+	KindCV     string = "cv"
+	KindAnswer string = "answer"
+)
+
+type File struct {
+	ID          uint       `gorm:"column:id;autoIncrement;primaryKey" json:"id"`
+	Key         string     `gorm:"column:key;not null;unique" json:"key"`
+	Backend     string     `gorm:"column:backend;not null" json:"backend"`
+	URL         string     `gorm:"column:url;not null" json:"-"`
+	Kind        string     `gorm:"column:kind;not null" json:"kind"`
+	Name        string     `gorm:"column:name" json:"name"`
+	ContentType string     `gorm:"column:content_type;not null" json:"content_type"`
+	Size        int64      `gorm:"column:size;not null" json:"size"`
+	Checksum    string     `gorm:"column:checksum;not null" json:"checksum"`
+	SquadID     uint       `gorm:"column:squad_id;index" json:"squad_id"`
+	UploadedBy  uint       `gorm:"column:uploaded_by;index" json:"uploaded_by"`
+	Variant     string     `gorm:"column:variant" json:"variant"`
+	ParentID    *uint      `gorm:"column:parent_id;index" json:"parent_id"`
+	ScanStatus  string     `gorm:"column:scan_status;not null;default:pending" json:"scan_status"`
+	ScanResult  string     `gorm:"column:scan_result" json:"scan_result"`
+	ScannedAt   *time.Time `gorm:"column:scanned_at" json:"scanned_at"`
+	gorm.Model
+}
+
+type FileAccess struct {
+	ID        uint      `gorm:"column:id;autoIncrement;primaryKey" json:"id"`
+	FileID    uint      `gorm:"column:file_id;not null;index" json:"file_id"`
+	UserID    uint      `gorm:"column:user_id;index" json:"user_id"`
+	Action    string    `gorm:"column:action;not null" json:"action"`
+	IP        string    `gorm:"column:ip" json:"ip"`
+	UserAgent string    `gorm:"column:user_agent" json:"user_agent"`
+	CreatedAt time.Time `gorm:"column:created_at;index" json:"created_at"`
+}
+
+// signed download url of a file
+type DownloadURL struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// content to store and what it belongs to
+type Upload struct {
+	Kind         string
+	Name         string
+	SquadID      uint
+	UploadedBy   uint
+	Content      io.ReadSeeker
+	ContentTypes []string
+	MaxSize      int64
+	Variant      string
+	ParentID     *uint
+	Scanner      scanner.Scanner
+}
+
+// create new file record
+func NewFile(db *gorm.DB, file File) (File, error) {
+	return file, db.Create(&file).Error
+}
+
+// get file by id
+func GetFileByID(db *gorm.DB, id uint) (file File, err error) {
+	return file, db.Where("id = ?", id).First(&file).Error
+}
+
+// get file by key
+func GetFileByKey(db *gorm.DB, key string) (file File, err error) {
+	return file, db.Where("key = ?", key).First(&file).Error
+}
+
+// get the files of a squad by kind, without the variants
+func GetSquadFiles(db *gorm.DB, squad_id uint, kind string) (files []File, err error) {
+	return files, db.Where("squad_id = ? AND kind = ? AND parent_id IS NULL", squad_id, kind).Order("id").Find(&files).Error
+}
+
+// get the last file of a squad by kind, without the variants
+func GetLastSquadFile(db *gorm.DB, squad_id uint, kind string) (file File, err error) {
+	return file, db.Where("squad_id = ? AND kind = ? AND parent_id IS NULL", squad_id, kind).Order("id DESC").First(&file).Error
+}
+
+// get the variants of a file
+func GetFileVariants(db *gorm.DB, file_id uint) (files []File, err error) {
+	return files, db.Where("parent_id = ?", file_id).Order("id").Find(&files).Error
+}
+
+// get a variant of a file
+func GetFileVariant(db *gorm.DB, file_id uint, variant string) (file File, err error) {
+	return file, db.Where("parent_id = ? AND variant = ?", file_id, variant).First(&file).Error
+}
+
+// get a page of the files waiting for a scan, after the file id
+func GetPendingFiles(db *gorm.DB, after_id uint, limit int) (files []File, err error) {
+	return files, db.Where("scan_status = ? AND id > ?", ScanPending, after_id).Order("id").Limit(limit).Find(&files).Error
+}
+
+// save the outcome of a scan
+func UpdateScan(db *gorm.DB, file_id uint, status, result string, scanned_at *time.Time) error {
+	return db.Model(&File{}).Where("id = ?", file_id).Updates(map[string]interface{}{"scan_status": status, "scan_result": result, "scanned_at": scanned_at}).Error
+}
+
+// delete file record
+func DeleteFile(db *gorm.DB, id uint) error {
+	return db.Where("id = ?", id).Delete(&File{}).Error
+}
+
+// save file access
+func NewFileAccess(db *gorm.DB, access FileAccess) error {
+	return db.Create(&access).Error
+}
+
+// get the accesses of a file, the latest first
+func GetFileAccesses(db *gorm.DB, file_id uint) (accesses []FileAccess, err error) {
+	return accesses, db.Where("file_id = ?", file_id).Order("created_at DESC").Find(&accesses).Error
+}
@@ -0,0 +1,36 @@
+package file
+
+import (
+	"github.com/casbin/casbin/v2"
+	"github.com/ezzddinne/config"
+	"github.com/ezzddinne/middleware"
+	"github.com/ezzddinne/scanner"
+	"github.com/ezzddinne/storage"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func RoutesFilesJWT(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.SyncedEnforcer, cfg *config.Config, store storage.Storage, sc scanner.Scanner) {
+
+	baseInstance := Database{DB: db, Enforcer: enforcer, Config: cfg, Storage: store, Scanner: sc}
+
+	// signed download url route
+	router.GET("/:id/url", AuthorizeFile("read", db, enforcer), baseInstance.GetFileURL)
+
+	// rescan route, for the files left in quarantine
+	router.POST("/:id/scan", middleware.Authorize("files", "write", enforcer), baseInstance.RescanFile)
+
+	// registration form answer upload route
+	router.POST("/answers", middleware.AuthorizeResource("answers", "write", enforcer, OwnsNewAnswer()), baseInstance.UploadAnswerFile)
+
+	// file accesses route
+	router.GET("/:id/accesses", middleware.Authorize("files", "read", enforcer), baseInstance.GetFileAccesses)
+}
+
+func RoutesFiles(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.SyncedEnforcer, cfg *config.Config, store storage.Storage) {
+
+	baseInstance := Database{DB: db, Enforcer: enforcer, Config: cfg, Storage: store}
+
+	// download route, authorized by the signature of the url
+	router.GET("/:id/download", baseInstance.DownloadFile)
+}
@@ -0,0 +1,110 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/ezzddinne/scanner"
+	"github.com/ezzddinne/storage"
+	"gorm.io/gorm"
+)
+
+// the scanner found malware in the content
+var ErrInfected = errors.New("file is infected")
+
+// the file isn't scanned clean yet
+var ErrQuarantined = errors.New("file is in quarantine until it is scanned")
+
+// files scanned on each run of the worker
+const scanBatchSize int = 50
+
+// scan the content of the file and save the outcome, the infected content is deleted from the storage
+func ScanFile(ctx context.Context, db *gorm.DB, store storage.Storage, sc scanner.Scanner, file File, content io.Reader) (File, error) {
+
+	result, err := sc.Scan(ctx, content)
+	if err != nil {
+		// the file stays in quarantine until the next scan
+		log.Printf("[WARNING] scan file %d: %v", file.ID, err)
+		file.ScanStatus, file.ScanResult = ScanPending, err.Error()
+		return file, UpdateScan(db, file.ID, file.ScanStatus, file.ScanResult, nil)
+	}
+
+	now := time.Now()
+	file.ScannedAt = &now
+
+	if result.Infected {
+		file.ScanStatus, file.ScanResult = ScanInfected, sc.Name()+": "+result.Signature
+		if err := UpdateScan(db, file.ID, file.ScanStatus, file.ScanResult, file.ScannedAt); err != nil {
+			return file, err
+		}
+
+		// the record is kept to trace the upload
+		if err := store.Delete(ctx, file.Key); err != nil {
+			log.Printf("[WARNING] delete infected file %d: %v", file.ID, err)
+		}
+		return file, fmt.Errorf("%w: %s", ErrInfected, result.Signature)
+	}
+
+	file.ScanStatus, file.ScanResult = ScanClean, sc.Name()+": OK"
+	return file, UpdateScan(db, file.ID, file.ScanStatus, file.ScanResult, file.ScannedAt)
+}
+
+// scan the files left in quarantine: uploaded while the scanner was down or before the scans
+// the pending files are paged by id, the files that can't be opened don't hold back the newer ones
+func ScanQuarantined(ctx context.Context, db *gorm.DB, store storage.Storage, sc scanner.Scanner) (int, error) {
+
+	scanned := 0
+	var last_id uint
+	for {
+		files, err := GetPendingFiles(db, last_id, scanBatchSize)
+		if err != nil {
+			return scanned, err
+		}
+
+		for _, file := range files {
+			last_id = file.ID
+
+			content, err := store.Open(ctx, file.Key)
+			if err != nil {
+				log.Printf("[WARNING] open file %d: %v", file.ID, err)
+				continue
+			}
+
+			file, err = ScanFile(ctx, db, store, sc, file, content)
+			content.Close()
+			if err != nil && !errors.Is(err, ErrInfected) {
+				return scanned, err
+			}
+			if file.ScanStatus != ScanPending {
+				scanned++
+			}
+		}
+
+		if len(files) < scanBatchSize {
+			return scanned, nil
+		}
+	}
+}
+
+// scan the quarantined files every interval
+func StartScanWorker(db *gorm.DB, store storage.Storage, sc scanner.Scanner, interval time.Duration) {
+
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for ; true; <-ticker.C {
+			if _, err := ScanQuarantined(context.Background(), db, store, sc); err != nil {
+				log.Println("[WARNING] scan worker:", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,190 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ezzddinne/scanner"
+	"github.com/ezzddinne/storage"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// memory database and local storage of the uploads
+func testStorage(t *testing.T) (*gorm.DB, storage.Storage) {
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each connection opens its own memory database
+	sql_db, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql_db.SetMaxOpenConns(1)
+
+	if err := db.AutoMigrate(&File{}); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db, store
+}
+
+// store the content under key and create its file record, waiting for its scan
+func upload(t *testing.T, db *gorm.DB, store storage.Storage, key string) File {
+
+	url, err := store.Put(context.Background(), key, strings.NewReader("content"), int64(len("content")), "application/pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := NewFile(db, File{Key: key, Backend: store.Name(), URL: url, Kind: KindCV, ContentType: "application/pdf", Size: int64(len("content")), Checksum: "checksum"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+// database and storage holding one uploaded file waiting for its scan
+func uploadedFile(t *testing.T) (*gorm.DB, storage.Storage, File) {
+
+	db, store := testStorage(t)
+	return db, store, upload(t, db, store, "cvs/1/cv.pdf")
+}
+
+func TestScanFileClean(t *testing.T) {
+
+	db, store, file := uploadedFile(t)
+
+	sc := scanner.Func(func(ctx context.Context, content io.Reader) (scanner.Result, error) {
+		data, err := io.ReadAll(content)
+		if err != nil || string(data) != "content" {
+			t.Errorf("scanned %q, %v", data, err)
+		}
+		return scanner.Result{}, nil
+	})
+
+	scanned, err := ScanFile(context.Background(), db, store, sc, file, strings.NewReader("content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scanned.ScanStatus != ScanClean || scanned.ScanResult != "func: OK" || scanned.ScannedAt == nil {
+		t.Errorf("got %s %q, want clean", scanned.ScanStatus, scanned.ScanResult)
+	}
+
+	saved, err := GetFileByID(db, file.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.ScanStatus != ScanClean || saved.ScannedAt == nil {
+		t.Errorf("saved %s, want clean", saved.ScanStatus)
+	}
+}
+
+func TestScanFileInfected(t *testing.T) {
+
+	db, store, file := uploadedFile(t)
+
+	sc := scanner.Func(func(ctx context.Context, content io.Reader) (scanner.Result, error) {
+		return scanner.Result{Infected: true, Signature: "Eicar-Test-Signature"}, nil
+	})
+
+	scanned, err := ScanFile(context.Background(), db, store, sc, file, strings.NewReader("content"))
+	if !errors.Is(err, ErrInfected) {
+		t.Fatalf("error %v, want ErrInfected", err)
+	}
+	if scanned.ScanStatus != ScanInfected || scanned.ScanResult != "func: Eicar-Test-Signature" {
+		t.Errorf("got %s %q, want infected", scanned.ScanStatus, scanned.ScanResult)
+	}
+
+	// the record is kept, the content is deleted
+	saved, err := GetFileByID(db, file.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.ScanStatus != ScanInfected {
+		t.Errorf("saved %s, want infected", saved.ScanStatus)
+	}
+	if content, err := store.Open(context.Background(), file.Key); err == nil {
+		content.Close()
+		t.Error("infected content still stored")
+	}
+}
+
+func TestScanFileError(t *testing.T) {
+
+	db, store, file := uploadedFile(t)
+
+	sc := scanner.Func(func(ctx context.Context, content io.Reader) (scanner.Result, error) {
+		return scanner.Result{}, errors.New("clamd unreachable")
+	})
+
+	// the file stays in quarantine for the next scan
+	scanned, err := ScanFile(context.Background(), db, store, sc, file, strings.NewReader("content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scanned.ScanStatus != ScanPending || scanned.ScanResult != "clamd unreachable" {
+		t.Errorf("got %s %q, want pending", scanned.ScanStatus, scanned.ScanResult)
+	}
+
+	saved, err := GetFileByID(db, file.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.ScanStatus != ScanPending || saved.ScannedAt != nil {
+		t.Errorf("saved %s, want pending", saved.ScanStatus)
+	}
+	if content, err := store.Open(context.Background(), file.Key); err != nil {
+		t.Errorf("content deleted: %v", err)
+	} else {
+		content.Close()
+	}
+}
+
+func TestScanQuarantinedSkipsMissingContent(t *testing.T) {
+
+	db, store := testStorage(t)
+	ctx := context.Background()
+
+	// more files than a batch whose content was deleted from the storage
+	for i := 0; i < scanBatchSize+5; i++ {
+		missing := upload(t, db, store, fmt.Sprintf("cvs/%d/missing.pdf", i))
+		if err := store.Delete(ctx, missing.Key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := upload(t, db, store, "cvs/1/cv.pdf")
+
+	sc := scanner.Func(func(ctx context.Context, content io.Reader) (scanner.Result, error) {
+		return scanner.Result{}, nil
+	})
+
+	scanned, err := ScanQuarantined(ctx, db, store, sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scanned != 1 {
+		t.Errorf("scanned %d files, want 1", scanned)
+	}
+
+	saved, err := GetFileByID(db, file.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.ScanStatus != ScanClean {
+		t.Errorf("saved %s, want clean", saved.ScanStatus)
+	}
+}
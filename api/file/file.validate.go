@@ -0,0 +1,146 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+
+	"github.com/ledongthuc/pdf"
+	"golang.org/x/image/draw"
+)
+
+// the content is larger than the allowed size
+var ErrTooLarge = errors.New("file too large")
+
+// room left for the multipart headers around the uploaded file
+const MultipartOverhead int64 = 1 << 20
+
+// square sizes of the stored logos, the first one is the main file
+var LogoSizes = []int{512, 128}
+
+// largest logo decoded, in pixels
+const maxLogoPixels int = 25000000
+
+// content types recognized from their first bytes
+var signatures = []struct {
+	content_type string
+	magic        []byte
+}{
+	{"image/png", []byte("\x89PNG\r\n\x1a\n")},
+	{"image/jpeg", []byte{0xFF, 0xD8, 0xFF}},
+	{"application/pdf", []byte("%PDF-")},
+}
+
+// content type of the magic bytes, the name and the declared type are ignored
+func SniffContentType(head []byte) string {
+
+	for _, signature := range signatures {
+		if bytes.HasPrefix(head, signature.magic) {
+			return signature.content_type
+		}
+	}
+
+	return "application/octet-stream"
+}
+
+// read the content, failing when it is larger than max_size
+func ReadLimited(content io.Reader, max_size int64) ([]byte, error) {
+
+	data, err := io.ReadAll(io.LimitReader(content, max_size+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max_size {
+		return nil, ErrTooLarge
+	}
+
+	return data, nil
+}
+
+// check the pdf can be parsed and has pages
+func CheckPDF(content io.ReaderAt, size int64) (err error) {
+
+	// the parser panics on some malformed files
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("invalid pdf: %v", recovered)
+		}
+	}()
+
+	reader, err := pdf.NewReader(content, size)
+	if err != nil {
+		return fmt.Errorf("invalid pdf: %v", err)
+	}
+	if reader.NumPage() == 0 {
+		return fmt.Errorf("invalid pdf: no pages")
+	}
+
+	return nil
+}
+
+// decode the logo and encode it as png squares of LogoSizes, the metadata is dropped
+func NormalizeLogo(data []byte) (map[int][]byte, error) {
+
+	// reject the huge images before decoding them
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image: %v", err)
+	}
+	if format != "png" && format != "jpeg" {
+		return nil, fmt.Errorf("image format %s is not allowed", format)
+	}
+	if config.Width*config.Height > maxLogoPixels {
+		return nil, fmt.Errorf("image is too large: %dx%d", config.Width, config.Height)
+	}
+
+	var img image.Image
+	if format == "png" {
+		img, err = png.Decode(bytes.NewReader(data))
+	} else {
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid image: %v", err)
+	}
+
+	// centered square of the image
+	bounds := img.Bounds()
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	x := bounds.Min.X + (bounds.Dx()-side)/2
+	y := bounds.Min.Y + (bounds.Dy()-side)/2
+	square := image.Rect(x, y, x+side, y+side)
+
+	variants := map[int][]byte{}
+	for _, size := range LogoSizes {
+		dst := image.NewRGBA(image.Rect(0, 0, size, size))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, square, draw.Over, nil)
+
+		var buffer bytes.Buffer
+		if err := png.Encode(&buffer, dst); err != nil {
+			return nil, err
+		}
+		variants[size] = buffer.Bytes()
+	}
+
+	return variants, nil
+}
+
+// status of an upload error: too large, infected or invalid content
+func UploadErrorStatus(err error) int {
+	var max_bytes *http.MaxBytesError
+	if errors.Is(err, ErrTooLarge) || errors.As(err, &max_bytes) {
+		return http.StatusRequestEntityTooLarge
+	}
+	if errors.Is(err, ErrInfected) {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadRequest
+}
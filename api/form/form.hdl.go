@@ -0,0 +1,312 @@
+package form
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/ezzddinne/config"
+	"github.com/ezzddinne/middleware"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type Database struct {
+	DB       *gorm.DB
+	Enforcer *casbin.SyncedEnforcer
+	Config   *config.Config
+}
+
+// create new field
+// @Security bearerAuth
+// @Summary Add a field to the registration form
+// @Description This method adds a question to the registration form of the event selected by the X-Event header.
+// @Tags Form
+// @Accept json
+// @Produce json
+// @Param X-Event header string false "Domain"
+// @Param request body FormField true "Field required fields"
+// @Schemes
+// @Success 200 {object} form.FormField
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Router /form/fields [post]
+func (db Database) NewField(ctx *gin.Context) {
+
+	//init vars
+	var field FormField
+
+	//unmarshal sent json
+	if err := ctx.ShouldBindJSON(&field); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	field.ID = 0
+	field.Domain = middleware.ResourceDomain(ctx)
+	if field.Scope == "" {
+		field.Scope = ScopeUser
+	}
+
+	// check field
+	if err := ValidateField(field); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// the key names the answer
+	if fields, err := GetFields(db.DB, field.Domain, ""); err == nil {
+		for _, db_field := range fields {
+			if db_field.Key == field.Key {
+				ctx.JSON(http.StatusBadRequest, gin.H{"message": "field key already exists"})
+				return
+			}
+		}
+	}
+
+	new_field, err := NewField(db.DB, field)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, new_field)
+}
+
+// get fields
+// @Summary Get the registration form
+// @Description This method returns the questions of the registration form of the event, the scope query keeps the user or the squad questions.
+// @Tags Form
+// @Produce json
+// @Param X-Event header string false "Domain"
+// @Param scope query string false "user or squad"
+// @Schemes
+// @Success 200 {array} form.FormField
+// @Failure 400 {object} gin.H
+// @Router /form/fields [get]
+func (db Database) GetFields(ctx *gin.Context) {
+
+	fields, err := GetFields(db.DB, middleware.RequestDomain(ctx), ctx.Query("scope"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, fields)
+}
+
+// update field
+// @Security bearerAuth
+// @Summary Update a field of the registration form
+// @Description This method replaces the definition of a question, the answers already given are kept.
+// @Tags Form
+// @Accept json
+// @Produce json
+// @Param id path int true "Field ID"
+// @Param request body FormField true "Field required fields"
+// @Schemes
+// @Success 200 {object} form.FormField
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Router /form/fields/{id} [put]
+func (db Database) UpdateField(ctx *gin.Context) {
+
+	//init vars
+	var field FormField
+
+	// get id value from path
+	field_id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	db_field, err := GetFieldByID(db.DB, uint(field_id))
+	if err != nil || db_field.Domain != middleware.ResourceDomain(ctx) {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "field not found"})
+		return
+	}
+
+	//unmarshal sent json
+	if err := ctx.ShouldBindJSON(&field); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	field.ID = db_field.ID
+	field.Domain = db_field.Domain
+	if field.Scope == "" {
+		field.Scope = db_field.Scope
+	}
+
+	// check field
+	if err := ValidateField(field); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	if err := UpdateField(db.DB, field); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	updated, err := GetFieldByID(db.DB, db_field.ID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, updated)
+}
+
+// delete field
+// @Security bearerAuth
+// @Summary Delete a field of the registration form
+// @Description This method removes a question, its answers are no longer exported.
+// @Tags Form
+// @Produce json
+// @Param id path int true "Field ID"
+// @Schemes
+// @Success 200 {object} gin.H
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Router /form/fields/{id} [delete]
+func (db Database) DeleteField(ctx *gin.Context) {
+
+	// get id value from path
+	field_id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	db_field, err := GetFieldByID(db.DB, uint(field_id))
+	if err != nil || db_field.Domain != middleware.ResourceDomain(ctx) {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "field not found"})
+		return
+	}
+
+	if err := DeleteField(db.DB, db_field.ID); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Field deleted successfully"})
+}
+
+// get my answers
+// @Security bearerAuth
+// @Summary Get the answers of the current user
+// @Description This method returns the answers of the logged in user to the registration form of the event.
+// @Tags Form
+// @Produce json
+// @Schemes
+// @Success 200 {object} form.Answers
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Router /form/answers/me [get]
+func (db Database) GetMyAnswers(ctx *gin.Context) {
+
+	//get values
+	session := middleware.ExtractTokenValues(ctx)
+
+	answers, err := UserAnswers(db.DB, RegistrationDomain(), session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, answers)
+}
+
+// update my answers
+// @Security bearerAuth
+// @Summary Update the answers of the current user
+// @Description This method updates the given answers of the logged in user, a blank answer clears the previous one.
+// @Tags Form
+// @Accept json
+// @Produce json
+// @Param request body form.Answers true "Answers by field key"
+// @Schemes
+// @Success 200 {object} form.Answers
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Router /form/answers/me [put]
+func (db Database) UpdateMyAnswers(ctx *gin.Context) {
+
+	//init vars
+	var input Answers
+	domain := RegistrationDomain()
+
+	//unmarshal sent json
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	//get values
+	session := middleware.ExtractTokenValues(ctx)
+
+	previous, err := UserAnswers(db.DB, domain, session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	answers, err := ValidateAnswers(db.DB, domain, ScopeUser, MergeAnswers(previous, input), session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse(err))
+		return
+	}
+
+	if err := SaveUserAnswers(db.DB, session.UserID, answers); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	saved, err := UserAnswers(db.DB, domain, session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, saved)
+}
+
+// get answers
+// @Security bearerAuth
+// @Summary Export the answers of the event
+// @Description This method returns the answers of every user, or of every squad with scope=squad, by user or squad id.
+// @Tags Form
+// @Produce json
+// @Param X-Event header string false "Domain"
+// @Param scope query string false "user or squad"
+// @Schemes
+// @Success 200 {object} map[uint]form.Answers
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Router /form/answers [get]
+func (db Database) GetAnswers(ctx *gin.Context) {
+
+	scope := ctx.DefaultQuery("scope", ScopeUser)
+	if scope != ScopeUser && scope != ScopeSquad {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "scope must be user or squad"})
+		return
+	}
+
+	answers, err := AnswersByOwner(db.DB, middleware.ResourceDomain(ctx), scope)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, answers)
+}
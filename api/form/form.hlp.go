@@ -0,0 +1,142 @@
+package form
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/ezzddinne/middleware"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// body of an answers error, with the error of every field
+func ErrorResponse(err error) gin.H {
+	var errs AnswerErrors
+	if errors.As(err, &errs) {
+		return gin.H{"message": "invalid answers", "errors": errs}
+	}
+	return gin.H{"message": err.Error()}
+}
+
+// domain the users and squads register in, where their roles are granted
+// the answers are always checked against it, never against the domain sent with the request
+func RegistrationDomain() string {
+	return middleware.DefaultDomain()
+}
+
+// the request targets the answers of the user himself
+func OwnsAnswers() middleware.OwnerResolver {
+	return func(ctx *gin.Context, user_id uint) (bool, error) {
+		return user_id != 0, nil
+	}
+}
+
+// the field of the route belongs to the event of its form
+func FieldDomain(db *gorm.DB) middleware.DomainResolver {
+	return func(ctx *gin.Context) (string, error) {
+
+		field_id, err := strconv.Atoi(ctx.Param("id"))
+		if err != nil {
+			return "", gorm.ErrRecordNotFound
+		}
+
+		field, err := GetFieldByID(db, uint(field_id))
+		if err != nil {
+			return "", err
+		}
+		return field.Domain, nil
+	}
+}
+
+// answers of the owners by field key, the owner is the user or the squad of the scope
+func AnswersByOwner(db *gorm.DB, domain, scope string) (map[uint]Answers, error) {
+
+	fields, err := GetFields(db, domain, scope)
+	if err != nil || len(fields) == 0 {
+		return map[uint]Answers{}, err
+	}
+
+	by_id := map[uint]FormField{}
+	ids := make([]uint, 0, len(fields))
+	for _, field := range fields {
+		by_id[field.ID] = field
+		ids = append(ids, field.ID)
+	}
+
+	answers, err := GetAnswers(db, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[uint]Answers{}
+	for _, answer := range answers {
+		owner := answer.UserID
+		if scope == ScopeSquad {
+			owner = answer.SquadID
+		}
+		if owner == nil {
+			continue
+		}
+		if result[*owner] == nil {
+			result[*owner] = Answers{}
+		}
+		field := by_id[answer.FieldID]
+		result[*owner][field.Key] = answerValue(field, answer)
+	}
+
+	return result, nil
+}
+
+// answers of a user by field key
+func UserAnswers(db *gorm.DB, domain string, user_id uint) (Answers, error) {
+	return ownerAnswers(db, domain, ScopeUser, func(ids []uint) ([]FormAnswer, error) {
+		return GetUserAnswers(db, ids, user_id)
+	})
+}
+
+// answers of a squad by field key
+func SquadAnswers(db *gorm.DB, domain string, squad_id uint) (Answers, error) {
+	return ownerAnswers(db, domain, ScopeSquad, func(ids []uint) ([]FormAnswer, error) {
+		return GetSquadAnswers(db, ids, squad_id)
+	})
+}
+
+func ownerAnswers(db *gorm.DB, domain, scope string, get func([]uint) ([]FormAnswer, error)) (Answers, error) {
+
+	fields, err := GetFields(db, domain, scope)
+	if err != nil || len(fields) == 0 {
+		return Answers{}, err
+	}
+
+	by_id := map[uint]FormField{}
+	ids := make([]uint, 0, len(fields))
+	for _, field := range fields {
+		by_id[field.ID] = field
+		ids = append(ids, field.ID)
+	}
+
+	answers, err := get(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	result := Answers{}
+	for _, answer := range answers {
+		field := by_id[answer.FieldID]
+		result[field.Key] = answerValue(field, answer)
+	}
+
+	return result, nil
+}
+
+// the previous answers updated with the new ones, to check the required fields of a partial update
+func MergeAnswers(previous, update Answers) Answers {
+	merged := Answers{}
+	for key, value := range previous {
+		merged[key] = value
+	}
+	for key, value := range update {
+		merged[key] = value
+	}
+	return merged
+}
@@ -0,0 +1,141 @@
+package form
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// the answers belong to a user or to a squad
+const (
+	ScopeUser  string = "user"
+	ScopeSquad string = "squad"
+)
+
+// types of the fields
+const (
+	TypeText        string = "text"
+	TypeChoice      string = "choice"
+	TypeMultiChoice string = "multi_choice"
+	TypeNumber      string = "number"
+	TypeDate        string = "date"
+	TypeFile        string = "file"
+)
+
+// question of the registration form of an event
+// min and max bound the length of a text, the value of a number and the choices of a multi choice
+type FormField struct {
+	ID       uint           `gorm:"column:id;autoIncrement;primaryKey" json:"id"`
+	Domain   string         `gorm:"column:domain;not null" json:"domain"`
+	Scope    string         `gorm:"column:scope;not null" json:"scope"`
+	Key      string         `gorm:"column:key;not null" json:"key"`
+	Label    string         `gorm:"column:label;not null" json:"label"`
+	Type     string         `gorm:"column:type;not null" json:"type"`
+	Required bool           `gorm:"column:required;not null" json:"required"`
+	Options  pq.StringArray `gorm:"column:options;type:text[]" json:"options"`
+	Min      *float64       `gorm:"column:min" json:"min"`
+	Max      *float64       `gorm:"column:max" json:"max"`
+	Pattern  string         `gorm:"column:pattern" json:"pattern"`
+	Position int            `gorm:"column:position;not null" json:"position"`
+
+	gorm.Model
+}
+
+// answer of a user or a squad, the multi choices have many values
+type FormAnswer struct {
+	ID        uint           `gorm:"column:id;autoIncrement;primaryKey" json:"id"`
+	FieldID   uint           `gorm:"column:field_id;not null" json:"field_id"`
+	UserID    *uint          `gorm:"column:user_id" json:"user_id"`
+	SquadID   *uint          `gorm:"column:squad_id" json:"squad_id"`
+	Values    pq.StringArray `gorm:"column:values;type:text[]" json:"values"`
+	CreatedAt time.Time      `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt time.Time      `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// answers by field key
+type Answers map[string]interface{}
+
+// create new field
+func NewField(db *gorm.DB, field FormField) (FormField, error) {
+	return field, db.Create(&field).Error
+}
+
+// get field by id
+func GetFieldByID(db *gorm.DB, field_id uint) (field FormField, err error) {
+	return field, db.Where("id = ?", field_id).First(&field).Error
+}
+
+// get the fields of an event, all the scopes when scope is empty
+func GetFields(db *gorm.DB, domain, scope string) (fields []FormField, err error) {
+	query := db.Where("domain = ?", domain)
+	if scope != "" {
+		query = query.Where("scope = ?", scope)
+	}
+	return fields, query.Order("position, id").Find(&fields).Error
+}
+
+// update every column of the field
+func UpdateField(db *gorm.DB, field FormField) error {
+	return db.Model(&FormField{}).Where("id = ?", field.ID).
+		Select("scope", "key", "label", "type", "required", "options", "min", "max", "pattern", "position").
+		Updates(&field).Error
+}
+
+// delete field
+func DeleteField(db *gorm.DB, field_id uint) error {
+	return db.Where("id = ?", field_id).Delete(&FormField{}).Error
+}
+
+// get the answers of the fields, by the user or the squad
+func GetAnswers(db *gorm.DB, field_ids []uint) (answers []FormAnswer, err error) {
+	return answers, db.Where("field_id IN ?", field_ids).Find(&answers).Error
+}
+
+// get the answers of a user
+func GetUserAnswers(db *gorm.DB, field_ids []uint, user_id uint) (answers []FormAnswer, err error) {
+	return answers, db.Where("field_id IN ? AND user_id = ?", field_ids, user_id).Find(&answers).Error
+}
+
+// get the answers of a squad
+func GetSquadAnswers(db *gorm.DB, field_ids []uint, squad_id uint) (answers []FormAnswer, err error) {
+	return answers, db.Where("field_id IN ? AND squad_id = ?", field_ids, squad_id).Find(&answers).Error
+}
+
+// replace the answers of a user, the blank answers are removed
+func SaveUserAnswers(db *gorm.DB, user_id uint, answers []FormAnswer) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, answer := range answers {
+			if err := tx.Where("field_id = ? AND user_id = ?", answer.FieldID, user_id).Delete(&FormAnswer{}).Error; err != nil {
+				return err
+			}
+			if len(answer.Values) == 0 {
+				continue
+			}
+			answer.UserID = &user_id
+			if err := tx.Create(&answer).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// replace the answers of a squad, the blank answers are removed
+func SaveSquadAnswers(db *gorm.DB, squad_id uint, answers []FormAnswer) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, answer := range answers {
+			if err := tx.Where("field_id = ? AND squad_id = ?", answer.FieldID, squad_id).Delete(&FormAnswer{}).Error; err != nil {
+				return err
+			}
+			if len(answer.Values) == 0 {
+				continue
+			}
+			answer.SquadID = &squad_id
+			if err := tx.Create(&answer).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,34 @@
+package form
+
+import (
+	"github.com/casbin/casbin/v2"
+	"github.com/ezzddinne/config"
+	"github.com/ezzddinne/middleware"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func RoutesForms(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.SyncedEnforcer, cfg *config.Config) {
+
+	baseInstance := Database{DB: db, Enforcer: enforcer, Config: cfg}
+
+	// registration form route, read before signing up
+	router.GET("/fields", baseInstance.GetFields)
+}
+
+func RoutesFormsJWT(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.SyncedEnforcer, cfg *config.Config) {
+
+	baseInstance := Database{DB: db, Enforcer: enforcer, Config: cfg}
+
+	// form builder routes, authorized in the event of the form
+	router.POST("/fields", middleware.InDomain(middleware.SelectedDomain), middleware.Authorize("forms", "write", enforcer), baseInstance.NewField)
+	router.PUT("/fields/:id", middleware.InDomain(FieldDomain(db)), middleware.Authorize("forms", "write", enforcer), baseInstance.UpdateField)
+	router.DELETE("/fields/:id", middleware.InDomain(FieldDomain(db)), middleware.Authorize("forms", "delete", enforcer), baseInstance.DeleteField)
+
+	// my answers routes
+	router.GET("/answers/me", middleware.AuthorizeResource("answers", "read", enforcer, OwnsAnswers()), baseInstance.GetMyAnswers)
+	router.PUT("/answers/me", middleware.AuthorizeResource("answers", "write", enforcer, OwnsAnswers()), baseInstance.UpdateMyAnswers)
+
+	// answers export route
+	router.GET("/answers", middleware.InDomain(middleware.SelectedDomain), middleware.Authorize("answers", "export", enforcer), baseInstance.GetAnswers)
+}
@@ -0,0 +1,284 @@
+package form
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// format of the date answers
+const DateLayout string = "2006-01-02"
+
+// kind of the files answering the file fields, as file.KindAnswer
+const AnswerFileKind string = "answer"
+
+var keyRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+var types = []string{TypeText, TypeChoice, TypeMultiChoice, TypeNumber, TypeDate, TypeFile}
+
+// errors of the answers by field key
+type AnswerErrors map[string]string
+
+func (errs AnswerErrors) Error() string {
+
+	keys := make([]string, 0, len(errs))
+	for key := range errs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	messages := make([]string, 0, len(keys))
+	for _, key := range keys {
+		messages = append(messages, key+": "+errs[key])
+	}
+	return "invalid answers: " + strings.Join(messages, ", ")
+}
+
+// check the definition of a field
+func ValidateField(field FormField) error {
+
+	if !keyRegexp.MatchString(field.Key) {
+		return fmt.Errorf("key must be lowercase letters, digits and underscores")
+	}
+	if strings.TrimSpace(field.Label) == "" {
+		return fmt.Errorf("label is required")
+	}
+	if field.Scope != ScopeUser && field.Scope != ScopeSquad {
+		return fmt.Errorf("scope must be %s or %s", ScopeUser, ScopeSquad)
+	}
+
+	valid_type := false
+	for _, field_type := range types {
+		if field.Type == field_type {
+			valid_type = true
+		}
+	}
+	if !valid_type {
+		return fmt.Errorf("type must be one of %s", strings.Join(types, ", "))
+	}
+
+	if (field.Type == TypeChoice || field.Type == TypeMultiChoice) && len(field.Options) == 0 {
+		return fmt.Errorf("options are required by the %s fields", field.Type)
+	}
+	if field.Min != nil && field.Max != nil && *field.Min > *field.Max {
+		return fmt.Errorf("min can't be greater than max")
+	}
+	if field.Pattern != "" {
+		if _, err := regexp.Compile(field.Pattern); err != nil {
+			return fmt.Errorf("pattern: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// check the answers to the fields of the event and scope
+// the file answers are ids of files uploaded by uploaded_by, they can't be given before signing in
+func ValidateAnswers(db *gorm.DB, domain, scope string, answers Answers, uploaded_by uint) ([]FormAnswer, error) {
+
+	fields, err := GetFields(db, domain, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := AnswerErrors{}
+	known := map[string]bool{}
+	var result []FormAnswer
+
+	for _, field := range fields {
+		known[field.Key] = true
+
+		value, ok := answers[field.Key]
+		if !ok || isEmpty(value) {
+			if field.Required && !(field.Type == TypeFile && uploaded_by == 0) {
+				errs[field.Key] = "is required"
+			} else if ok {
+				// the blank answer clears the previous one
+				result = append(result, FormAnswer{FieldID: field.ID})
+			}
+			continue
+		}
+
+		values, err := checkValue(db, field, value, uploaded_by)
+		if err != nil {
+			errs[field.Key] = err.Error()
+			continue
+		}
+
+		result = append(result, FormAnswer{FieldID: field.ID, Values: values})
+	}
+
+	for key := range answers {
+		if !known[key] {
+			errs[key] = "unknown field"
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return result, nil
+}
+
+// the value was left blank
+func isEmpty(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(v) == ""
+	case []interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+// check the value against the rules of the field, the values are stored as strings
+func checkValue(db *gorm.DB, field FormField, value interface{}, uploaded_by uint) ([]string, error) {
+
+	switch field.Type {
+	case TypeText:
+		text, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be a text")
+		}
+		if err := checkBounds(float64(utf8.RuneCountInString(text)), field, "length"); err != nil {
+			return nil, err
+		}
+		if field.Pattern != "" {
+			if pattern, err := regexp.Compile(field.Pattern); err == nil && !pattern.MatchString(text) {
+				return nil, fmt.Errorf("doesn't match the expected format")
+			}
+		}
+		return []string{text}, nil
+
+	case TypeNumber:
+		number, err := toNumber(value)
+		if err != nil {
+			return nil, fmt.Errorf("must be a number")
+		}
+		if err := checkBounds(number, field, "value"); err != nil {
+			return nil, err
+		}
+		return []string{strconv.FormatFloat(number, 'f', -1, 64)}, nil
+
+	case TypeDate:
+		date, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be a date")
+		}
+		if _, err := time.Parse(DateLayout, date); err != nil {
+			return nil, fmt.Errorf("must be a date formatted as %s", DateLayout)
+		}
+		return []string{date}, nil
+
+	case TypeChoice:
+		choice, ok := value.(string)
+		if !ok || !hasOption(field, choice) {
+			return nil, fmt.Errorf("must be one of %s", strings.Join(field.Options, ", "))
+		}
+		return []string{choice}, nil
+
+	case TypeMultiChoice:
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("must be a list of choices")
+		}
+		seen := map[string]bool{}
+		var choices []string
+		for _, item := range items {
+			choice, ok := item.(string)
+			if !ok || !hasOption(field, choice) {
+				return nil, fmt.Errorf("choices must be among %s", strings.Join(field.Options, ", "))
+			}
+			if !seen[choice] {
+				seen[choice] = true
+				choices = append(choices, choice)
+			}
+		}
+		if err := checkBounds(float64(len(choices)), field, "number of choices"); err != nil {
+			return nil, err
+		}
+		return choices, nil
+
+	case TypeFile:
+		if uploaded_by == 0 {
+			return nil, fmt.Errorf("files can be uploaded once signed in")
+		}
+		file_id, err := toNumber(value)
+		if err != nil || file_id <= 0 || file_id != float64(uint(file_id)) {
+			return nil, fmt.Errorf("must be the id of an uploaded file")
+		}
+
+		// the answer files are uploaded by the user himself
+		var count int64
+		if err := db.Table("files").Where("id = ? AND kind = ? AND uploaded_by = ? AND deleted_at IS NULL", uint(file_id), AnswerFileKind, uploaded_by).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, fmt.Errorf("file not found")
+		}
+		return []string{strconv.Itoa(int(file_id))}, nil
+	}
+
+	return nil, fmt.Errorf("unknown field type %s", field.Type)
+}
+
+// check the min and max of the field
+func checkBounds(value float64, field FormField, what string) error {
+	if field.Min != nil && value < *field.Min {
+		return fmt.Errorf("%s must be at least %v", what, *field.Min)
+	}
+	if field.Max != nil && value > *field.Max {
+		return fmt.Errorf("%s must be at most %v", what, *field.Max)
+	}
+	return nil
+}
+
+// number from the json number or its text
+func toNumber(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	}
+	return 0, fmt.Errorf("not a number")
+}
+
+// the choice is an option of the field
+func hasOption(field FormField, choice string) bool {
+	for _, option := range field.Options {
+		if option == choice {
+			return true
+		}
+	}
+	return false
+}
+
+// answer as sent by the clients: a list for the multi choices, a value for the others
+func answerValue(field FormField, answer FormAnswer) interface{} {
+	if field.Type == TypeMultiChoice {
+		values := make([]interface{}, 0, len(answer.Values))
+		for _, value := range answer.Values {
+			values = append(values, value)
+		}
+		return values
+	}
+	if len(answer.Values) == 0 {
+		return nil
+	}
+	if field.Type == TypeNumber || field.Type == TypeFile {
+		if number, err := strconv.ParseFloat(answer.Values[0], 64); err == nil {
+			return number
+		}
+	}
+	return answer.Values[0]
+}
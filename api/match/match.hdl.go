@@ -0,0 +1,720 @@
+package match
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/ezzddinne/api/squad"
+	"github.com/ezzddinne/api/user"
+	"github.com/ezzddinne/config"
+	"github.com/ezzddinne/middleware"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type Database struct {
+	DB       *gorm.DB
+	Enforcer *casbin.SyncedEnforcer
+	Config   *config.Config
+}
+
+// status of a join error
+func joinErrorStatus(err error) int {
+	if errors.Is(err, ErrHasSquad) || errors.Is(err, ErrRequestClosed) || errors.Is(err, squad.ErrSquadFull) || errors.Is(err, squad.ErrSquadLocked) {
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
+// Get my pool profile
+// @Security bearerAuth
+// @Summary Get the pool profile of the current user
+// @Description This method returns the skills and preferences the user listed to find a team.
+// @Tags Match
+// @Produce json
+// @Schemes
+// @Success 200 {object} match.PoolProfile
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Router /match/profile [get]
+func (db Database) GetMyProfile(ctx *gin.Context) {
+
+	//get values
+	session := middleware.ExtractTokenValues(ctx)
+
+	profile, err := GetProfile(db.DB, session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "No pool profile"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, profile)
+}
+
+// Update my pool profile
+// @Security bearerAuth
+// @Summary Join the pool or update the pool profile
+// @Description This method lists the user without squad in the looking for team pool with his skills and preferences.
+// @Tags Match
+// @Accept json
+// @Produce json
+// @Param request body ProfileInput true "Skills and preferences"
+// @Schemes
+// @Success 200 {object} match.PoolProfile
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 409 {object} gin.H
+// @Router /match/profile [put]
+func (db Database) UpdateMyProfile(ctx *gin.Context) {
+
+	//init vars
+	var input ProfileInput
+
+	//unmarshal sent json
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	//get values
+	session := middleware.ExtractTokenValues(ctx)
+
+	dbUser, err := user.GetUserByID(db.DB, session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	if dbUser.SquadID != 0 {
+		ctx.JSON(http.StatusConflict, gin.H{"message": ErrHasSquad.Error()})
+		return
+	}
+
+	active := true
+	if input.Active != nil {
+		active = *input.Active
+	}
+
+	profile, err := SaveProfile(db.DB, PoolProfile{
+		UserID:    dbUser.ID,
+		Skills:    normalizeTags(input.Skills),
+		Interests: normalizeTags(input.Interests),
+		Bio:       strings.TrimSpace(input.Bio),
+		Active:    active,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, profile)
+}
+
+// Delete my pool profile
+// @Security bearerAuth
+// @Summary Leave the pool
+// @Description This method removes the pool profile of the current user.
+// @Tags Match
+// @Produce json
+// @Schemes
+// @Success 200 {object} gin.H
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Router /match/profile [delete]
+func (db Database) DeleteMyProfile(ctx *gin.Context) {
+
+	//get values
+	session := middleware.ExtractTokenValues(ctx)
+
+	if err := DeleteProfile(db.DB, session.UserID); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Pool profile deleted successfully"})
+}
+
+// Get pool
+// @Security bearerAuth
+// @Summary Browse the users looking for a team
+// @Description This method lists the pool for the leaders, the skill query keeps the users with that skill.
+// @Tags Match
+// @Produce json
+// @Param skill query string false "Skill"
+// @Schemes
+// @Success 200 {array} match.PoolEntry
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Router /match/pool [get]
+func (db Database) GetPool(ctx *gin.Context) {
+
+	profiles, err := GetPool(db.DB)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	skill := strings.ToLower(strings.TrimSpace(ctx.Query("skill")))
+
+	entries := []PoolEntry{}
+	for _, profile := range profiles {
+		if skill != "" && !contains(profile.Skills, skill) {
+			continue
+		}
+
+		dbUser, err := user.GetUserByID(db.DB, profile.UserID)
+		if err != nil {
+			continue
+		}
+
+		entries = append(entries, PoolEntry{
+			UserID:     dbUser.ID,
+			FirstName:  dbUser.FirstName,
+			LastName:   dbUser.LastName,
+			University: dbUser.University,
+			Skills:     profile.Skills,
+			Interests:  profile.Interests,
+			Bio:        profile.Bio,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, entries)
+}
+
+// Get open squads
+// @Security bearerAuth
+// @Summary Browse the squads with open slots
+// @Description This method lists the draft squads that can take another member.
+// @Tags Match
+// @Produce json
+// @Schemes
+// @Success 200 {array} match.OpenSquad
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Router /match/squads [get]
+func (db Database) GetOpenSquads(ctx *gin.Context) {
+
+	squads, err := squad.GetSquadsByStatus(db.DB, squad.StatusDraft)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	open := []OpenSquad{}
+	for _, dbSquad := range squads {
+		if !squad.HasOpenSlot(dbSquad, db.Config.Squad) {
+			continue
+		}
+
+		open_slots := -1
+		if db.Config.Squad.MaxMembers > 0 {
+			open_slots = db.Config.Squad.MaxMembers - len(dbSquad.SquadMembers)
+		}
+		open = append(open, OpenSquad{ID: dbSquad.ID, Name: dbSquad.Name, Members: len(dbSquad.SquadMembers), OpenSlots: open_slots})
+	}
+
+	ctx.JSON(http.StatusOK, open)
+}
+
+// Invite user
+// @Security bearerAuth
+// @Summary Invite a user of the pool to the squad
+// @Description This method sends a join request from the squad of the leader to a user looking for a team.
+// @Tags Match
+// @Accept json
+// @Produce json
+// @Param request body RequestInput true "User and message"
+// @Schemes
+// @Success 200 {object} match.JoinRequest
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 409 {object} gin.H
+// @Router /match/invites [post]
+func (db Database) InviteUser(ctx *gin.Context) {
+
+	//init vars
+	var input RequestInput
+
+	//unmarshal sent json
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	//get values
+	session := middleware.ExtractTokenValues(ctx)
+
+	dbLeader, err := user.GetUserByID(db.DB, session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	dbSquad, err := squad.GetSquadByID(db.DB, dbLeader.SquadID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if !squad.HasOpenSlot(dbSquad, db.Config.Squad) {
+		ctx.JSON(http.StatusConflict, gin.H{"message": squad.ErrSquadFull.Error()})
+		return
+	}
+
+	// only the users of the pool can be invited
+	profile, err := GetProfile(db.DB, input.UserID)
+	if err != nil || !profile.Active {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": ErrNotInPool.Error()})
+		return
+	}
+	if dbUser, err := user.GetUserByID(db.DB, input.UserID); err != nil || dbUser.SquadID != 0 {
+		ctx.JSON(http.StatusConflict, gin.H{"message": ErrHasSquad.Error()})
+		return
+	}
+
+	if HasPendingRequest(db.DB, input.UserID, dbSquad.ID) {
+		ctx.JSON(http.StatusConflict, gin.H{"message": "a request is already pending"})
+		return
+	}
+
+	request, err := NewJoinRequest(db.DB, JoinRequest{
+		SquadID:   dbSquad.ID,
+		UserID:    input.UserID,
+		Kind:      KindInvite,
+		Status:    StatusPending,
+		Message:   input.Message,
+		CreatedBy: dbLeader.ID,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, request)
+}
+
+// Apply to squad
+// @Security bearerAuth
+// @Summary Ask to join a squad
+// @Description This method sends a join request from the user without squad to a squad with open slots.
+// @Tags Match
+// @Accept json
+// @Produce json
+// @Param request body RequestInput true "Squad and message"
+// @Schemes
+// @Success 200 {object} match.JoinRequest
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 409 {object} gin.H
+// @Router /match/requests [post]
+func (db Database) ApplySquad(ctx *gin.Context) {
+
+	//init vars
+	var input RequestInput
+
+	//unmarshal sent json
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	//get values
+	session := middleware.ExtractTokenValues(ctx)
+
+	dbUser, err := user.GetUserByID(db.DB, session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if dbUser.SquadID != 0 {
+		ctx.JSON(http.StatusConflict, gin.H{"message": ErrHasSquad.Error()})
+		return
+	}
+
+	dbSquad, err := squad.GetSquadByID(db.DB, input.SquadID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	}
+	if dbSquad.Status != squad.StatusDraft {
+		ctx.JSON(http.StatusConflict, gin.H{"message": squad.ErrSquadLocked.Error()})
+		return
+	}
+	if !squad.HasOpenSlot(dbSquad, db.Config.Squad) {
+		ctx.JSON(http.StatusConflict, gin.H{"message": squad.ErrSquadFull.Error()})
+		return
+	}
+
+	if HasPendingRequest(db.DB, dbUser.ID, dbSquad.ID) {
+		ctx.JSON(http.StatusConflict, gin.H{"message": "a request is already pending"})
+		return
+	}
+
+	request, err := NewJoinRequest(db.DB, JoinRequest{
+		SquadID:   dbSquad.ID,
+		UserID:    dbUser.ID,
+		Kind:      KindApply,
+		Status:    StatusPending,
+		Message:   input.Message,
+		CreatedBy: dbUser.ID,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, request)
+}
+
+// Get my requests
+// @Security bearerAuth
+// @Summary Get the join requests of the current user
+// @Description This method lists the requests sent and received by the user and, for a leader, by his squad.
+// @Tags Match
+// @Produce json
+// @Schemes
+// @Success 200 {array} match.JoinRequest
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Router /match/requests [get]
+func (db Database) GetMyRequests(ctx *gin.Context) {
+
+	//get values
+	session := middleware.ExtractTokenValues(ctx)
+
+	dbUser, err := user.GetUserByID(db.DB, session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// the requests of the squad are only shown to its leader
+	squad_id := uint(0)
+	if dbSquad, err := squad.GetSquadByID(db.DB, dbUser.SquadID); err == nil && dbSquad.CreatedBy == dbUser.ID {
+		squad_id = dbSquad.ID
+	}
+
+	requests, err := GetJoinRequests(db.DB, dbUser.ID, squad_id)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, requests)
+}
+
+// the user answers the request: the invited user or the leader of the squad applied to
+func (db Database) isRecipient(request JoinRequest, user_id uint) bool {
+
+	if request.Kind == KindInvite {
+		return request.UserID == user_id
+	}
+
+	dbSquad, err := squad.GetSquadByID(db.DB, request.SquadID)
+	return err == nil && dbSquad.CreatedBy == user_id
+}
+
+// load the request of the path
+func (db Database) pathRequest(ctx *gin.Context) (JoinRequest, bool) {
+
+	request_id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return JoinRequest{}, false
+	}
+
+	request, err := GetJoinRequestByID(db.DB, uint(request_id))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "request not found"})
+		return JoinRequest{}, false
+	}
+
+	return request, true
+}
+
+// Accept request
+// @Security bearerAuth
+// @Summary Accept a join request
+// @Description This method accepts an invite or an application, the user becomes a member of the squad.
+// @Tags Match
+// @Produce json
+// @Param id path int true "Request ID"
+// @Schemes
+// @Success 200 {object} gin.H
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Failure 409 {object} gin.H
+// @Router /match/requests/{id}/accept [post]
+func (db Database) AcceptRequest(ctx *gin.Context) {
+
+	request, ok := db.pathRequest(ctx)
+	if !ok {
+		return
+	}
+
+	//get values
+	session := middleware.ExtractTokenValues(ctx)
+
+	if !db.isRecipient(request, session.UserID) {
+		ctx.JSON(http.StatusForbidden, gin.H{"message": "You are not authorized"})
+		return
+	}
+
+	if err := JoinSquad(db.DB, db.Enforcer, db.Config, request); err != nil {
+		ctx.JSON(joinErrorStatus(err), gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "member added successfully"})
+}
+
+// Decline request
+// @Security bearerAuth
+// @Summary Decline a join request
+// @Description This method declines an invite or an application.
+// @Tags Match
+// @Produce json
+// @Param id path int true "Request ID"
+// @Schemes
+// @Success 200 {object} gin.H
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Failure 409 {object} gin.H
+// @Router /match/requests/{id}/decline [post]
+func (db Database) DeclineRequest(ctx *gin.Context) {
+
+	request, ok := db.pathRequest(ctx)
+	if !ok {
+		return
+	}
+
+	//get values
+	session := middleware.ExtractTokenValues(ctx)
+
+	if !db.isRecipient(request, session.UserID) {
+		ctx.JSON(http.StatusForbidden, gin.H{"message": "You are not authorized"})
+		return
+	}
+
+	affected, err := RespondJoinRequest(db.DB, request.ID, StatusDeclined)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if affected == 0 {
+		ctx.JSON(http.StatusConflict, gin.H{"message": ErrRequestClosed.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Request declined successfully"})
+}
+
+// Cancel request
+// @Security bearerAuth
+// @Summary Cancel a join request
+// @Description This method cancels a pending request sent by the current user.
+// @Tags Match
+// @Produce json
+// @Param id path int true "Request ID"
+// @Schemes
+// @Success 200 {object} gin.H
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Failure 409 {object} gin.H
+// @Router /match/requests/{id} [delete]
+func (db Database) CancelRequest(ctx *gin.Context) {
+
+	request, ok := db.pathRequest(ctx)
+	if !ok {
+		return
+	}
+
+	//get values
+	session := middleware.ExtractTokenValues(ctx)
+
+	if request.CreatedBy != session.UserID {
+		ctx.JSON(http.StatusForbidden, gin.H{"message": "You are not authorized"})
+		return
+	}
+
+	affected, err := RespondJoinRequest(db.DB, request.ID, StatusCancelled)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if affected == 0 {
+		ctx.JSON(http.StatusConflict, gin.H{"message": ErrRequestClosed.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Request cancelled successfully"})
+}
+
+// Run matching
+// @Security bearerAuth
+// @Summary Propose squads from the pool
+// @Description This method groups the users of the pool into balanced squad proposals.
+// @Tags Match
+// @Produce json
+// @Schemes
+// @Success 200 {array} match.MatchProposal
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Router /match/proposals [post]
+func (db Database) RunMatching(ctx *gin.Context) {
+
+	proposals, err := ProposeSquads(db.DB, db.Config)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	if proposals == nil {
+		proposals = []MatchProposal{}
+	}
+
+	ctx.JSON(http.StatusOK, proposals)
+}
+
+// Get proposals
+// @Security bearerAuth
+// @Summary Get the squad proposals
+// @Description This method lists the proposals of the matchmaking, the status query filters them.
+// @Tags Match
+// @Produce json
+// @Param status query string false "pending, accepted, declined or cancelled"
+// @Schemes
+// @Success 200 {array} match.MatchProposal
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Router /match/proposals [get]
+func (db Database) GetProposals(ctx *gin.Context) {
+
+	proposals, err := GetProposals(db.DB, ctx.Query("status"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, proposals)
+}
+
+// Get my proposal
+// @Security bearerAuth
+// @Summary Get the squad proposed to the current user
+// @Description This method returns the pending proposal including the user.
+// @Tags Match
+// @Produce json
+// @Schemes
+// @Success 200 {object} match.MatchProposal
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Router /match/proposal [get]
+func (db Database) GetMyProposal(ctx *gin.Context) {
+
+	//get values
+	session := middleware.ExtractTokenValues(ctx)
+
+	proposal, err := GetUserProposal(db.DB, session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "No proposal"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, proposal)
+}
+
+// Accept my proposal
+// @Security bearerAuth
+// @Summary Accept the squad proposed to the current user
+// @Description This method accepts the proposal, the squad is created once every member accepted.
+// @Tags Match
+// @Produce json
+// @Schemes
+// @Success 200 {object} match.MatchProposal
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Failure 409 {object} gin.H
+// @Router /match/proposal/accept [post]
+func (db Database) AcceptMyProposal(ctx *gin.Context) {
+
+	//get values
+	session := middleware.ExtractTokenValues(ctx)
+
+	proposal, err := GetUserProposal(db.DB, session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "No proposal"})
+		return
+	}
+
+	proposal, err = AcceptProposal(db.DB, db.Enforcer, db.Config, proposal.ID, session.UserID)
+	if err != nil {
+		ctx.JSON(joinErrorStatus(err), gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, proposal)
+}
+
+// Decline my proposal
+// @Security bearerAuth
+// @Summary Decline the squad proposed to the current user
+// @Description This method declines the proposal for every member, they stay in the pool.
+// @Tags Match
+// @Produce json
+// @Schemes
+// @Success 200 {object} gin.H
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Router /match/proposal/decline [post]
+func (db Database) DeclineMyProposal(ctx *gin.Context) {
+
+	//get values
+	session := middleware.ExtractTokenValues(ctx)
+
+	proposal, err := GetUserProposal(db.DB, session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "No proposal"})
+		return
+	}
+
+	if err := db.DB.Model(&MatchProposal{}).Where("id = ? AND status = ?", proposal.ID, StatusPending).Update("status", StatusDeclined).Error; err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Proposal declined successfully"})
+}
+
+// the tag is in the list
+func contains(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
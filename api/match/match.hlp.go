@@ -0,0 +1,391 @@
+package match
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/ezzddinne/api/squad"
+	"github.com/ezzddinne/api/user"
+	"github.com/ezzddinne/config"
+	"github.com/ezzddinne/middleware"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrHasSquad      = errors.New("user already has a squad")
+	ErrNotInPool     = errors.New("user is not looking for a team")
+	ErrRequestClosed = errors.New("request was already answered")
+)
+
+// most skills or interests kept on a profile
+const maxTags int = 20
+
+// size of the proposed squads when the squads have no max
+const defaultProposalSize int = 4
+
+// key of the postgres advisory lock held while proposing squads
+const matchLock int64 = 7365929
+
+// the request targets the pool profile or the requests of the user himself
+func OwnsPool() middleware.OwnerResolver {
+	return func(ctx *gin.Context, user_id uint) (bool, error) {
+		return user_id != 0, nil
+	}
+}
+
+// lowercase tags without duplicates
+func normalizeTags(tags []string) []string {
+
+	seen := map[string]bool{}
+	result := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+		if len(result) == maxTags {
+			break
+		}
+	}
+	return result
+}
+
+// change the main role of the user in the default domain
+func switchRole(enforcer *casbin.SyncedEnforcer, user_id uint, old_role, role string) error {
+
+	if old_role == role {
+		return nil
+	}
+
+	subject := middleware.Subject(user_id)
+	if old_role != "" {
+		if _, err := enforcer.RemoveGroupingPolicy(subject, old_role, middleware.DefaultDomain()); err != nil {
+			return err
+		}
+	}
+	_, err := enforcer.AddGroupingPolicy(subject, role, middleware.DefaultDomain())
+	return err
+}
+
+// move the user into the squad, in the transaction of the caller
+// the user leaves the pool and his other pending requests are cancelled
+func addMember(tx *gorm.DB, squad_id, user_id uint, role string) error {
+
+	result := tx.Model(&user.User{}).Where("id = ? AND (squad_id = 0 OR squad_id IS NULL)", user_id).
+		Updates(map[string]interface{}{"squad_id": squad_id, "role": role})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrHasSquad
+	}
+
+	if err := tx.Model(&PoolProfile{}).Where("user_id = ?", user_id).Update("active", false).Error; err != nil {
+		return err
+	}
+
+	return tx.Model(&JoinRequest{}).Where("user_id = ? AND status = ?", user_id, StatusPending).
+		Updates(map[string]interface{}{"status": StatusCancelled, "responded_at": time.Now()}).Error
+}
+
+// accept the join request: the user becomes a member of the draft squad if it has a slot left
+func JoinSquad(db *gorm.DB, enforcer *casbin.SyncedEnforcer, cfg *config.Config, request JoinRequest) error {
+
+	dbUser, err := user.GetUserByID(db, request.UserID)
+	if err != nil {
+		return err
+	}
+	if dbUser.SquadID != 0 {
+		return ErrHasSquad
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+
+		// the row of the squad is locked while counting its slots
+		dbSquad, err := squad.LockSquadByID(tx, request.SquadID)
+		if err != nil {
+			return err
+		}
+		if dbSquad.Status != squad.StatusDraft {
+			return squad.ErrSquadLocked
+		}
+		if !squad.HasOpenSlot(dbSquad, cfg.Squad) {
+			return squad.ErrSquadFull
+		}
+
+		affected, err := RespondJoinRequest(tx, request.ID, StatusAccepted)
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return ErrRequestClosed
+		}
+
+		if err := addMember(tx, dbSquad.ID, dbUser.ID, cfg.RBAC.DefaultUser); err != nil {
+			return err
+		}
+
+		dbSquad.SquadMembers = append(dbSquad.SquadMembers, int32(dbUser.ID))
+		return squad.UpdateSquadMembers(tx, dbSquad)
+	})
+	if err != nil {
+		return err
+	}
+
+	return switchRole(enforcer, dbUser.ID, dbUser.Role, cfg.RBAC.DefaultUser)
+}
+
+// propose balanced squads from the pool, the users already proposed are left out
+// the instances propose one at a time so a user is never in two proposals
+func ProposeSquads(db *gorm.DB, cfg *config.Config) ([]MatchProposal, error) {
+
+	var proposals []MatchProposal
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", matchLock).Error; err != nil {
+			return err
+		}
+
+		var err error
+		proposals, err = proposeSquads(tx, cfg)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return proposals, nil
+}
+
+// every user goes to the squad he brings the most new skills to, the smallest one on a tie
+func proposeSquads(db *gorm.DB, cfg *config.Config) ([]MatchProposal, error) {
+
+	pool, err := GetPool(db)
+	if err != nil {
+		return nil, err
+	}
+
+	pending, err := GetProposals(db, StatusPending)
+	if err != nil {
+		return nil, err
+	}
+	proposed := map[uint]bool{}
+	for _, proposal := range pending {
+		for _, member := range proposal.Members {
+			proposed[uint(member)] = true
+		}
+	}
+
+	var profiles []PoolProfile
+	for _, profile := range pool {
+		if !proposed[profile.UserID] {
+			profiles = append(profiles, profile)
+		}
+	}
+
+	size := cfg.Squad.MaxMembers
+	if size == 0 {
+		size = defaultProposalSize
+	}
+	if len(profiles) < cfg.Squad.MinMembers {
+		return nil, nil
+	}
+
+	// the most skilled users are placed first
+	sort.SliceStable(profiles, func(i, j int) bool {
+		return len(profiles[i].Skills) > len(profiles[j].Skills)
+	})
+
+	type group struct {
+		members []int32
+		skills  map[string]bool
+	}
+	groups := make([]group, (len(profiles)+size-1)/size)
+	for i := range groups {
+		groups[i].skills = map[string]bool{}
+	}
+
+	for _, profile := range profiles {
+		best, best_gain := -1, -1
+		for i, g := range groups {
+			if len(g.members) >= size {
+				continue
+			}
+			gain := 0
+			for _, skill := range profile.Skills {
+				if !g.skills[skill] {
+					gain++
+				}
+			}
+			if gain > best_gain || (gain == best_gain && len(g.members) < len(groups[best].members)) {
+				best, best_gain = i, gain
+			}
+		}
+		groups[best].members = append(groups[best].members, int32(profile.UserID))
+		for _, skill := range profile.Skills {
+			groups[best].skills[skill] = true
+		}
+	}
+
+	var proposals []MatchProposal
+	for _, g := range groups {
+		if len(g.members) < cfg.Squad.MinMembers {
+			continue
+		}
+
+		skills := make([]string, 0, len(g.skills))
+		for skill := range g.skills {
+			skills = append(skills, skill)
+		}
+		sort.Strings(skills)
+
+		proposal, err := NewProposal(db, MatchProposal{Members: g.members, Accepted: []int32{}, Skills: skills, Status: StatusPending})
+		if err != nil {
+			return proposals, err
+		}
+		proposals = append(proposals, proposal)
+	}
+
+	return proposals, nil
+}
+
+// the user is in the list of ids
+func containsID(ids []int32, user_id uint) bool {
+	for _, id := range ids {
+		if uint(id) == user_id {
+			return true
+		}
+	}
+	return false
+}
+
+// get the pending proposal of a user
+func GetUserProposal(db *gorm.DB, user_id uint) (MatchProposal, error) {
+
+	proposals, err := GetProposals(db, StatusPending)
+	if err != nil {
+		return MatchProposal{}, err
+	}
+
+	for _, proposal := range proposals {
+		if containsID(proposal.Members, user_id) {
+			return proposal, nil
+		}
+	}
+
+	return MatchProposal{}, gorm.ErrRecordNotFound
+}
+
+// the user accepts the proposal, the squad is created when every member accepted
+// the first member leads the squad, the proposal is cancelled if a member found a squad meanwhile
+func AcceptProposal(db *gorm.DB, enforcer *casbin.SyncedEnforcer, cfg *config.Config, proposal_id, user_id uint) (MatchProposal, error) {
+
+	var proposal MatchProposal
+	var new_squad squad.Squad
+	roles := map[uint]string{}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+
+		// the members accepting at once wait for each other on the row of the proposal
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", proposal_id).First(&proposal).Error; err != nil {
+			return err
+		}
+		if proposal.Status != StatusPending || !containsID(proposal.Members, user_id) {
+			return ErrRequestClosed
+		}
+
+		if !containsID(proposal.Accepted, user_id) {
+			proposal.Accepted = append(proposal.Accepted, int32(user_id))
+			if err := tx.Model(&MatchProposal{}).Where("id = ?", proposal.ID).Update("accepted", proposal.Accepted).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, member := range proposal.Members {
+			if !containsID(proposal.Accepted, uint(member)) {
+				return nil
+			}
+		}
+
+		// roles before the squad is created
+		for _, member := range proposal.Members {
+			dbUser, err := user.GetUserByID(tx, uint(member))
+			if err != nil {
+				return err
+			}
+			roles[dbUser.ID] = dbUser.Role
+		}
+
+		var err error
+		new_squad, err = squad.NewSquad(tx, squad.Squad{
+			Name:         fmt.Sprintf("Matched squad %d", proposal.ID),
+			CreatedBy:    uint(proposal.Members[0]),
+			SquadMembers: proposal.Members,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, member := range proposal.Members {
+			if err := addMember(tx, new_squad.ID, uint(member), proposalRole(cfg, new_squad, uint(member))); err != nil {
+				return err
+			}
+		}
+
+		proposal.Status = StatusAccepted
+		proposal.SquadID = &new_squad.ID
+		return tx.Model(&MatchProposal{}).Where("id = ?", proposal.ID).
+			Updates(map[string]interface{}{"status": proposal.Status, "squad_id": new_squad.ID}).Error
+	})
+	if errors.Is(err, ErrHasSquad) {
+		db.Model(&MatchProposal{}).Where("id = ?", proposal.ID).Update("status", StatusCancelled)
+		proposal.Status = StatusCancelled
+		return proposal, err
+	}
+	if err != nil || proposal.Status != StatusAccepted {
+		return proposal, err
+	}
+
+	for _, member := range proposal.Members {
+		if err := switchRole(enforcer, uint(member), roles[uint(member)], proposalRole(cfg, new_squad, uint(member))); err != nil {
+			return proposal, err
+		}
+	}
+
+	return proposal, nil
+}
+
+// role of a member of the matched squad
+func proposalRole(cfg *config.Config, new_squad squad.Squad, user_id uint) string {
+	if new_squad.CreatedBy == user_id {
+		return "leader"
+	}
+	return cfg.RBAC.DefaultUser
+}
+
+// propose squads from the pool every interval
+func StartMatchWorker(db *gorm.DB, cfg *config.Config, interval time.Duration) {
+
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if _, err := ProposeSquads(db, cfg); err != nil {
+				log.Println("[WARNING] match worker:", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,171 @@
+package match
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// join requests: the leader invites a solo user or the solo user applies to a squad
+const (
+	KindInvite string = "invite"
+	KindApply  string = "apply"
+)
+
+// statuses of the join requests and of the proposals
+const (
+	StatusPending   string = "pending"
+	StatusAccepted  string = "accepted"
+	StatusDeclined  string = "declined"
+	StatusCancelled string = "cancelled"
+)
+
+// profile of a user looking for a team
+type PoolProfile struct {
+	ID        uint           `gorm:"column:id;autoIncrement;primaryKey" json:"id"`
+	UserID    uint           `gorm:"column:user_id;not null;unique" json:"user_id"`
+	Skills    pq.StringArray `gorm:"column:skills;type:text[]" json:"skills"`
+	Interests pq.StringArray `gorm:"column:interests;type:text[]" json:"interests"`
+	Bio       string         `gorm:"column:bio" json:"bio"`
+	Active    bool           `gorm:"column:active;not null" json:"active"`
+
+	gorm.Model
+}
+
+// request of a user to join a squad or invite of a leader
+type JoinRequest struct {
+	ID          uint       `gorm:"column:id;autoIncrement;primaryKey" json:"id"`
+	SquadID     uint       `gorm:"column:squad_id;not null;index" json:"squad_id"`
+	UserID      uint       `gorm:"column:user_id;not null;index" json:"user_id"`
+	Kind        string     `gorm:"column:kind;not null" json:"kind"`
+	Status      string     `gorm:"column:status;not null" json:"status"`
+	Message     string     `gorm:"column:message" json:"message"`
+	CreatedBy   uint       `gorm:"column:created_by;not null" json:"created_by"`
+	RespondedAt *time.Time `gorm:"column:responded_at" json:"responded_at"`
+
+	gorm.Model
+}
+
+// squad proposed by the matchmaking, created once every member accepts
+type MatchProposal struct {
+	ID       uint           `gorm:"column:id;autoIncrement;primaryKey" json:"id"`
+	Members  pq.Int32Array  `gorm:"column:members;type:integer[]" json:"members"`
+	Accepted pq.Int32Array  `gorm:"column:accepted;type:integer[]" json:"accepted"`
+	Skills   pq.StringArray `gorm:"column:skills;type:text[]" json:"skills"`
+	Status   string         `gorm:"column:status;not null" json:"status"`
+	SquadID  *uint          `gorm:"column:squad_id" json:"squad_id"`
+
+	gorm.Model
+}
+
+// user of the pool as seen by the leaders, without contact details
+type PoolEntry struct {
+	UserID     uint     `json:"user_id"`
+	FirstName  string   `json:"firstname"`
+	LastName   string   `json:"lastname"`
+	University string   `json:"university"`
+	Skills     []string `json:"skills"`
+	Interests  []string `json:"interests"`
+	Bio        string   `json:"bio"`
+}
+
+// squad with open slots as seen by the solo users
+type OpenSquad struct {
+	ID        uint   `json:"id"`
+	Name      string `json:"name"`
+	Members   int    `json:"members"`
+	OpenSlots int    `json:"open_slots"`
+}
+
+type ProfileInput struct {
+	Skills    []string `json:"skills"`
+	Interests []string `json:"interests"`
+	Bio       string   `json:"bio"`
+	Active    *bool    `json:"active"`
+}
+
+type RequestInput struct {
+	UserID  uint   `json:"user_id"`
+	SquadID uint   `json:"squad_id"`
+	Message string `json:"message"`
+}
+
+// get the profile of a user
+func GetProfile(db *gorm.DB, user_id uint) (profile PoolProfile, err error) {
+	return profile, db.Where("user_id = ?", user_id).First(&profile).Error
+}
+
+// create or replace the profile of a user
+func SaveProfile(db *gorm.DB, profile PoolProfile) (PoolProfile, error) {
+
+	existing, err := GetProfile(db, profile.UserID)
+	if err != nil {
+		return profile, db.Create(&profile).Error
+	}
+
+	profile.ID = existing.ID
+	profile.CreatedAt = existing.CreatedAt
+	return profile, db.Model(&PoolProfile{}).Where("id = ?", existing.ID).
+		Select("skills", "interests", "bio", "active").Updates(&profile).Error
+}
+
+// delete the profile of a user, for good as the user can join the pool again
+func DeleteProfile(db *gorm.DB, user_id uint) error {
+	return db.Unscoped().Where("user_id = ?", user_id).Delete(&PoolProfile{}).Error
+}
+
+// get the active profiles of the users without squad
+func GetPool(db *gorm.DB) (profiles []PoolProfile, err error) {
+	return profiles, db.Where("active = ? AND user_id IN (?)", true,
+		db.Table("users").Select("id").Where("(squad_id = 0 OR squad_id IS NULL) AND deleted_at IS NULL")).
+		Order("id").Find(&profiles).Error
+}
+
+// create new join request
+func NewJoinRequest(db *gorm.DB, request JoinRequest) (JoinRequest, error) {
+	return request, db.Create(&request).Error
+}
+
+// get join request by id
+func GetJoinRequestByID(db *gorm.DB, request_id uint) (request JoinRequest, err error) {
+	return request, db.Where("id = ?", request_id).First(&request).Error
+}
+
+// get the join requests of a user or of a squad
+func GetJoinRequests(db *gorm.DB, user_id, squad_id uint) (requests []JoinRequest, err error) {
+	return requests, db.Where("user_id = ? OR squad_id = ?", user_id, squad_id).Order("created_at desc").Find(&requests).Error
+}
+
+// a request between the user and the squad is waiting for an answer
+func HasPendingRequest(db *gorm.DB, user_id, squad_id uint) bool {
+	var count int64
+	db.Model(&JoinRequest{}).Where("user_id = ? AND squad_id = ? AND status = ?", user_id, squad_id, StatusPending).Count(&count)
+	return count > 0
+}
+
+// answer a pending join request
+func RespondJoinRequest(db *gorm.DB, request_id uint, status string) (int64, error) {
+	result := db.Model(&JoinRequest{}).Where("id = ? AND status = ?", request_id, StatusPending).
+		Updates(map[string]interface{}{"status": status, "responded_at": time.Now()})
+	return result.RowsAffected, result.Error
+}
+
+// create new proposal
+func NewProposal(db *gorm.DB, proposal MatchProposal) (MatchProposal, error) {
+	return proposal, db.Create(&proposal).Error
+}
+
+// get proposal by id
+func GetProposalByID(db *gorm.DB, proposal_id uint) (proposal MatchProposal, err error) {
+	return proposal, db.Where("id = ?", proposal_id).First(&proposal).Error
+}
+
+// get the proposals in a status, all of them when status is empty
+func GetProposals(db *gorm.DB, status string) (proposals []MatchProposal, err error) {
+	query := db.Order("id")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	return proposals, query.Find(&proposals).Error
+}
@@ -0,0 +1,43 @@
+package match
+
+import (
+	"github.com/casbin/casbin/v2"
+	"github.com/ezzddinne/api/squad"
+	"github.com/ezzddinne/config"
+	"github.com/ezzddinne/middleware"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func RoutesMatchJWT(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.SyncedEnforcer, cfg *config.Config) {
+
+	baseInstance := Database{DB: db, Enforcer: enforcer, Config: cfg}
+
+	// pool profile of the user looking for a team
+	router.GET("/profile", middleware.AuthorizeResource("pool", "read", enforcer, OwnsPool()), baseInstance.GetMyProfile)
+	router.PUT("/profile", middleware.AuthorizeResource("pool", "write", enforcer, OwnsPool()), baseInstance.UpdateMyProfile)
+	router.DELETE("/profile", middleware.AuthorizeResource("pool", "write", enforcer, OwnsPool()), baseInstance.DeleteMyProfile)
+
+	// leaders browse the pool and invite
+	router.GET("/pool", middleware.AuthorizeResource("squads", "write", enforcer, squad.LeadsOwnSquad(db)), baseInstance.GetPool)
+	router.POST("/invites", middleware.AuthorizeResource("squads", "write", enforcer, squad.LeadsOwnSquad(db)), squad.EditableSquad(db), baseInstance.InviteUser)
+
+	// solo users browse the open squads and apply
+	router.GET("/squads", middleware.AuthorizeResource("pool", "read", enforcer, OwnsPool()), baseInstance.GetOpenSquads)
+	router.POST("/requests", middleware.AuthorizeResource("pool", "write", enforcer, OwnsPool()), baseInstance.ApplySquad)
+
+	// requests sent and received, the handlers check the user answers them
+	router.GET("/requests", middleware.AuthorizeResource("pool", "read", enforcer, OwnsPool()), baseInstance.GetMyRequests)
+	router.POST("/requests/:id/accept", middleware.AuthorizeResource("pool", "write", enforcer, OwnsPool()), baseInstance.AcceptRequest)
+	router.POST("/requests/:id/decline", middleware.AuthorizeResource("pool", "write", enforcer, OwnsPool()), baseInstance.DeclineRequest)
+	router.DELETE("/requests/:id", middleware.AuthorizeResource("pool", "write", enforcer, OwnsPool()), baseInstance.CancelRequest)
+
+	// squads proposed by the matchmaking
+	router.POST("/proposals", middleware.Authorize("matches", "write", enforcer), baseInstance.RunMatching)
+	router.GET("/proposals", middleware.Authorize("matches", "read", enforcer), baseInstance.GetProposals)
+
+	// proposal of the user
+	router.GET("/proposal", middleware.AuthorizeResource("pool", "read", enforcer, OwnsPool()), baseInstance.GetMyProposal)
+	router.POST("/proposal/accept", middleware.AuthorizeResource("pool", "write", enforcer, OwnsPool()), baseInstance.AcceptMyProposal)
+	router.POST("/proposal/decline", middleware.AuthorizeResource("pool", "write", enforcer, OwnsPool()), baseInstance.DeclineMyProposal)
+}
@@ -0,0 +1,104 @@
+package squad
+
+import (
+	"net/http"
+
+	"github.com/ezzddinne/api/form"
+	"github.com/ezzddinne/api/user"
+	"github.com/ezzddinne/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// Get my squad answers
+// @Security bearerAuth
+// @Summary Get the answers of the squad of the current user
+// @Description This method returns the answers of the squad to the squad questions of the registration form.
+// @Tags Squad
+// @Produce json
+// @Schemes
+// @Success 200 {object} form.Answers
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Router /auth/jwt/squad/answers [get]
+func (db Database) GetMySquadAnswers(ctx *gin.Context) {
+
+	//get values
+	session := middleware.ExtractTokenValues(ctx)
+
+	dbUser, err := user.GetUserByID(db.DB, session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	answers, err := form.SquadAnswers(db.DB, form.RegistrationDomain(), dbUser.SquadID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, answers)
+}
+
+// Update my squad answers
+// @Security bearerAuth
+// @Summary Update the answers of the squad of the current user
+// @Description This method updates the given answers of the draft squad of the leader, a blank answer clears the previous one.
+// @Tags Squad
+// @Accept json
+// @Produce json
+// @Param request body form.Answers true "Answers by field key"
+// @Schemes
+// @Success 200 {object} form.Answers
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 423 {object} gin.H
+// @Router /auth/jwt/squad/answers [put]
+func (db Database) UpdateMySquadAnswers(ctx *gin.Context) {
+
+	//init vars
+	var input form.Answers
+	domain := form.RegistrationDomain()
+
+	//unmarshal sent json
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	//get values
+	session := middleware.ExtractTokenValues(ctx)
+
+	dbLeader, err := user.GetUserByID(db.DB, session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	previous, err := form.SquadAnswers(db.DB, domain, dbLeader.SquadID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	answers, err := form.ValidateAnswers(db.DB, domain, form.ScopeSquad, form.MergeAnswers(previous, input), dbLeader.ID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, form.ErrorResponse(err))
+		return
+	}
+
+	if err := form.SaveSquadAnswers(db.DB, dbLeader.SquadID, answers); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	saved, err := form.SquadAnswers(db.DB, domain, dbLeader.SquadID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, saved)
+}
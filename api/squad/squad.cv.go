@@ -0,0 +1,311 @@
+package squad
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/ezzddinne/api/file"
+	"github.com/ezzddinne/api/user"
+	"github.com/ezzddinne/config"
+	"github.com/ezzddinne/middleware"
+	"github.com/ezzddinne/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// Get my CV
+// @Security bearerAuth
+// @Summary Get the CV of the current user
+// @Description This method returns the CV file of the logged in member.
+// @Tags Squad
+// @Produce json
+// @Schemes
+// @Success 200 {object} file.File
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Router /auth/jwt/cv [get]
+func (db Database) GetMyCV(ctx *gin.Context) {
+
+	//get values
+	session := middleware.ExtractTokenValues(ctx)
+
+	// get user by ID
+	dbUser, err := user.GetUserByID(db.DB, session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	if dbUser.CvFileID == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "No CV uploaded"})
+		return
+	}
+
+	// get cv file
+	dbFile, err := file.GetFileByID(db.DB, *dbUser.CvFileID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dbFile)
+}
+
+// Upload my CV
+// @Security bearerAuth
+// @Summary Upload or replace the CV of the current user
+// @Description This method uploads the pdf CV of the logged in member, the previous CV is deleted.
+// @Tags Squad
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "CV pdf"
+// @Schemes
+// @Success 200 {object} file.File
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 413 {object} gin.H
+// @Failure 422 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /auth/jwt/cv [put]
+func (db Database) UploadCV(ctx *gin.Context) {
+
+	// the request can't be much larger than the cv
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, db.Config.Upload.CVMaxBytes()+file.MultipartOverhead)
+
+	//upload
+	formFile, formHeader, err := ctx.Request.FormFile("file")
+	if err != nil {
+		if file.UploadErrorStatus(err) == http.StatusRequestEntityTooLarge {
+			ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{"message": file.ErrTooLarge.Error()})
+			return
+		}
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Select a file to upload"})
+		return
+	}
+	defer formFile.Close()
+
+	//get values
+	session := middleware.ExtractTokenValues(ctx)
+
+	// get user by ID
+	dbUser, err := user.GetUserByID(db.DB, session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	uploaded, err := NewMediaUpload(db.DB, db.Storage, db.Scanner, db.Config.Upload).FileUpload(File{File: formFile, Name: formHeader.Filename, SquadID: dbUser.SquadID, UploadedBy: session.UserID})
+	if err != nil {
+		ctx.JSON(file.UploadErrorStatus(err), gin.H{"message": err.Error()})
+		return
+	}
+
+	// attach the new cv, the stored file is removed if it can't be attached
+	if err := user.SetUserCV(db.DB, dbUser.ID, &uploaded.ID); err != nil {
+		file.Remove(ctx.Request.Context(), db.DB, db.Storage, uploaded)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	// remove the replaced cv
+	if dbUser.CvFileID != nil {
+		removeCV(ctx, db, *dbUser.CvFileID)
+	}
+
+	ctx.JSON(http.StatusOK, uploaded)
+}
+
+// Delete my CV
+// @Security bearerAuth
+// @Summary Delete the CV of the current user
+// @Description This method deletes the CV of the logged in member.
+// @Tags Squad
+// @Produce json
+// @Schemes
+// @Success 200 {string} string "CV deleted successfully"
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Router /auth/jwt/cv [delete]
+func (db Database) DeleteMyCV(ctx *gin.Context) {
+
+	//get values
+	session := middleware.ExtractTokenValues(ctx)
+
+	// get user by ID
+	dbUser, err := user.GetUserByID(db.DB, session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	if dbUser.CvFileID == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "No CV uploaded"})
+		return
+	}
+
+	// detach the cv
+	if err := user.SetUserCV(db.DB, dbUser.ID, nil); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	removeCV(ctx, db, *dbUser.CvFileID)
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "CV deleted successfully"})
+}
+
+// Get my squad CVs
+// @Security bearerAuth
+// @Summary Get the CVs of the leader's squad
+// @Description This method lists the members of the leader's squad with their CV.
+// @Tags Squad
+// @Produce json
+// @Schemes
+// @Success 200 {array} SquadCV
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Router /auth/jwt/squad/cvs [get]
+func (db Database) GetMySquadCVs(ctx *gin.Context) {
+
+	//get values
+	session := middleware.ExtractTokenValues(ctx)
+
+	// get user by ID
+	dbUser, err := user.GetUserByID(db.DB, session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	cvs, err := GetSquadCVs(db.DB, dbUser.SquadID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, cvs)
+}
+
+// Get squad CVs
+// @Security bearerAuth
+// @Summary Get the CVs of a squad
+// @Description This method lists the members of the squad with their CV.
+// @Tags Squad
+// @Produce json
+// @Param id path int true "Squad ID"
+// @Schemes
+// @Success 200 {array} SquadCV
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Router /auth/jwt/squad/{id}/cvs [get]
+func (db Database) GetSquadCVsByID(ctx *gin.Context) {
+
+	// get id value from path
+	squad_id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// check squad exists
+	if _, err := GetSquadByID(db.DB, uint(squad_id)); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	cvs, err := GetSquadCVs(db.DB, uint(squad_id))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, cvs)
+}
+
+// members of the squad with their cv, nil when missing
+func GetSquadCVs(db *gorm.DB, squad_id uint) ([]SquadCV, error) {
+
+	cvs := []SquadCV{}
+	if squad_id == 0 {
+		return cvs, nil
+	}
+
+	members, err := user.GetMembersBySquadID(db, squad_id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, member := range members {
+		cv := SquadCV{UserID: member.ID, FirstName: member.FirstName, LastName: member.LastName, Email: member.Email}
+		if member.CvFileID != nil {
+			dbFile, err := file.GetFileByID(db, *member.CvFileID)
+			if err != nil && err != gorm.ErrRecordNotFound {
+				return nil, err
+			}
+			if err == nil {
+				cv.CV = &dbFile
+			}
+		}
+		cvs = append(cvs, cv)
+	}
+
+	return cvs, nil
+}
+
+// remove a detached cv, the failure is only logged since the user no longer references it
+func removeCV(ctx *gin.Context, db Database, file_id uint) {
+
+	dbFile, err := file.GetFileByID(db.DB, file_id)
+	if err != nil {
+		log.Printf("[WARNING] cv file %d: %v", file_id, err)
+		return
+	}
+
+	if err := file.Remove(ctx.Request.Context(), db.DB, db.Storage, dbFile); err != nil {
+		log.Printf("[WARNING] remove cv file %d: %v", file_id, err)
+	}
+}
+
+// delete the public cvs uploaded before the cvs were files, their urls are hidden from the api
+// the members upload them again as private files, the failed ones are retried at the next start
+func PurgeLegacyCVs(db *gorm.DB, cfg config.CloudinaryConfig) error {
+
+	var squads []Squad
+	if err := db.Unscoped().Where("cv_urls IS NOT NULL AND cv_urls <> '{}'").Find(&squads).Error; err != nil {
+		return err
+	}
+	if len(squads) == 0 {
+		return nil
+	}
+
+	if cfg.CloudName == "" {
+		log.Println("[WARNING] the public cvs of", len(squads), "squads can't be deleted without the cloudinary credentials")
+		return nil
+	}
+
+	for _, squad := range squads {
+
+		var kept pq.StringArray
+		for _, cv_url := range squad.CvURLS {
+			if err := storage.DeletePublicURL(context.Background(), cfg, cv_url); err != nil {
+				log.Println("[WARNING] failed to delete the public cv", cv_url, ":", err)
+				kept = append(kept, cv_url)
+			}
+		}
+
+		if err := db.Unscoped().Model(&Squad{}).Where("id = ?", squad.ID).Update("cv_urls", kept).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -2,20 +2,26 @@ package squad
 
 import (
 	"net/http"
-	"os"
-	"regexp"
 	"strconv"
 
 	"github.com/casbin/casbin/v2"
+	"github.com/ezzddinne/api/file"
+	"github.com/ezzddinne/api/form"
 	"github.com/ezzddinne/api/user"
+	"github.com/ezzddinne/config"
 	"github.com/ezzddinne/middleware"
+	"github.com/ezzddinne/scanner"
+	"github.com/ezzddinne/storage"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type Database struct {
 	DB       *gorm.DB
-	Enforcer *casbin.Enforcer
+	Enforcer *casbin.SyncedEnforcer
+	Config   *config.Config
+	Storage  storage.Storage
+	Scanner  scanner.Scanner
 }
 
 // create a squad
@@ -37,7 +43,7 @@ func (db Database) CreateSquad(ctx *gin.Context) {
 
 	//init vars
 	var squad Squad
-	empty_reg, _ := regexp.Compile(os.Getenv("EMPTY_REGEX"))
+	empty_reg := db.Config.EmptyRegexp()
 
 	//check json validity
 	if err := ctx.ShouldBindJSON(&squad); err != nil {
@@ -60,6 +66,13 @@ func (db Database) CreateSquad(ctx *gin.Context) {
 		return
 	}
 
+	// check the answers to the squad questions
+	answers, err := form.ValidateAnswers(db.DB, form.RegistrationDomain(), form.ScopeSquad, squad.Answers, dbLeader.ID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, form.ErrorResponse(err))
+		return
+	}
+
 	//Check user exist
 	// if exist can't create another Squad
 	if CheckUserCreateSquad(db.DB, dbLeader.ID) {
@@ -84,6 +97,12 @@ func (db Database) CreateSquad(ctx *gin.Context) {
 			return
 		}
 
+		// save the answers
+		if err := form.SaveSquadAnswers(db.DB, new_squad_created.ID, answers); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
 		//squad created succsessfully
 		ctx.JSON(http.StatusOK, gin.H{"message": "Squad created successfully"})
 
@@ -99,7 +118,7 @@ func (db Database) CreateSquad(ctx *gin.Context) {
 		subject := "Payment Process"
 
 		// Send Email
-		user.SendValidationGomail(subject, dbLeader.Email, "api/user/Validation.html", dbLeader)
+		user.SendValidationGomail(db.Config.Email, subject, dbLeader.Email, "api/user/Validation.html", dbLeader)
 
 		ctx.JSON(http.StatusOK, gin.H{"message": "Mail sended successfully"})
 
@@ -107,10 +126,16 @@ func (db Database) CreateSquad(ctx *gin.Context) {
 
 }
 
-// Get all squads
+// Get all squads, the status query filters them
 func (db Database) GetAllSquads(ctx *gin.Context) {
 
-	squads, err := GetAllSquads(db.DB)
+	var squads []Squad
+	var err error
+	if status := ctx.Query("status"); status != "" {
+		squads, err = GetSquadsByStatus(db.DB, status)
+	} else {
+		squads, err = GetAllSquads(db.DB)
+	}
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 		return
@@ -229,103 +254,32 @@ func (db Database) DeleteSquad(ctx *gin.Context) {
 // @Failure 400 {object} gin.H
 // @Failure 401 {object} gin.H
 // @Failure 403 {object} gin.H
+// @Failure 413 {object} gin.H
+// @Failure 422 {object} gin.H
 // @Failure 500 {object} gin.H
 // @Router /auth/jwt/image [post]
 func (db Database) ImageUpload() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		//upload
-		formFile, _, err := c.Request.FormFile("file")
-		if err != nil {
-			c.JSON(
-				http.StatusInternalServerError,
-				MediaDto{
-					StatusCode: http.StatusInternalServerError,
-					Message:    "error",
-					Data:       map[string]interface{}{"data": "Select a file to upload"},
-				})
-			return
-		}
-
-		//get values
-		session := middleware.ExtractTokenValues(c)
-
-		// get user by ID
-		dbUser, err := user.GetUserByID(db.DB, session.UserID)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
-			return
-		}
-
-		// get Squad by ID
-		dbSquad, err := GetSquadByID(db.DB, dbUser.SquadID)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
-			return
-		}
-
-		uploadUrl, err := NewMediaUpload().ImageUpload(File{File: formFile})
-		if err != nil {
-			c.JSON(
-				http.StatusInternalServerError,
-				MediaDto{
-					StatusCode: http.StatusInternalServerError,
-					Message:    "error",
-					Data:       map[string]interface{}{"data": "Error uploading file"},
-				})
-			return
-		}
-
-		c.JSON(
-			http.StatusOK,
-			MediaDto{
-				StatusCode: http.StatusOK,
-				Message:    "success",
-				Data:       map[string]interface{}{"data": uploadUrl + " : " + dbSquad.Name},
-			})
+		// the request can't be much larger than the logo
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, db.Config.Upload.LogoMaxBytes()+file.MultipartOverhead)
 
-		dbSquad.LogoURL = uploadUrl
-
-		// update squad
-		if err := UpdateSquad(db.DB, dbSquad); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{"message": "Squad updated successfully"})
-
-	}
-
-}
-
-// File Upload
-// @Security bearerAuth
-// @Summary Upload CV for squad member
-// @Description This method is for CV uploading.
-// @Tags Squad
-// @Accept json
-// @Produce json
-// @Param request body Squad true "Squad required fields"
-// @Schemes
-// @Success 200 {string} string "CV uploaded"
-// @Failure 400 {object} gin.H
-// @Failure 401 {object} gin.H
-// @Failure 403 {object} gin.H
-// @Failure 500 {object} gin.H
-// @Router /auth/jwt/file [post]
-func (db Database) FileUpload() gin.HandlerFunc {
-	return func(c *gin.Context) {
 		//upload
-		formFile, _, err := c.Request.FormFile("file")
+		formFile, formHeader, err := c.Request.FormFile("file")
 		if err != nil {
+			status, message := http.StatusInternalServerError, "Select a file to upload"
+			if file.UploadErrorStatus(err) == http.StatusRequestEntityTooLarge {
+				status, message = http.StatusRequestEntityTooLarge, file.ErrTooLarge.Error()
+			}
 			c.JSON(
-				http.StatusInternalServerError,
+				status,
 				MediaDto{
-					StatusCode: http.StatusInternalServerError,
+					StatusCode: status,
 					Message:    "error",
-					Data:       map[string]interface{}{"data": "Select a file to upload"},
+					Data:       map[string]interface{}{"data": message},
 				})
 			return
 		}
+		defer formFile.Close()
 
 		//get values
 		session := middleware.ExtractTokenValues(c)
@@ -344,14 +298,15 @@ func (db Database) FileUpload() gin.HandlerFunc {
 			return
 		}
 
-		uploadUrl, err := NewMediaUpload().FileUpload(File{File: formFile})
+		uploaded, err := NewMediaUpload(db.DB, db.Storage, db.Scanner, db.Config.Upload).ImageUpload(File{File: formFile, Name: formHeader.Filename, SquadID: dbSquad.ID, UploadedBy: session.UserID})
 		if err != nil {
+			status := file.UploadErrorStatus(err)
 			c.JSON(
-				http.StatusInternalServerError,
+				status,
 				MediaDto{
-					StatusCode: http.StatusInternalServerError,
+					StatusCode: status,
 					Message:    "error",
-					Data:       map[string]interface{}{"data": "Error uploading file"},
+					Data:       map[string]interface{}{"data": err.Error()},
 				})
 			return
 		}
@@ -361,13 +316,10 @@ func (db Database) FileUpload() gin.HandlerFunc {
 			MediaDto{
 				StatusCode: http.StatusOK,
 				Message:    "success",
-				Data:       map[string]interface{}{"data": uploadUrl + " : " + dbSquad.Name},
+				Data:       map[string]interface{}{"data": file.SignedURL(db.Config.Storage, uploaded, session.UserID).URL + " : " + dbSquad.Name},
 			})
 
-		//init vars
-		var stringCVs []string
-		stringCVs = append(stringCVs, uploadUrl)
-		dbSquad.CvURLS = append(dbSquad.CvURLS, stringCVs...)
+		dbSquad.LogoURL = uploaded.URL
 
 		// update squad
 		if err := UpdateSquad(db.DB, dbSquad); err != nil {
@@ -376,6 +328,7 @@ func (db Database) FileUpload() gin.HandlerFunc {
 		}
 
 		c.JSON(http.StatusOK, gin.H{"message": "Squad updated successfully"})
+
 	}
 
 }
@@ -399,7 +352,7 @@ func (db Database) AddMember(ctx *gin.Context) {
 
 	// init vars
 	var vuser user.User
-	empty_reg, _ := regexp.Compile(os.Getenv("EMPTY_REGEX"))
+	empty_reg := db.Config.EmptyRegexp()
 
 	// unmarshal sent json
 	if err := ctx.ShouldBindJSON(&vuser); err != nil {
@@ -413,6 +366,13 @@ func (db Database) AddMember(ctx *gin.Context) {
 		return
 	}
 
+	// check the answers to the registration form
+	answers, err := form.ValidateAnswers(db.DB, form.RegistrationDomain(), form.ScopeUser, vuser.Answers, 0)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, form.ErrorResponse(err))
+		return
+	}
+
 	dbUser, _ := user.GetUserByEmail(db.DB, vuser.Email)
 
 	if user.CheckUserInSquad(db.DB, dbUser.SquadID) {
@@ -443,41 +403,50 @@ func (db Database) AddMember(ctx *gin.Context) {
 			SquadID:        dbLeader.SquadID,
 		}
 
-		//add a member to squad
-		new_member_created, err := user.NewUser(db.DB, new_member)
+		//add a member to squad, the row of the squad is locked while counting its slots
+		var new_member_created user.User
+		err = db.DB.Transaction(func(tx *gorm.DB) error {
+
+			dbSquad, err := LockSquadByID(tx, dbLeader.SquadID)
+			if err != nil {
+				return err
+			}
+			if !HasOpenSlot(dbSquad, db.Config.Squad) {
+				return ErrSquadFull
+			}
+
+			new_member_created, err = user.NewUser(tx, new_member)
+			if err != nil {
+				return err
+			}
+
+			dbSquad.SquadMembers = append(dbSquad.SquadMembers, int32(new_member_created.ID))
+			return UpdateSquadMembers(tx, dbSquad)
+		})
 		if err != nil {
 			ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 			return
 		}
 
-		//user added successfully
-		ctx.JSON(http.StatusOK, gin.H{"message": "member added successfully"})
-
-		//get squad by id
-		dbSquad, err := GetSquadByID(db.DB, dbLeader.SquadID)
-		if err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		// add the member to the role
+		if _, err := db.Enforcer.AddGroupingPolicy(middleware.Subject(new_member_created.ID), new_member_created.Role, middleware.DefaultDomain()); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
 			return
 		}
 
-		//init vars
-		var intIDs []int32
-		intIDs = append(intIDs, int32(new_member_created.ID))
-		dbSquad.SquadMembers = append(dbSquad.SquadMembers, intIDs...)
-
-		// update squad
-		if err := UpdateSquad(db.DB, dbSquad); err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		// save the answers
+		if err := form.SaveUserAnswers(db.DB, new_member_created.ID, answers); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
 			return
 		}
 
-		//member added to slice successfully
-		ctx.JSON(http.StatusOK, gin.H{"message": "member added to slice successfully"})
+		//user added successfully
+		ctx.JSON(http.StatusOK, gin.H{"message": "member added successfully"})
 
 		subject := "Payment Process"
 
 		// Send Email
-		user.SendValidationGomail(subject, new_member.Email, "api/user/Validation.html", new_member_created)
+		user.SendValidationGomail(db.Config.Email, subject, new_member.Email, "api/user/Validation.html", new_member_created)
 
 		ctx.JSON(http.StatusOK, gin.H{"message": "Mail sended successfully"})
 	}
@@ -489,7 +458,7 @@ func (db Database) UpdateName(ctx *gin.Context) {
 
 	// init vars
 	var usquad Squad
-	empty_reg, _ := regexp.Compile(os.Getenv("EMPTY_REGEX"))
+	empty_reg := db.Config.EmptyRegexp()
 
 	// unmarshal sent json
 	if err := ctx.ShouldBindJSON(&usquad); err != nil {
@@ -521,6 +490,7 @@ func (db Database) UpdateName(ctx *gin.Context) {
 	}
 
 	dbSquad.Name = usquad.Name
+	dbSquad.Slug = UniqueSlug(db.DB, usquad.Name, dbSquad.ID)
 
 	// update squad
 	if err := UpdateSquad(db.DB, dbSquad); err != nil {
@@ -552,5 +522,18 @@ func (db Database) GetSquadLogo(ctx *gin.Context) {
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"message": dbSquad.LogoURL})
+	// signed url of the private logo, the logos uploaded before are public
+	dbFile, err := file.GetLastSquadFile(db.DB, dbSquad.ID, file.KindLogo)
+	if err != nil {
+		ctx.JSON(http.StatusOK, gin.H{"message": dbSquad.LogoURL})
+		return
+	}
+
+	// the thumbnail is missing for the logos uploaded before the variants
+	response := gin.H{"message": file.SignedURL(db.Config.Storage, dbFile, session.UserID).URL}
+	if thumbnail, err := file.GetFileVariant(db.DB, dbFile.ID, strconv.Itoa(file.LogoSizes[len(file.LogoSizes)-1])); err == nil {
+		response["thumbnail"] = file.SignedURL(db.Config.Storage, thumbnail, session.UserID).URL
+	}
+
+	ctx.JSON(http.StatusOK, response)
 }
@@ -1,52 +1,140 @@
 package squad
 
 import (
+	"bytes"
 	"context"
-	"time"
+	"errors"
+	"fmt"
+	"strconv"
 
-	"github.com/cloudinary/cloudinary-go"
-	"github.com/cloudinary/cloudinary-go/api/uploader"
+	"github.com/ezzddinne/api/file"
+	"github.com/ezzddinne/api/user"
 	"github.com/ezzddinne/config"
+	"github.com/ezzddinne/middleware"
+	"github.com/ezzddinne/scanner"
+	"github.com/ezzddinne/storage"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
-//Image upload 
-func ImageUploadHelper(input interface{}) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// the squad has no slot left
+var ErrSquadFull = errors.New("squad is full")
 
-	//create cloudinary instance
-	cld, err := cloudinary.NewFromParams(config.EnvCloudName(), config.EnvCloudAPIKey(), config.EnvCloudAPISecret())
+// the squad can take another member, without max there is no limit
+func HasOpenSlot(squad Squad, cfg config.SquadConfig) bool {
+	return cfg.MaxMembers == 0 || len(squad.SquadMembers) < cfg.MaxMembers
+}
+
+//Image upload
+// the logo is stored as png squares of file.LogoSizes, the largest is the main file
+func ImageUploadHelper(ctx context.Context, db *gorm.DB, store storage.Storage, sc scanner.Scanner, cfg config.UploadConfig, input File) (file.File, error) {
+
+	data, err := file.ReadLimited(input.File, cfg.LogoMaxBytes())
 	if err != nil {
-		return "", err
+		return file.File{}, err
 	}
 
-	currentTime := time.Now()
+	if content_type := file.SniffContentType(data); content_type != "image/png" && content_type != "image/jpeg" {
+		return file.File{}, fmt.Errorf("file type %s is not allowed", content_type)
+	}
 
-	//upload file
-	uploadParam, err := cld.Upload.Upload(ctx, input, uploader.UploadParams{AllowedFormats: []string{"jpg", "png"}, PublicID: "TeamLogo : " + currentTime.Format("2006-01-02 15:04:05"), Folder: config.EnvCloudUploadFolder()})
+	variants, err := file.NormalizeLogo(data)
 	if err != nil {
-		return "", err
+		return file.File{}, err
+	}
+
+	var logo file.File
+	for i, size := range file.LogoSizes {
+		upload := file.Upload{
+			Kind:         file.KindLogo,
+			Name:         input.Name,
+			SquadID:      input.SquadID,
+			UploadedBy:   input.UploadedBy,
+			Content:      bytes.NewReader(variants[size]),
+			ContentTypes: []string{"image/png"},
+			Variant:      strconv.Itoa(size),
+			Scanner:      sc,
+		}
+		if i > 0 {
+			upload.ParentID = &logo.ID
+		}
+
+		stored, err := file.Store(ctx, db, store, upload)
+		if err != nil {
+			if i > 0 {
+				file.Remove(ctx, db, store, logo)
+			}
+			return file.File{}, err
+		}
+		if i == 0 {
+			logo = stored
+		}
 	}
-	return uploadParam.SecureURL, nil
+
+	return logo, nil
 }
 
 //File Upload
-func FileUploadHelper(input interface{}) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// the cv must be a pdf that can be parsed
+func FileUploadHelper(ctx context.Context, db *gorm.DB, store storage.Storage, sc scanner.Scanner, cfg config.UploadConfig, input File) (file.File, error) {
 
-	//create cloudinary instance
-	cld, err := cloudinary.NewFromParams(config.EnvCloudName(), config.EnvCloudAPIKey(), config.EnvCloudAPISecret())
+	data, err := file.ReadLimited(input.File, cfg.CVMaxBytes())
 	if err != nil {
-		return "", err
+		return file.File{}, err
+	}
+
+	if content_type := file.SniffContentType(data); content_type != "application/pdf" {
+		return file.File{}, fmt.Errorf("file type %s is not allowed", content_type)
 	}
 
-	currentTime := time.Now()
+	if err := file.CheckPDF(bytes.NewReader(data), int64(len(data))); err != nil {
+		return file.File{}, err
+	}
 
-	//upload file
-	uploadParam, err := cld.Upload.Upload(ctx, input, uploader.UploadParams{AllowedFormats: []string{"pdf"}, PublicID: "CV : " + currentTime.Format("2006-01-02 15:04:05"), Folder: config.EnvCloudUploadFolder()})
-	if err != nil {
-		return "", err
+	return file.Store(ctx, db, store, file.Upload{
+		Kind:         file.KindCV,
+		Name:         input.Name,
+		SquadID:      input.SquadID,
+		UploadedBy:   input.UploadedBy,
+		Content:      bytes.NewReader(data),
+		ContentTypes: []string{"application/pdf"},
+		MaxSize:      cfg.CVMaxBytes(),
+		Scanner:      sc,
+	})
+}
+
+// the request targets the cv of the user himself
+func OwnsCV() middleware.OwnerResolver {
+	return func(ctx *gin.Context, user_id uint) (bool, error) {
+		return user_id != 0, nil
+	}
+}
+
+// the user leads his own squad, the request targets it
+func LeadsOwnSquad(db *gorm.DB) middleware.OwnerResolver {
+	return func(ctx *gin.Context, user_id uint) (bool, error) {
+
+		if user_id == 0 {
+			return false, nil
+		}
+
+		dbUser, err := user.GetUserByID(db, user_id)
+		if err != nil || dbUser.SquadID == 0 {
+			return false, nil
+		}
+
+		dbSquad, err := GetSquadByID(db, dbUser.SquadID)
+		if err != nil {
+			return false, nil
+		}
+
+		return dbSquad.CreatedBy == user_id, nil
+	}
+}
+
+// the squad being created will be owned by the user
+func OwnsNewSquad() middleware.OwnerResolver {
+	return func(ctx *gin.Context, user_id uint) (bool, error) {
+		return user_id != 0, nil
 	}
-	return uploadParam.SecureURL, nil
 }
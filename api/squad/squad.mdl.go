@@ -2,10 +2,14 @@ package squad
 
 import (
 	"mime/multipart"
+	"time"
 
+	"github.com/ezzddinne/api/file"
+	"github.com/ezzddinne/api/form"
 	"github.com/ezzddinne/api/user"
 	"github.com/lib/pq"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Squad struct {
@@ -15,25 +19,118 @@ type Squad struct {
 	LeaderID     user.User      `gorm:"foreignKey:CreatedBy;references:ID"`
 	SquadMembers pq.Int32Array  `gorm:"column:squad_members;type:integer[]" json:"squad_members"`
 	LogoURL      string         `gorm:"column:logo_url;not null" json:"logo_url"`
-	CvURLS       pq.StringArray `gorm:"column:cv_urls;type:varchar[]" json:"cv_urls"`
+	CvURLS       pq.StringArray `gorm:"column:cv_urls;type:varchar[]" json:"-"` // public cvs uploaded before the cv files, deleted by PurgeLegacyCVs
+	Status       string         `gorm:"column:status;not null;default:draft" json:"status"`
+	SubmittedAt  *time.Time     `gorm:"column:submitted_at" json:"submitted_at"`
+	WaitlistedAt *time.Time     `gorm:"column:waitlisted_at" json:"waitlisted_at"`
+	AcceptedAt   *time.Time     `gorm:"column:accepted_at" json:"accepted_at"`
+	ConfirmBy    *time.Time     `gorm:"column:confirm_by" json:"confirm_by"`
+
+	// public profile, listed in the directory once accepted if the squad opted in
+	Slug          string         `gorm:"column:slug;unique" json:"slug"`
+	Description   string         `gorm:"column:description" json:"description"`
+	ProjectIdea   string         `gorm:"column:project_idea" json:"project_idea"`
+	TechStack     pq.StringArray `gorm:"column:tech_stack;type:text[]" json:"tech_stack"`
+	RepositoryURL string         `gorm:"column:repository_url" json:"repository_url"`
+	SocialLinks   pq.StringArray `gorm:"column:social_links;type:text[]" json:"social_links"`
+	Public        bool           `gorm:"column:public;not null;default:false" json:"public"`
+
+	// answers to the squad questions of the registration form, sent at creation
+	Answers form.Answers `gorm:"-" json:"answers,omitempty"`
 
 	gorm.Model
 }
 
+// status change of a squad and the note of the reviewer
+type SquadReview struct {
+	ID         uint      `gorm:"column:id;autoIncrement;primaryKey" json:"id"`
+	SquadID    uint      `gorm:"column:squad_id;not null;index" json:"squad_id"`
+	FromStatus string    `gorm:"column:from_status;not null" json:"from_status"`
+	ToStatus   string    `gorm:"column:to_status;not null" json:"to_status"`
+	Comment    string    `gorm:"column:comment" json:"comment"`
+	ReviewedBy uint      `gorm:"column:reviewed_by;not null" json:"reviewed_by"`
+	CreatedAt  time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+// member of the squad and his cv
+type SquadCV struct {
+	UserID    uint       `json:"user_id"`
+	FirstName string     `json:"firstname"`
+	LastName  string     `json:"lastname"`
+	Email     string     `json:"email"`
+	CV        *file.File `json:"cv"`
+}
+
 type File struct {
-	File multipart.File `json:"file,omitempty" validate:"required"`
+	File       multipart.File `json:"file,omitempty" validate:"required"`
+	Name       string         `json:"name,omitempty"`
+	SquadID    uint           `json:"squad_id,omitempty"`
+	UploadedBy uint           `json:"uploaded_by,omitempty"`
 }
 
-// create new squad
+// create new squad, the slug comes from the name
 func NewSquad(db *gorm.DB, squad Squad) (Squad, error) {
+	squad.Slug = UniqueSlug(db, squad.Name, 0)
 	return squad, db.Create(&squad).Error
 }
 
+// get squad by id and lock its row until the end of the transaction
+func LockSquadByID(tx *gorm.DB, squad_id uint) (squad Squad, err error) {
+	return squad, tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", squad_id).First(&squad).Error
+}
+
+// update the members of the squad, in the transaction holding the lock of its row
+func UpdateSquadMembers(tx *gorm.DB, squad Squad) error {
+	return tx.Model(&Squad{}).Where("id = ?", squad.ID).Update("squad_members", squad.SquadMembers).Error
+}
+
 // get all users
 func GetAllSquads(db *gorm.DB) (squads []Squad, err error) {
 	return squads, db.Preload("LeaderID").Find(&squads).Error
 }
 
+// get the squads in a status
+func GetSquadsByStatus(db *gorm.DB, status string) (squads []Squad, err error) {
+	return squads, db.Where("status = ?", status).Preload("LeaderID").Find(&squads).Error
+}
+
+// get a page of the accepted squads listed in the directory and their count
+func GetPublicSquads(db *gorm.DB, offset, limit int) (squads []Squad, total int64, err error) {
+
+	query := db.Model(&Squad{}).Where("status = ? AND public = ?", StatusAccepted, true).Session(&gorm.Session{})
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return squads, total, query.Order("name").Order("id").Offset(offset).Limit(limit).Find(&squads).Error
+}
+
+// get the accepted squad listed in the directory by slug
+func GetPublicSquadBySlug(db *gorm.DB, slug string) (squad Squad, err error) {
+	return squad, db.Where("slug = ? AND status = ? AND public = ?", slug, StatusAccepted, true).First(&squad).Error
+}
+
+// update the public profile of the squad, the cleared fields included
+func UpdateSquadProfile(db *gorm.DB, squad Squad) error {
+	return db.Model(&Squad{}).Where("id = ?", squad.ID).
+		Select("description", "project_idea", "tech_stack", "repository_url", "social_links", "public").Updates(&squad).Error
+}
+
+// count the squads in the statuses
+func CountSquadsByStatus(db *gorm.DB, statuses ...string) (count int64, err error) {
+	return count, db.Model(&Squad{}).Where("status IN ?", statuses).Count(&count).Error
+}
+
+// get the waitlisted squads, first in first out
+func GetWaitlist(db *gorm.DB) (squads []Squad, err error) {
+	return squads, db.Where("status = ?", StatusWaitlisted).Order("waitlisted_at, id").Preload("LeaderID").Find(&squads).Error
+}
+
+// get the status history of a squad
+func GetSquadReviews(db *gorm.DB, squad_id uint) (reviews []SquadReview, err error) {
+	return reviews, db.Where("squad_id = ?", squad_id).Order("created_at").Find(&reviews).Error
+}
+
 // update function
 func UpdateSquad(db *gorm.DB, squad Squad) error {
 	return db.Where("id = ?", squad.ID).Updates(&squad).Error
@@ -0,0 +1,343 @@
+package squad
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/ezzddinne/api/file"
+	"github.com/ezzddinne/api/user"
+	"github.com/ezzddinne/config"
+	"github.com/ezzddinne/middleware"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
+)
+
+// limits of the public profile
+const (
+	maxSlugLength   int = 60
+	maxTextLength   int = 2000
+	maxURLLength    int = 2048
+	maxTechStack    int = 20
+	maxSocialLinks  int = 10
+	defaultPageSize int = 20
+	maxPageSize     int = 100
+)
+
+// public profile sent by the leader, the omitted fields are cleared
+type ProfileInput struct {
+	Description   string   `json:"description"`
+	ProjectIdea   string   `json:"project_idea"`
+	TechStack     []string `json:"tech_stack"`
+	RepositoryURL string   `json:"repository_url"`
+	SocialLinks   []string `json:"social_links"`
+	Public        bool     `json:"public"`
+}
+
+// squad as listed in the directory, without the members and their contact details
+type PublicSquad struct {
+	Name          string   `json:"name"`
+	Slug          string   `json:"slug"`
+	Logo          string   `json:"logo"`
+	Description   string   `json:"description"`
+	ProjectIdea   string   `json:"project_idea"`
+	TechStack     []string `json:"tech_stack"`
+	RepositoryURL string   `json:"repository_url"`
+	SocialLinks   []string `json:"social_links"`
+	Members       int      `json:"members"`
+}
+
+// page of the directory
+type DirectoryPage struct {
+	Squads []PublicSquad `json:"squads"`
+	Page   int           `json:"page"`
+	Limit  int           `json:"limit"`
+	Total  int64         `json:"total"`
+}
+
+// url safe form of the name: lowercase letters and digits joined by dashes, the accents dropped
+func Slugify(name string) string {
+
+	var builder strings.Builder
+	dash := false
+	for _, r := range norm.NFD.String(strings.ToLower(name)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			builder.WriteRune(r)
+			dash = false
+		} else if !dash && builder.Len() > 0 {
+			builder.WriteByte('-')
+			dash = true
+		}
+	}
+
+	slug := strings.Trim(builder.String(), "-")
+	if len(slug) > maxSlugLength {
+		slug = strings.Trim(slug[:maxSlugLength], "-")
+	}
+	if slug == "" {
+		slug = "squad"
+	}
+	return slug
+}
+
+// slug of the name not taken by another squad, the deleted squads included
+func UniqueSlug(db *gorm.DB, name string, squad_id uint) string {
+
+	base := Slugify(name)
+	slug := base
+	for i := 2; ; i++ {
+		var count int64
+		db.Unscoped().Model(&Squad{}).Where("slug = ? AND id <> ?", slug, squad_id).Count(&count)
+		if count == 0 {
+			return slug
+		}
+		slug = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// absolute http or https url
+func validURL(raw string) error {
+
+	if len(raw) > maxURLLength {
+		return fmt.Errorf("url is longer than %d characters", maxURLLength)
+	}
+
+	parsed, err := url.ParseRequestURI(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid url %q", raw)
+	}
+	return nil
+}
+
+// check the profile and apply it to the squad
+func applyProfile(squad *Squad, input ProfileInput) error {
+
+	input.Description = strings.TrimSpace(input.Description)
+	input.ProjectIdea = strings.TrimSpace(input.ProjectIdea)
+	input.RepositoryURL = strings.TrimSpace(input.RepositoryURL)
+
+	if len(input.Description) > maxTextLength || len(input.ProjectIdea) > maxTextLength {
+		return fmt.Errorf("description and project idea are limited to %d characters", maxTextLength)
+	}
+
+	// lowercase tags without duplicates
+	seen := map[string]bool{}
+	tech_stack := []string{}
+	for _, tag := range input.TechStack {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tech_stack = append(tech_stack, tag)
+	}
+	if len(tech_stack) > maxTechStack {
+		return fmt.Errorf("tech stack is limited to %d tags", maxTechStack)
+	}
+
+	if input.RepositoryURL != "" {
+		if err := validURL(input.RepositoryURL); err != nil {
+			return err
+		}
+	}
+
+	social_links := []string{}
+	for _, link := range input.SocialLinks {
+		link = strings.TrimSpace(link)
+		if link == "" {
+			continue
+		}
+		if err := validURL(link); err != nil {
+			return err
+		}
+		social_links = append(social_links, link)
+	}
+	if len(social_links) > maxSocialLinks {
+		return fmt.Errorf("social links are limited to %d", maxSocialLinks)
+	}
+
+	squad.Description = input.Description
+	squad.ProjectIdea = input.ProjectIdea
+	squad.TechStack = tech_stack
+	squad.RepositoryURL = input.RepositoryURL
+	squad.SocialLinks = social_links
+	squad.Public = input.Public
+	return nil
+}
+
+// public view of the squad, the logo is signed for anonymous readers
+func publicSquad(db *gorm.DB, cfg *config.Config, squad Squad) PublicSquad {
+
+	public := PublicSquad{
+		Name:          squad.Name,
+		Slug:          squad.Slug,
+		Description:   squad.Description,
+		ProjectIdea:   squad.ProjectIdea,
+		TechStack:     squad.TechStack,
+		RepositoryURL: squad.RepositoryURL,
+		SocialLinks:   squad.SocialLinks,
+		Members:       len(squad.SquadMembers),
+	}
+	if public.TechStack == nil {
+		public.TechStack = []string{}
+	}
+	if public.SocialLinks == nil {
+		public.SocialLinks = []string{}
+	}
+
+	// the thumbnail when there is one, the logos uploaded before the files are public
+	logo, err := file.GetLastSquadFile(db, squad.ID, file.KindLogo)
+	if err != nil {
+		public.Logo = squad.LogoURL
+		return public
+	}
+	if logo.ScanStatus != file.ScanClean {
+		return public
+	}
+	if thumbnail, err := file.GetFileVariant(db, logo.ID, strconv.Itoa(file.LogoSizes[len(file.LogoSizes)-1])); err == nil {
+		logo = thumbnail
+	}
+	public.Logo = file.SignedURL(cfg.Storage, logo, 0).URL
+
+	return public
+}
+
+// positive integer query, the fallback when missing or invalid
+func queryInt(ctx *gin.Context, key string, fallback int) int {
+
+	value, err := strconv.Atoi(ctx.Query(key))
+	if err != nil || value < 1 {
+		return fallback
+	}
+	return value
+}
+
+// offset of the page, the pages past the largest offset are empty
+func pageOffset(page, limit int) int {
+
+	if page-1 > math.MaxInt32/limit {
+		return math.MaxInt32
+	}
+	return (page - 1) * limit
+}
+
+// Update squad profile
+// @Security bearerAuth
+// @Summary Update the public profile of the squad
+// @Description This method replaces the description, project idea, tech stack and links of the squad of the leader, public lists the squad in the directory once accepted.
+// @Tags Squad
+// @Accept json
+// @Produce json
+// @Param request body ProfileInput true "Public profile"
+// @Schemes
+// @Success 200 {object} squad.Squad
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Router /auth/jwt/profile [put]
+func (db Database) UpdateSquadProfile(ctx *gin.Context) {
+
+	//init vars
+	var input ProfileInput
+
+	//unmarshal sent json
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	//get values
+	session := middleware.ExtractTokenValues(ctx)
+
+	dbLeader, err := user.GetUserByID(db.DB, session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	dbSquad, err := GetSquadByID(db.DB, dbLeader.SquadID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	if err := applyProfile(&dbSquad, input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	if err := UpdateSquadProfile(db.DB, dbSquad); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dbSquad)
+}
+
+// Get directory
+// @Summary Browse the squads of the event
+// @Description This method lists a page of the accepted squads that opted in, without their members.
+// @Tags Squad
+// @Produce json
+// @Param page query int false "Page, from 1"
+// @Param limit query int false "Squads by page, 100 at most"
+// @Schemes
+// @Success 200 {object} squad.DirectoryPage
+// @Failure 500 {object} gin.H
+// @Router /squads [get]
+func (db Database) GetDirectory(ctx *gin.Context) {
+
+	page := queryInt(ctx, "page", 1)
+	limit := queryInt(ctx, "limit", defaultPageSize)
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	squads, total, err := GetPublicSquads(db.DB, pageOffset(page, limit), limit)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	response := DirectoryPage{Squads: []PublicSquad{}, Page: page, Limit: limit, Total: total}
+	for _, squad := range squads {
+		response.Squads = append(response.Squads, publicSquad(db.DB, db.Config, squad))
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// Get directory squad
+// @Summary Get a squad of the directory
+// @Description This method returns the public profile of an accepted squad that opted in.
+// @Tags Squad
+// @Produce json
+// @Param slug path string true "Squad slug"
+// @Schemes
+// @Success 200 {object} squad.PublicSquad
+// @Failure 404 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /squads/{slug} [get]
+func (db Database) GetDirectorySquad(ctx *gin.Context) {
+
+	dbSquad, err := GetPublicSquadBySlug(db.DB, ctx.Param("slug"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "squad not found"})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, publicSquad(db.DB, db.Config, dbSquad))
+}
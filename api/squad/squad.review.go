@@ -0,0 +1,420 @@
+package squad
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ezzddinne/api/form"
+	"github.com/ezzddinne/api/user"
+	"github.com/ezzddinne/config"
+	"github.com/ezzddinne/middleware"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// squad statuses
+const (
+	StatusDraft       = "draft"
+	StatusSubmitted   = "submitted"
+	StatusUnderReview = "under_review"
+	StatusAccepted    = "accepted"
+	StatusWaitlisted  = "waitlisted"
+	StatusRejected    = "rejected"
+	StatusPromoted    = "promoted"
+	StatusWithdrawn   = "withdrawn"
+	StatusExpired     = "expired"
+)
+
+var (
+	ErrInvalidTransition = errors.New("status transition not allowed")
+	ErrStatusChanged     = errors.New("squad status was changed meanwhile")
+	ErrSquadLocked       = errors.New("squad is locked once submitted")
+)
+
+// statuses reachable from every status
+// the leader submits the draft, the reviewers do the rest and can send the squad back to draft
+// the waitlisted squads are promoted when a place frees up and expire when they don't confirm in time
+var transitions = map[string][]string{
+	StatusDraft:       {StatusSubmitted},
+	StatusSubmitted:   {StatusUnderReview, StatusAccepted, StatusWaitlisted, StatusRejected, StatusDraft, StatusWithdrawn},
+	StatusUnderReview: {StatusAccepted, StatusWaitlisted, StatusRejected, StatusDraft, StatusWithdrawn},
+	StatusWaitlisted:  {StatusUnderReview, StatusAccepted, StatusRejected, StatusPromoted, StatusWithdrawn},
+	StatusPromoted:    {StatusAccepted, StatusRejected, StatusExpired, StatusWithdrawn},
+	StatusAccepted:    {StatusUnderReview, StatusWaitlisted, StatusRejected, StatusExpired, StatusWithdrawn},
+	StatusRejected:    {StatusUnderReview},
+	StatusExpired:     {StatusUnderReview},
+}
+
+// statuses the reviewers can set, the others are set by the leader or the waitlist
+var reviewStatuses = []string{StatusDraft, StatusUnderReview, StatusAccepted, StatusWaitlisted, StatusRejected}
+
+// subject and text of the email sent when a squad enters a status
+var statusEmails = map[string]struct{ Subject, Message string }{
+	StatusDraft:       {"Your squad needs changes", "Your squad was sent back to draft, you can edit it and submit it again."},
+	StatusSubmitted:   {"Your squad was submitted", "Your squad was submitted, it can't be edited until the review is over."},
+	StatusUnderReview: {"Your squad is under review", "The reviewers are looking at your squad."},
+	StatusAccepted:    {"Your squad was accepted", "Congratulations, your squad was accepted!"},
+	StatusWaitlisted:  {"Your squad was waitlisted", "Your squad is on the waitlist, we will let you know as soon as a place frees up."},
+	StatusRejected:    {"Your squad was not accepted", "We are sorry, your squad was not accepted this time."},
+	StatusPromoted:    {"A place freed up for your squad", "A place freed up and your squad left the waitlist, confirm your participation before the deadline to keep it."},
+	StatusWithdrawn:   {"Your squad was withdrawn", "Your squad was withdrawn from the event."},
+	StatusExpired:     {"Your squad lost its place", "Your squad lost its place because it was not confirmed or paid in time."},
+}
+
+type SquadStatusEmailData struct {
+	FirstName string
+	LastName  string
+	SquadName string
+	Status    string
+	Message   string
+	Comment   string
+	Deadline  string
+}
+
+type ReviewInput struct {
+	Status  string `json:"status"`
+	Comment string `json:"comment"`
+}
+
+// the squad can go from a status to the other
+func CanTransition(from, to string) bool {
+	for _, status := range transitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// the reviewers can set the status
+func IsReviewStatus(status string) bool {
+	for _, review_status := range reviewStatuses {
+		if review_status == status {
+			return true
+		}
+	}
+	return false
+}
+
+// move the squad to a status and keep the change in its history
+func TransitionSquad(db *gorm.DB, squad Squad, to string, reviewed_by uint, comment string) (Squad, error) {
+	return transitionSquad(db, squad, to, reviewed_by, comment, nil)
+}
+
+// move the squad to a status, the promoted squads confirm before confirm_by
+func transitionSquad(db *gorm.DB, squad Squad, to string, reviewed_by uint, comment string, confirm_by *time.Time) (Squad, error) {
+
+	if !CanTransition(squad.Status, to) {
+		return squad, fmt.Errorf("%w: %s to %s", ErrInvalidTransition, squad.Status, to)
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+
+		now := time.Now()
+		updates := map[string]interface{}{"status": to, "confirm_by": confirm_by}
+		switch to {
+		case StatusSubmitted:
+			updates["submitted_at"] = now
+		case StatusWaitlisted:
+			updates["waitlisted_at"] = now
+		case StatusAccepted:
+			updates["accepted_at"] = now
+		}
+
+		// the status is checked again in case another reviewer moved the squad
+		result := tx.Model(&Squad{}).Where("id = ? AND status = ?", squad.ID, squad.Status).Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrStatusChanged
+		}
+
+		return tx.Create(&SquadReview{
+			SquadID:    squad.ID,
+			FromStatus: squad.Status,
+			ToStatus:   to,
+			Comment:    comment,
+			ReviewedBy: reviewed_by,
+		}).Error
+	})
+	if err != nil {
+		return squad, err
+	}
+
+	return GetSquadByID(db, squad.ID)
+}
+
+// email the members of the squad about its new status, once the transition is committed
+// the members are read before returning, the emails are sent in the background
+func NotifySquadStatus(db *gorm.DB, cfg config.EmailConfig, squad Squad, comment string) {
+
+	email, ok := statusEmails[squad.Status]
+	if !ok {
+		return
+	}
+
+	members, err := user.GetMembersBySquadID(db, squad.ID)
+	if err != nil {
+		log.Println("[WARNING] failed to get the members of squad", squad.ID, ":", err)
+		return
+	}
+
+	deadline := ""
+	if squad.ConfirmBy != nil {
+		deadline = squad.ConfirmBy.Format("2006-01-02 15:04:05")
+	}
+
+	// a slow smtp server doesn't hold the request
+	go func() {
+		for _, member := range members {
+			err := user.SendTemplateGomail(cfg, email.Subject, member.Email, "api/squad/SquadStatus.html", SquadStatusEmailData{
+				FirstName: member.FirstName,
+				LastName:  member.LastName,
+				SquadName: squad.Name,
+				Status:    squad.Status,
+				Message:   email.Message,
+				Comment:   comment,
+				Deadline:  deadline,
+			})
+			if err != nil {
+				log.Println("[WARNING] failed to send the status email of squad", squad.ID, "to", member.Email, ":", err)
+			}
+		}
+	}()
+}
+
+// status of a transition error
+func transitionErrorStatus(err error) int {
+	if errors.Is(err, ErrInvalidTransition) || errors.Is(err, ErrStatusChanged) || errors.Is(err, ErrEventFull) {
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
+// the squad of the user can still be edited
+func EditableSquad(db *gorm.DB) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		session := middleware.ExtractTokenValues(ctx)
+
+		dbUser, err := user.GetUserByID(db, session.UserID)
+		if err != nil || dbUser.SquadID == 0 {
+			ctx.Next()
+			return
+		}
+
+		dbSquad, err := GetSquadByID(db, dbUser.SquadID)
+		if err != nil {
+			ctx.Next()
+			return
+		}
+
+		if dbSquad.Status != StatusDraft {
+			ctx.AbortWithStatusJSON(http.StatusLocked, gin.H{"message": ErrSquadLocked.Error(), "status": dbSquad.Status})
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// Submit squad
+// @Security bearerAuth
+// @Summary Submit the squad for review
+// @Description This method submits the draft squad of the leader once the required squad questions are answered, the squad can't be edited afterwards.
+// @Tags Squad
+// @Produce json
+// @Schemes
+// @Success 200 {object} squad.Squad
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 409 {object} gin.H
+// @Router /auth/jwt/submit [post]
+func (db Database) SubmitSquad(ctx *gin.Context) {
+
+	// get session value
+	session := middleware.ExtractTokenValues(ctx)
+
+	//get leader by id
+	dbLeader, err := user.GetUserByID(db.DB, session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	//get squad by id
+	dbSquad, err := GetSquadByID(db.DB, dbLeader.SquadID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	if dbSquad.Status != StatusDraft {
+		ctx.JSON(http.StatusConflict, gin.H{"message": "squad was already submitted", "status": dbSquad.Status})
+		return
+	}
+
+	// the required squad questions are answered, the matched squads answer them after their creation
+	answers, err := form.SquadAnswers(db.DB, form.RegistrationDomain(), dbSquad.ID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if _, err := form.ValidateAnswers(db.DB, form.RegistrationDomain(), form.ScopeSquad, answers, dbLeader.ID); err != nil {
+		ctx.JSON(http.StatusBadRequest, form.ErrorResponse(err))
+		return
+	}
+
+	// submit
+	submitted, err := TransitionSquad(db.DB, dbSquad, StatusSubmitted, session.UserID, "")
+	if err != nil {
+		ctx.JSON(transitionErrorStatus(err), gin.H{"message": err.Error()})
+		return
+	}
+
+	NotifySquadStatus(db.DB, db.Config.Email, submitted, "")
+
+	ctx.JSON(http.StatusOK, submitted)
+}
+
+// Review squad
+// @Security bearerAuth
+// @Summary Change the status of a squad
+// @Description This method moves a squad to another status with a note for the squad, the members are notified by email.
+// @Tags Squad
+// @Accept json
+// @Produce json
+// @Param id path uint true "Squad ID"
+// @Param request body ReviewInput true "New status and comment"
+// @Schemes
+// @Success 200 {object} squad.Squad
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Failure 409 {object} gin.H
+// @Router /auth/jwt/squad/{id}/status [patch]
+func (db Database) ReviewSquad(ctx *gin.Context) {
+
+	// init vars
+	var input ReviewInput
+
+	//get the squad id
+	squad_id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// unmarshal sent json
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// the leader submits and withdraws his squad, the waitlist promotes and expires it
+	if !IsReviewStatus(input.Status) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "invalid status"})
+		return
+	}
+
+	//get squad by id
+	dbSquad, err := GetSquadByID(db.DB, uint(squad_id))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	}
+
+	// get session value
+	session := middleware.ExtractTokenValues(ctx)
+
+	// the accepted squads are waitlisted when the event is full
+	var reviewed Squad
+	if input.Status == StatusAccepted {
+		reviewed, err = AcceptSquad(db.DB, db.Config.Squad, dbSquad, session.UserID, input.Comment)
+	} else {
+		reviewed, err = TransitionSquad(db.DB, dbSquad, input.Status, session.UserID, input.Comment)
+	}
+	if err != nil {
+		ctx.JSON(transitionErrorStatus(err), gin.H{"message": err.Error()})
+		return
+	}
+
+	NotifySquadStatus(db.DB, db.Config.Email, reviewed, input.Comment)
+
+	// the squad may have left its place to the waitlist
+	if _, err := PromoteWaitlist(db.DB, db.Config); err != nil {
+		log.Println("[WARNING] waitlist promotion:", err)
+	}
+
+	ctx.JSON(http.StatusOK, reviewed)
+}
+
+// Get my squad reviews
+// @Security bearerAuth
+// @Summary Get the status history of the squad of the current user
+// @Description This method returns the status changes of the squad with the notes of the reviewers.
+// @Tags Squad
+// @Produce json
+// @Schemes
+// @Success 200 {array} squad.SquadReview
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Router /auth/jwt/squad/reviews [get]
+func (db Database) GetMySquadReviews(ctx *gin.Context) {
+
+	// get session value
+	session := middleware.ExtractTokenValues(ctx)
+
+	dbUser, err := user.GetUserByID(db.DB, session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	reviews, err := GetSquadReviews(db.DB, dbUser.SquadID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, reviews)
+}
+
+// Get squad reviews
+// @Security bearerAuth
+// @Summary Get the status history of a squad
+// @Description This method returns the status changes of any squad with the notes of the reviewers.
+// @Tags Squad
+// @Produce json
+// @Param id path uint true "Squad ID"
+// @Schemes
+// @Success 200 {array} squad.SquadReview
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Router /auth/jwt/squad/{id}/reviews [get]
+func (db Database) GetSquadReviewsByID(ctx *gin.Context) {
+
+	//get the squad id
+	squad_id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	reviews, err := GetSquadReviews(db.DB, uint(squad_id))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, reviews)
+}
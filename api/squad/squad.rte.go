@@ -2,47 +2,98 @@ package squad
 
 import (
 	"github.com/casbin/casbin/v2"
+	"github.com/ezzddinne/api/user"
+	"github.com/ezzddinne/config"
 	"github.com/ezzddinne/middleware"
+	"github.com/ezzddinne/scanner"
+	"github.com/ezzddinne/storage"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
-func RoutesAuthJWT(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.Enforcer) {
+func RoutesAuthJWT(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.SyncedEnforcer, cfg *config.Config, store storage.Storage, sc scanner.Scanner) {
 
-	baseInstance := Database{DB: db, Enforcer: enforcer}
+	baseInstance := Database{DB: db, Enforcer: enforcer, Config: cfg, Storage: store, Scanner: sc}
 
 	// create squad route
-	router.POST("/new", middleware.Authorize("squads", "write", enforcer), baseInstance.CreateSquad)
+	router.POST("/new", middleware.AuthorizeResource("squads", "write", enforcer, OwnsNewSquad()), baseInstance.CreateSquad)
 
 	// Get all squads route
 	router.GET("/allsquads", middleware.Authorize("squads", "read", enforcer), baseInstance.GetAllSquads)
 
 	// Get squad by id
-	router.GET("/squad/:id", middleware.Authorize("squads", "read", enforcer), baseInstance.GetSquadByID)
+	router.GET("/squad/:id", middleware.AuthorizeResource("squads", "read", enforcer, user.InSquadParam(db)), baseInstance.GetSquadByID)
 
 	// get squad id front
 	//Front
-	router.GET("/squad/id", middleware.Authorize("squads", "write", enforcer), baseInstance.GetSquadByIDFront)
+	router.GET("/squad/id", middleware.AuthorizeResource("squads", "read", enforcer, user.InOwnSquad(db)), baseInstance.GetSquadByIDFront)
 
 	// get logo
 	//front
-	router.GET("/squad/logo", middleware.Authorize("squads", "write", enforcer), baseInstance.GetSquadLogo)
+	router.GET("/squad/logo", middleware.AuthorizeResource("squads", "read", enforcer, user.InOwnSquad(db)), baseInstance.GetSquadLogo)
 
 	// Get squad by email
 	router.GET("/:email", middleware.Authorize("squads", "read", enforcer), baseInstance.GetSquadByEmail)
 
 	// Delete squad route
-	router.DELETE("/delete", middleware.Authorize("squads", "write", enforcer), baseInstance.DeleteSquad)
+	router.DELETE("/delete", middleware.AuthorizeResource("squads", "delete", enforcer, LeadsOwnSquad(db)), EditableSquad(db), baseInstance.DeleteSquad)
 
 	// add member route
-	router.POST("/add", middleware.Authorize("squads", "write", enforcer), baseInstance.AddMember)
+	router.POST("/add", middleware.AuthorizeResource("squads", "write", enforcer, LeadsOwnSquad(db)), EditableSquad(db), baseInstance.AddMember)
 
 	// upload image route
-	router.POST("/image", middleware.Authorize("squads", "write", enforcer), baseInstance.ImageUpload())
+	router.POST("/image", middleware.AuthorizeResource("squads", "write", enforcer, LeadsOwnSquad(db)), EditableSquad(db), baseInstance.ImageUpload())
 
-	// upload file route
-	router.POST("/file", middleware.Authorize("squads", "write", enforcer), baseInstance.FileUpload())
+	// upload file route, kept for the clients uploading the cv on /file
+	router.POST("/file", middleware.AuthorizeResource("cvs", "write", enforcer, OwnsCV()), baseInstance.UploadCV)
+
+	// my cv routes
+	router.GET("/cv", middleware.AuthorizeResource("cvs", "read", enforcer, OwnsCV()), baseInstance.GetMyCV)
+	router.PUT("/cv", middleware.AuthorizeResource("cvs", "write", enforcer, OwnsCV()), baseInstance.UploadCV)
+	router.DELETE("/cv", middleware.AuthorizeResource("cvs", "delete", enforcer, OwnsCV()), baseInstance.DeleteMyCV)
+
+	// cvs of the leader's squad
+	router.GET("/squad/cvs", middleware.AuthorizeResource("cvs", "read", enforcer, LeadsOwnSquad(db)), baseInstance.GetMySquadCVs)
+
+	// cvs of any squad
+	router.GET("/squad/:id/cvs", middleware.Authorize("cvs", "read", enforcer), baseInstance.GetSquadCVsByID)
 
 	// update squad name route
-	router.PATCH("/name", middleware.Authorize("squads", "write", enforcer), baseInstance.UpdateName)
+	router.PATCH("/name", middleware.AuthorizeResource("squads", "write", enforcer, LeadsOwnSquad(db)), EditableSquad(db), baseInstance.UpdateName)
+
+	// submit the squad for review
+	router.POST("/submit", middleware.AuthorizeResource("squads", "write", enforcer, LeadsOwnSquad(db)), baseInstance.SubmitSquad)
+
+	// status history of the own squad
+	router.GET("/squad/reviews", middleware.AuthorizeResource("squads", "read", enforcer, user.InOwnSquad(db)), baseInstance.GetMySquadReviews)
+
+	// reviewers move the squads between statuses
+	router.PATCH("/squad/:id/status", middleware.Authorize("squads", "approve", enforcer), baseInstance.ReviewSquad)
+	router.GET("/squad/:id/reviews", middleware.Authorize("squads", "approve", enforcer), baseInstance.GetSquadReviewsByID)
+
+	// promoted squads confirm their place, submitted squads can withdraw
+	router.POST("/confirm", middleware.AuthorizeResource("squads", "write", enforcer, LeadsOwnSquad(db)), baseInstance.ConfirmSquad)
+	router.POST("/withdraw", middleware.AuthorizeResource("squads", "write", enforcer, LeadsOwnSquad(db)), baseInstance.WithdrawSquad)
+
+	// waitlist position of the own squad
+	router.GET("/squad/waitlist", middleware.AuthorizeResource("squads", "read", enforcer, user.InOwnSquad(db)), baseInstance.GetMyWaitlistPosition)
+
+	// waitlist in order
+	router.GET("/waitlist", middleware.Authorize("squads", "approve", enforcer), baseInstance.GetWaitlist)
+
+	// answers of the own squad to the registration form
+	router.GET("/squad/answers", middleware.AuthorizeResource("squads", "read", enforcer, user.InOwnSquad(db)), baseInstance.GetMySquadAnswers)
+	router.PUT("/squad/answers", middleware.AuthorizeResource("squads", "write", enforcer, LeadsOwnSquad(db)), EditableSquad(db), baseInstance.UpdateMySquadAnswers)
+
+	// public profile of the own squad
+	router.PUT("/profile", middleware.AuthorizeResource("squads", "write", enforcer, LeadsOwnSquad(db)), baseInstance.UpdateSquadProfile)
+}
+
+func RoutesSquads(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.SyncedEnforcer, cfg *config.Config) {
+
+	baseInstance := Database{DB: db, Enforcer: enforcer, Config: cfg}
+
+	// public directory of the accepted squads
+	router.GET("", baseInstance.GetDirectory)
+	router.GET("/:slug", baseInstance.GetDirectorySquad)
 }
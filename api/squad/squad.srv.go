@@ -1,48 +1,61 @@
 package squad
 
-import "github.com/go-playground/validator/v10"
+import (
+	"context"
+	"time"
+
+	"github.com/ezzddinne/api/file"
+	"github.com/ezzddinne/config"
+	"github.com/ezzddinne/scanner"
+	"github.com/ezzddinne/storage"
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
 
 var (
 	validate = validator.New()
 )
 
 type mediaUpload interface {
-	ImageUpload(file File) (string, error)
-	FileUpload(file File) (string, error)
+	ImageUpload(input File) (file.File, error)
+	FileUpload(input File) (file.File, error)
 }
 
-type media struct{}
+type media struct {
+	db      *gorm.DB
+	storage storage.Storage
+	scanner scanner.Scanner
+	cfg     config.UploadConfig
+}
 
-func NewMediaUpload() mediaUpload {
-	return &media{}
+func NewMediaUpload(db *gorm.DB, store storage.Storage, sc scanner.Scanner, cfg config.UploadConfig) mediaUpload {
+	return &media{db: db, storage: store, scanner: sc, cfg: cfg}
 }
 
-func (*media) ImageUpload(file File) (string, error) {
+func (m *media) ImageUpload(input File) (file.File, error) {
 	//validate
-	err := validate.Struct(file)
+	err := validate.Struct(input)
 	if err != nil {
-		return "", err
+		return file.File{}, err
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
 	//upload
-	uploadUrl, err := ImageUploadHelper(file.File)
-	if err != nil {
-		return "", err
-	}
-	return uploadUrl, nil
+	return ImageUploadHelper(ctx, m.db, m.storage, m.scanner, m.cfg, input)
 }
 
-func (*media) FileUpload(file File) (string, error) {
+func (m *media) FileUpload(input File) (file.File, error) {
 	//validate
-	err := validate.Struct(file)
+	err := validate.Struct(input)
 	if err != nil {
-		return "", err
+		return file.File{}, err
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
 	//upload
-	uploadUrl, err := FileUploadHelper(file.File)
-	if err != nil {
-		return "", err
-	}
-	return uploadUrl, nil
+	return FileUploadHelper(ctx, m.db, m.storage, m.scanner, m.cfg, input)
 }
@@ -0,0 +1,446 @@
+package squad
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ezzddinne/api/user"
+	"github.com/ezzddinne/config"
+	"github.com/ezzddinne/middleware"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var ErrEventFull = errors.New("event is full")
+
+// the accepted and promoted squads hold a place
+var placeStatuses = []string{StatusAccepted, StatusPromoted}
+
+// key of the postgres advisory lock held while counting the places
+const waitlistLock int64 = 7365928
+
+// place of a squad in the waitlist
+type WaitlistPosition struct {
+	Status    string     `json:"status"`
+	Position  int        `json:"position,omitempty"`
+	ConfirmBy *time.Time `json:"confirm_by,omitempty"`
+}
+
+// the event has no place left, without capacity there is no limit
+func isFull(db *gorm.DB, cfg config.SquadConfig) (bool, error) {
+
+	if cfg.Capacity == 0 {
+		return false, nil
+	}
+
+	count, err := CountSquadsByStatus(db, placeStatuses...)
+	if err != nil {
+		return false, err
+	}
+
+	return count >= int64(cfg.Capacity), nil
+}
+
+// run fn in a transaction holding the waitlist lock
+// the instances count the places and move the squads one at a time
+func withWaitlistLock(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", waitlistLock).Error; err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+// accept the squad, it is waitlisted when the event is full
+// a waitlisted squad can't skip the line once the event is full
+func AcceptSquad(db *gorm.DB, cfg config.SquadConfig, squad Squad, reviewed_by uint, comment string) (Squad, error) {
+
+	reviewed := squad
+	err := withWaitlistLock(db, func(tx *gorm.DB) error {
+
+		to := StatusAccepted
+
+		// the promoted squads already hold their place
+		if squad.Status != StatusPromoted {
+			full, err := isFull(tx, cfg)
+			if err != nil {
+				return err
+			}
+			if full && squad.Status == StatusWaitlisted {
+				return ErrEventFull
+			}
+			if full {
+				to = StatusWaitlisted
+			}
+		}
+
+		var err error
+		reviewed, err = TransitionSquad(tx, squad, to, reviewed_by, comment)
+		return err
+	})
+
+	return reviewed, err
+}
+
+// promote the waitlisted squads in order while places are free
+// without capacity the waitlist is handled by the reviewers
+// the members are emailed once the lock is released
+func PromoteWaitlist(db *gorm.DB, cfg *config.Config) ([]Squad, error) {
+
+	var promoted []Squad
+	err := withWaitlistLock(db, func(tx *gorm.DB) (err error) {
+		promoted, err = promoteWaitlist(tx, cfg)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	notifySquads(db, cfg, promoted)
+	return promoted, nil
+}
+
+// promote the waitlisted squads, in the transaction holding the waitlist lock
+func promoteWaitlist(tx *gorm.DB, cfg *config.Config) ([]Squad, error) {
+
+	if cfg.Squad.Capacity == 0 {
+		return nil, nil
+	}
+
+	count, err := CountSquadsByStatus(tx, placeStatuses...)
+	if err != nil {
+		return nil, err
+	}
+
+	free := int(int64(cfg.Squad.Capacity) - count)
+	if free <= 0 {
+		return nil, nil
+	}
+
+	waitlist, err := GetWaitlist(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var promoted []Squad
+	for _, squad := range waitlist {
+		if len(promoted) == free {
+			break
+		}
+
+		confirm_by := time.Now().Add(cfg.Squad.ConfirmTTL())
+		squad, err := transitionSquad(tx, squad, StatusPromoted, 0, "", &confirm_by)
+		if errors.Is(err, ErrStatusChanged) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		promoted = append(promoted, squad)
+	}
+
+	return promoted, nil
+}
+
+// free the places of the promoted squads not confirmed in time
+// and of the accepted squads not paid in time
+// in the transaction holding the waitlist lock
+func expireSquads(tx *gorm.DB, cfg *config.Config) ([]Squad, error) {
+
+	now := time.Now()
+
+	var squads []Squad
+	if err := tx.Where("status = ? AND confirm_by < ?", StatusPromoted, now).Find(&squads).Error; err != nil {
+		return nil, err
+	}
+
+	if cfg.Squad.PaymentDuration > 0 {
+		var accepted []Squad
+		if err := tx.Where("status = ? AND accepted_at < ?", StatusAccepted, now.Add(-cfg.Squad.PaymentTTL())).Find(&accepted).Error; err != nil {
+			return nil, err
+		}
+
+		unpaid, err := unpaidSquads(tx, accepted)
+		if err != nil {
+			return nil, err
+		}
+		squads = append(squads, unpaid...)
+	}
+
+	var expired []Squad
+	for _, squad := range squads {
+		squad, err := TransitionSquad(tx, squad, StatusExpired, 0, "")
+		if errors.Is(err, ErrStatusChanged) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		expired = append(expired, squad)
+	}
+
+	return expired, nil
+}
+
+// email the members of the squads moved by the waitlist
+func notifySquads(db *gorm.DB, cfg *config.Config, squads []Squad) {
+	for _, squad := range squads {
+		NotifySquadStatus(db, cfg.Email, squad, "")
+	}
+}
+
+// the squads with a member who didn't pay
+func unpaidSquads(db *gorm.DB, squads []Squad) ([]Squad, error) {
+
+	if len(squads) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]uint, 0, len(squads))
+	for _, squad := range squads {
+		ids = append(ids, squad.ID)
+	}
+
+	var unpaid_ids []uint
+	if err := db.Model(&user.User{}).Where("squad_id IN ? AND paiment_status = ?", ids, false).Distinct().Pluck("squad_id", &unpaid_ids).Error; err != nil {
+		return nil, err
+	}
+
+	unpaid := map[uint]bool{}
+	for _, id := range unpaid_ids {
+		unpaid[id] = true
+	}
+
+	var result []Squad
+	for _, squad := range squads {
+		if unpaid[squad.ID] {
+			result = append(result, squad)
+		}
+	}
+
+	return result, nil
+}
+
+// expire the late squads then give their places to the waitlist, under the same lock
+func CheckWaitlist(db *gorm.DB, cfg *config.Config) error {
+
+	var expired, promoted []Squad
+	err := withWaitlistLock(db, func(tx *gorm.DB) (err error) {
+		if expired, err = expireSquads(tx, cfg); err != nil {
+			return err
+		}
+		promoted, err = promoteWaitlist(tx, cfg)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	notifySquads(db, cfg, append(expired, promoted...))
+	return nil
+}
+
+// check the waitlist every interval
+func StartWaitlistWorker(db *gorm.DB, cfg *config.Config, interval time.Duration) {
+
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for ; true; <-ticker.C {
+			if err := CheckWaitlist(db, cfg); err != nil {
+				log.Println("[WARNING] waitlist worker:", err)
+			}
+		}
+	}()
+}
+
+// position of the squad in the waitlist, starting at 1
+func waitlistPosition(db *gorm.DB, squad Squad) (int, error) {
+
+	waitlist, err := GetWaitlist(db)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, waitlisted := range waitlist {
+		if waitlisted.ID == squad.ID {
+			return i + 1, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// Confirm squad
+// @Security bearerAuth
+// @Summary Confirm the place of the squad
+// @Description This method confirms the participation of a squad promoted from the waitlist before its deadline.
+// @Tags Squad
+// @Produce json
+// @Schemes
+// @Success 200 {object} squad.Squad
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 409 {object} gin.H
+// @Router /auth/jwt/confirm [post]
+func (db Database) ConfirmSquad(ctx *gin.Context) {
+
+	// get session value
+	session := middleware.ExtractTokenValues(ctx)
+
+	//get leader by id
+	dbLeader, err := user.GetUserByID(db.DB, session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	//get squad by id
+	dbSquad, err := GetSquadByID(db.DB, dbLeader.SquadID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	if dbSquad.Status != StatusPromoted {
+		ctx.JSON(http.StatusConflict, gin.H{"message": "squad has no place to confirm", "status": dbSquad.Status})
+		return
+	}
+
+	// the worker may not have expired it yet
+	if dbSquad.ConfirmBy != nil && dbSquad.ConfirmBy.Before(time.Now()) {
+		ctx.JSON(http.StatusConflict, gin.H{"message": "confirmation deadline has passed"})
+		return
+	}
+
+	confirmed, err := TransitionSquad(db.DB, dbSquad, StatusAccepted, session.UserID, "")
+	if err != nil {
+		ctx.JSON(transitionErrorStatus(err), gin.H{"message": err.Error()})
+		return
+	}
+
+	NotifySquadStatus(db.DB, db.Config.Email, confirmed, "")
+
+	ctx.JSON(http.StatusOK, confirmed)
+}
+
+// Withdraw squad
+// @Security bearerAuth
+// @Summary Withdraw the squad from the event
+// @Description This method withdraws the submitted squad of the leader, its place goes to the waitlist.
+// @Tags Squad
+// @Produce json
+// @Schemes
+// @Success 200 {object} squad.Squad
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 409 {object} gin.H
+// @Router /auth/jwt/withdraw [post]
+func (db Database) WithdrawSquad(ctx *gin.Context) {
+
+	// get session value
+	session := middleware.ExtractTokenValues(ctx)
+
+	//get leader by id
+	dbLeader, err := user.GetUserByID(db.DB, session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	//get squad by id
+	dbSquad, err := GetSquadByID(db.DB, dbLeader.SquadID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	withdrawn, err := TransitionSquad(db.DB, dbSquad, StatusWithdrawn, session.UserID, "")
+	if err != nil {
+		ctx.JSON(transitionErrorStatus(err), gin.H{"message": err.Error()})
+		return
+	}
+
+	NotifySquadStatus(db.DB, db.Config.Email, withdrawn, "")
+
+	// give the place to the waitlist
+	if _, err := PromoteWaitlist(db.DB, db.Config); err != nil {
+		log.Println("[WARNING] waitlist promotion:", err)
+	}
+
+	ctx.JSON(http.StatusOK, withdrawn)
+}
+
+// Get my waitlist position
+// @Security bearerAuth
+// @Summary Get the waitlist position of the squad of the current user
+// @Description This method returns the status of the squad, its position when waitlisted and its deadline when promoted.
+// @Tags Squad
+// @Produce json
+// @Schemes
+// @Success 200 {object} squad.WaitlistPosition
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Router /auth/jwt/squad/waitlist [get]
+func (db Database) GetMyWaitlistPosition(ctx *gin.Context) {
+
+	// get session value
+	session := middleware.ExtractTokenValues(ctx)
+
+	dbUser, err := user.GetUserByID(db.DB, session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	dbSquad, err := GetSquadByID(db.DB, dbUser.SquadID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	position, err := waitlistPosition(db.DB, dbSquad)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, WaitlistPosition{Status: dbSquad.Status, Position: position, ConfirmBy: dbSquad.ConfirmBy})
+}
+
+// Get waitlist
+// @Security bearerAuth
+// @Summary Get the waitlist
+// @Description This method returns the waitlisted squads in the order they will be promoted.
+// @Tags Squad
+// @Produce json
+// @Schemes
+// @Success 200 {array} squad.Squad
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Router /auth/jwt/waitlist [get]
+func (db Database) GetWaitlist(ctx *gin.Context) {
+
+	squads, err := GetWaitlist(db.DB)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, squads)
+}
@@ -2,12 +2,13 @@ package user
 
 import (
 	"net/http"
-	"os"
-	"regexp"
 	"strconv"
 	"time"
 
 	"github.com/casbin/casbin/v2"
+	"github.com/ezzddinne/api/app/audit"
+	"github.com/ezzddinne/api/form"
+	"github.com/ezzddinne/config"
 	"github.com/ezzddinne/middleware"
 	"github.com/ezzddinne/middleware_reset"
 	"github.com/gin-gonic/gin"
@@ -17,7 +18,8 @@ import (
 
 type Database struct {
 	DB       *gorm.DB
-	Enforcer *casbin.Enforcer
+	Enforcer *casbin.SyncedEnforcer
+	Config   *config.Config
 }
 
 // create new leader
@@ -38,7 +40,7 @@ func (db Database) NewLeader(ctx *gin.Context) {
 
 	// init vars
 	var leader User
-	empty_reg, _ := regexp.Compile(os.Getenv("EMPTY_REGEX"))
+	empty_reg := db.Config.EmptyRegexp()
 
 	// unmarshal sent json
 	if err := ctx.ShouldBindJSON(&leader); err != nil {
@@ -52,6 +54,13 @@ func (db Database) NewLeader(ctx *gin.Context) {
 		return
 	}
 
+	// check the answers to the registration form
+	answers, err := form.ValidateAnswers(db.DB, form.RegistrationDomain(), form.ScopeUser, leader.Answers, 0)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, form.ErrorResponse(err))
+		return
+	}
+
 	//hash password
 	HashPassword(&leader.Password)
 
@@ -81,11 +90,23 @@ func (db Database) NewLeader(ctx *gin.Context) {
 		return
 	}
 
+	// add the leader to the role
+	if _, err := db.Enforcer.AddGroupingPolicy(middleware.Subject(new_leader_created.ID), new_leader_created.Role, middleware.DefaultDomain()); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	// save the answers
+	if err := form.SaveUserAnswers(db.DB, new_leader_created.ID, answers); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
 	//Send email with code
 	subject := "Coding Moon Community Want To Say Hi !"
 
 	// Send Email
-	SendGomail(subject, new_leader_created.Email, "api/user/Registration.html", new_leader_created)
+	SendGomail(db.Config.Email, subject, new_leader_created.Email, "api/user/Registration.html", new_leader_created)
 
 	//leader created successfully
 	ctx.JSON(http.StatusOK, gin.H{"message": "Leader created successfully"})
@@ -97,7 +118,7 @@ func (db Database) handleEmailVerification(ctx *gin.Context) {
 
 	// init vars
 	var leader User
-	empty_reg, _ := regexp.Compile(os.Getenv("EMPTY_REGEX"))
+	empty_reg := db.Config.EmptyRegexp()
 
 	// unmarshal sent json
 	if err := ctx.ShouldBindJSON(&leader); err != nil {
@@ -184,11 +205,12 @@ func (db Database) SignInLeader(ctx *gin.Context) {
 
 	//init vars
 	var leader_login LeaderLogIn
-	empty_reg, _ := regexp.Compile(os.Getenv("EMPTY_REGEX"))
+	empty_reg := db.Config.EmptyRegexp()
 
 	// unmarshal sent json
 	if err := ctx.ShouldBindJSON(&leader_login); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
 	}
 
 	// check field validity
@@ -197,39 +219,174 @@ func (db Database) SignInLeader(ctx *gin.Context) {
 		return
 	}
 
+	// login attempt
+	login_event := LoginEvent{
+		Email:     leader_login.Email,
+		IP:        ctx.ClientIP(),
+		UserAgent: ctx.Request.UserAgent(),
+	}
+
 	//check if email exists ==> user
 	dbLeader, err := GetUserByEmail(db.DB, leader_login.Email)
 	if err != nil {
+		login_event.Reason = "unknown email"
+		NewLoginEvent(db.DB, login_event)
 		ctx.JSON(http.StatusBadRequest, gin.H{"message": "No Such User Found"})
 		return
 	}
+	login_event.UserID = dbLeader.ID
 
 	// Verify if the user is verified
-	if dbLeader.IsVerified {
+	if !dbLeader.IsVerified {
+		login_event.Reason = "not verified"
+		NewLoginEvent(db.DB, login_event)
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "This user is not verified"})
+		return
+	}
 
-		// update last login
-		dbLeader.LastLogin = time.Now().Format("2006-01-02 15:04:05")
+	//compare password
+	if !ComparePasswords(dbLeader.Password, leader_login.Password) {
+		login_event.Reason = "password not matched"
+		NewLoginEvent(db.DB, login_event)
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "password not matched"})
+		return
+	}
 
-		// update user
-		if err := UpdateUser(db.DB, dbLeader); err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
-			return
-		}
+	// update last login
+	dbLeader.LastLogin = time.Now().Format("2006-01-02 15:04:05")
 
-		//compare password
-		if isTrue := ComparePasswords(dbLeader.Password, leader_login.Password); isTrue {
+	// update user
+	if err := UpdateUser(db.DB, dbLeader); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
 
-			//generate token
-			token := middleware.GenerateToken(dbLeader.ID, dbLeader.SquadID, dbLeader.Role)
-			ctx.JSON(http.StatusOK, LeaderLogedIn{Token: token})
-			return
-		}
+	// check the device before saving this login
+	known_device := CheckKnownDevice(db.DB, dbLeader.ID, login_event.UserAgent)
 
-		ctx.JSON(http.StatusBadRequest, gin.H{"message": "password not matched"})
-	} else {
-		ctx.JSON(http.StatusBadRequest, gin.H{"message": "This user is not verified"})
+	// create the session
+	session, err := NewSession(db.DB, UserSession{
+		ID:         uuid.New().String(),
+		UserID:     dbLeader.ID,
+		IP:         login_event.IP,
+		UserAgent:  login_event.UserAgent,
+		LastSeenAt: time.Now(),
+		ExpiresAt:  time.Now().Add(db.Config.Token.SessionTTL()),
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	login_event.Success = true
+	NewLoginEvent(db.DB, login_event)
+
+	//generate token
+	token, err := middleware.GenerateToken(dbLeader.ID, dbLeader.SquadID, dbLeader.Role, session.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, LeaderLogedIn{Token: token})
+
+	// notify the user of the new device
+	if !known_device {
+		SendNewDeviceGomail(db.Config.Email, "New sign-in to your Coding Moon account", dbLeader.Email, "api/user/NewDevice.html", DeviceEmailData{
+			FirstName: dbLeader.FirstName,
+			LastName:  dbLeader.LastName,
+			IP:        login_event.IP,
+			UserAgent: login_event.UserAgent,
+			Time:      time.Now().Format("2006-01-02 15:04:05"),
+		})
+	}
+}
+
+// list the active sessions
+// @Security bearerAuth
+// @Summary Active sessions
+// @Description This method lists the active sessions of the signed in user.
+// @Tags User
+// @Produce json
+// @Schemes
+// @Success 200 {array} user.UserSession
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /user/jwt/me/sessions [get]
+func (db Database) GetMySessions(ctx *gin.Context) {
+
+	// get values from session
+	session := middleware.ExtractTokenValues(ctx)
+
+	sessions, err := GetActiveSessions(db.DB, session.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// flag the session of this request
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == session.SessionID
+	}
+
+	ctx.JSON(http.StatusOK, sessions)
+}
+
+// revoke one session
+// @Security bearerAuth
+// @Summary Revoke session
+// @Description This method signs out one session of the signed in user.
+// @Tags User
+// @Produce json
+// @Param id path string true "Session ID"
+// @Schemes
+// @Success 200 {string} string "Revoked"
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /user/jwt/me/sessions/{id} [delete]
+func (db Database) RevokeMySession(ctx *gin.Context) {
+
+	// get values from session
+	session := middleware.ExtractTokenValues(ctx)
+
+	revoked, err := RevokeSession(db.DB, session.UserID, ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	if revoked == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "session not found"})
+		return
 	}
 
+	ctx.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully"})
+}
+
+// revoke all sessions
+// @Security bearerAuth
+// @Summary Revoke all sessions
+// @Description This method signs out every session of the signed in user.
+// @Tags User
+// @Produce json
+// @Schemes
+// @Success 200 {string} string "Revoked"
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /user/jwt/me/sessions [delete]
+func (db Database) RevokeAllMySessions(ctx *gin.Context) {
+
+	// get values from session
+	session := middleware.ExtractTokenValues(ctx)
+
+	if err := RevokeAllSessions(db.DB, session.UserID); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Sessions revoked successfully"})
 }
 
 // Get all users
@@ -361,6 +518,13 @@ func (db Database) ChangePaimentStatus(ctx *gin.Context) {
 		return
 	}
 
+	// get the user before the change
+	dbUser, err := GetUserByID(db.DB, uint(user_id))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
 	paiment_status := User{
 		ID:             uint(user_id),
 		Paiment_Status: true,
@@ -376,15 +540,21 @@ func (db Database) ChangePaimentStatus(ctx *gin.Context) {
 
 	//updated successfully
 	ctx.JSON(http.StatusOK, gin.H{"message": "Paiment Status changed successfully"})
+
+	// audit the change
+	audit.Record(db.DB, ctx, "paiment.update", "user:"+strconv.Itoa(user_id),
+		gin.H{"paiment_status": dbUser.Paiment_Status, "paiment_date": dbUser.Paiment_Date},
+		gin.H{"paiment_status": paiment_status.Paiment_Status, "paiment_date": paiment_status.Paiment_Date})
 }
 
 // get users by squad ID
 func (db Database) GetUsersBySquadID(ctx *gin.Context) {
 
 	// get the value from the path
-	squad_id, err := strconv.Atoi(ctx.Param("squad_id"))
+	squad_id, err := strconv.Atoi(ctx.Param("id"))
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
 	// get the users by squad ID
@@ -416,7 +586,7 @@ func (db Database) ForgetPassword(ctx *gin.Context) {
 
 	//init vars
 	var userrsp ForgotPasswordInput
-	empty_reg, _ := regexp.Compile(os.Getenv("EMPTY_REGEX"))
+	empty_reg := db.Config.EmptyRegexp()
 
 	// unmarshal sent json
 	if err := ctx.ShouldBindJSON(&userrsp); err != nil {
@@ -438,7 +608,11 @@ func (db Database) ForgetPassword(ctx *gin.Context) {
 	}
 
 	//generate token
-	token := middleware_reset.GenerateResetToken(dbLeader.ID, dbLeader.SquadID, dbLeader.Role)
+	token, err := middleware_reset.GenerateResetToken(dbLeader.ID, dbLeader.SquadID, dbLeader.Role)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
 
 	ctx.JSON(http.StatusOK, ResetTokenUser{LeaderID: dbLeader.ID, Email: dbLeader.Email, ResetToken: token})
 
@@ -450,7 +624,7 @@ func (db Database) ForgetPassword(ctx *gin.Context) {
 		Subject: "Reset Your Password",
 	}
 
-	SendForgetGomail(&emailData, dbLeader.Email, "api/user/Reset_password.html", dbLeader)
+	SendForgetGomail(db.Config.Email, &emailData, dbLeader.Email, "api/user/Reset_password.html", dbLeader)
 
 	ctx.JSON(http.StatusOK, gin.H{"status": "success", "message": "Check your mail please"})
 }
@@ -475,7 +649,7 @@ func (db Database) ResetPassword(ctx *gin.Context) {
 
 	//init vars
 	var reset ResetPasswordInput
-	empty_reg, _ := regexp.Compile(os.Getenv("EMPTY_REGEX"))
+	empty_reg := db.Config.EmptyRegexp()
 
 	// unmarshal sent json
 	if err := ctx.ShouldBindJSON(&reset); err != nil {
@@ -520,6 +694,68 @@ func (db Database) ResetPassword(ctx *gin.Context) {
 		return
 	}
 
+	// sign out every session using the old password
+	if err := RevokeAllSessions(db.DB, dbLeader.ID); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
 	ctx.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
 
 }
+
+// impersonate user
+// @Security bearerAuth
+// @Summary Impersonate user
+// @Description This method issues a short lived token to act as the user, root only.
+// @Tags User
+// @Produce json
+// @Param id path uint true "User ID"
+// @Schemes
+// @Success 200 {object} user.LeaderLogedIn
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /user/jwt/{id}/impersonate [post]
+func (db Database) ImpersonateUser(ctx *gin.Context) {
+
+	// get values from session
+	session := middleware.ExtractTokenValues(ctx)
+
+	// only root can impersonate
+	if is_root, _ := db.Enforcer.HasRoleForUser(middleware.Subject(session.UserID), db.Config.RBAC.DefaultRoot, middleware.AllDomains); session.UserID == 0 || !is_root {
+		ctx.JSON(http.StatusForbidden, gin.H{"message": "You are not authorized"})
+		return
+	}
+
+	// get id from path
+	user_id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// get the target user
+	dbUser, err := GetUserByID(db.DB, uint(user_id))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	if dbUser.ID == session.UserID {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "You can't impersonate yourself"})
+		return
+	}
+
+	//generate token
+	token, err := middleware.GenerateImpersonationToken(dbUser.ID, dbUser.SquadID, dbUser.Role, session.UserID, session.SessionID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, LeaderLogedIn{Token: token})
+
+	// audit the impersonation
+	audit.Record(db.DB, ctx, "impersonation.start", "user:"+strconv.Itoa(user_id), nil, gin.H{"actor_id": session.UserID, "user_id": dbUser.ID})
+}
@@ -0,0 +1,44 @@
+package user
+
+import (
+	"strconv"
+
+	"github.com/ezzddinne/middleware"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// the user is a member of the squad in the path
+func InSquadParam(db *gorm.DB) middleware.OwnerResolver {
+	return func(ctx *gin.Context, user_id uint) (bool, error) {
+
+		squad_id, err := strconv.Atoi(ctx.Param("id"))
+		if err != nil || user_id == 0 {
+			return false, nil
+		}
+
+		dbUser, err := GetUserByID(db, user_id)
+		if err != nil {
+			return false, nil
+		}
+
+		return dbUser.SquadID != 0 && dbUser.SquadID == uint(squad_id), nil
+	}
+}
+
+// the user is a member of a squad, the request targets his own squad
+func InOwnSquad(db *gorm.DB) middleware.OwnerResolver {
+	return func(ctx *gin.Context, user_id uint) (bool, error) {
+
+		if user_id == 0 {
+			return false, nil
+		}
+
+		dbUser, err := GetUserByID(db, user_id)
+		if err != nil {
+			return false, nil
+		}
+
+		return dbUser.SquadID != 0, nil
+	}
+}
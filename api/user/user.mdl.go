@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"fmt"
 	"html/template"
-	"os"
+	"time"
 
+	"github.com/ezzddinne/api/form"
+	"github.com/ezzddinne/config"
 	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/gomail.v2"
 	"gorm.io/gorm"
@@ -28,9 +30,36 @@ type User struct {
 	LastLogin      string `gorm:"column:last_login" json:"last_login"`
 	Role           string `gorm:"column:role;not null" json:"role"`
 	SquadID        uint   `gorm:"column:squad_id" json:"squad_id"`
+	CvFileID       *uint  `gorm:"column:cv_file_id" json:"cv_file_id"`
+
+	// answers to the registration form, sent at signup
+	Answers form.Answers `gorm:"-" json:"answers,omitempty"`
 	gorm.Model
 }
 
+type LoginEvent struct {
+	ID        uint      `gorm:"column:id;autoIncrement;primaryKey" json:"id"`
+	UserID    uint      `gorm:"column:user_id;index" json:"user_id"`
+	Email     string    `gorm:"column:email" json:"email"`
+	Success   bool      `gorm:"column:success;not null" json:"success"`
+	Reason    string    `gorm:"column:reason" json:"reason"`
+	IP        string    `gorm:"column:ip" json:"ip"`
+	UserAgent string    `gorm:"column:user_agent" json:"user_agent"`
+	CreatedAt time.Time `gorm:"column:created_at;index" json:"created_at"`
+}
+
+type UserSession struct {
+	ID         string     `gorm:"column:id;primaryKey" json:"id"`
+	UserID     uint       `gorm:"column:user_id;not null;index" json:"user_id"`
+	IP         string     `gorm:"column:ip" json:"ip"`
+	UserAgent  string     `gorm:"column:user_agent" json:"user_agent"`
+	LastSeenAt time.Time  `gorm:"column:last_seen_at" json:"last_seen_at"`
+	ExpiresAt  time.Time  `gorm:"column:expires_at;not null" json:"expires_at"`
+	RevokedAt  *time.Time `gorm:"column:revoked_at" json:"revoked_at"`
+	CreatedAt  time.Time  `gorm:"column:created_at" json:"created_at"`
+	Current    bool       `gorm:"-" json:"current"`
+}
+
 type LeaderLogIn struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
@@ -64,6 +93,14 @@ type EmailData struct {
 	Subject   string
 }
 
+type DeviceEmailData struct {
+	FirstName string
+	LastName  string
+	IP        string
+	UserAgent string
+	Time      string
+}
+
 // hash password
 func HashPassword(pass *string) {
 	bytePass := []byte(*pass)
@@ -150,6 +187,48 @@ func GetMembersBySquadID(db *gorm.DB, squad_id uint) (users []User, err error) {
 	return users, db.Where("squad_id = ?", squad_id).Find(&users).Error
 }
 
+// attach the cv file to the user, nil removes it
+func SetUserCV(db *gorm.DB, user_id uint, file_id *uint) error {
+	return db.Model(&User{}).Where("id = ?", user_id).Update("cv_file_id", file_id).Error
+}
+
+// create new login event
+func NewLoginEvent(db *gorm.DB, event LoginEvent) error {
+	return db.Create(&event).Error
+}
+
+// check the user already signed in from this device
+func CheckKnownDevice(db *gorm.DB, user_id uint, user_agent string) bool {
+
+	//init vars
+	var count int64
+
+	db.Model(&LoginEvent{}).Where("user_id = ? AND user_agent = ? AND success = ?", user_id, user_agent, true).Count(&count)
+
+	return count > 0
+}
+
+// create new session
+func NewSession(db *gorm.DB, session UserSession) (UserSession, error) {
+	return session, db.Create(&session).Error
+}
+
+// get the active sessions of the user
+func GetActiveSessions(db *gorm.DB, user_id uint) (sessions []UserSession, err error) {
+	return sessions, db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", user_id, time.Now()).Order("last_seen_at desc").Find(&sessions).Error
+}
+
+// revoke one session of the user
+func RevokeSession(db *gorm.DB, user_id uint, session_id string) (int64, error) {
+	revoke := db.Model(&UserSession{}).Where("id = ? AND user_id = ? AND revoked_at IS NULL", session_id, user_id).Update("revoked_at", time.Now())
+	return revoke.RowsAffected, revoke.Error
+}
+
+// revoke all the sessions of the user
+func RevokeAllSessions(db *gorm.DB, user_id uint) error {
+	return db.Model(&UserSession{}).Where("user_id = ? AND revoked_at IS NULL", user_id).Update("revoked_at", time.Now()).Error
+}
+
 // compare two passwords
 func ComparePasswords(dbpass, pass string) bool {
 	return bcrypt.CompareHashAndPassword([]byte(dbpass), []byte(pass)) == nil
@@ -161,7 +240,7 @@ func VerifyEmail(db *gorm.DB, code string) (user User, err error) {
 }
 
 // Send Email
-func SendGomail(subject, email, templatePath string, user User) {
+func SendGomail(cfg config.EmailConfig, subject, email, templatePath string, user User) {
 
 	// Get the HTML template
 	t, err := template.ParseFiles(templatePath)
@@ -178,12 +257,12 @@ func SendGomail(subject, email, templatePath string, user User) {
 
 	// Send With Gomail
 	m := gomail.NewMessage()
-	m.SetHeader("From", os.Getenv("EMAIL_SENDER"))
+	m.SetHeader("From", cfg.Sender)
 	m.SetHeader("To", email)
 	m.SetHeader("Subject", subject)
 	m.SetBody("text/html", body.String())
 
-	d := gomail.NewDialer(os.Getenv("EMAIL_SMTP_SERVER"), 587, os.Getenv("EMAIL_SENDER"), "euctetwblhjmlldz")
+	d := gomail.NewDialer(cfg.SMTPServer, cfg.SMTPPort, cfg.Sender, cfg.Password)
 
 	// Send the email
 	if err := d.DialAndSend(m); err != nil {
@@ -193,7 +272,7 @@ func SendGomail(subject, email, templatePath string, user User) {
 
 }
 
-func SendForgetGomail(data *EmailData, email, templatePath string, user User) {
+func SendForgetGomail(cfg config.EmailConfig, data *EmailData, email, templatePath string, user User) {
 
 	// Get the HTML template
 	t, err := template.ParseFiles(templatePath)
@@ -210,14 +289,14 @@ func SendForgetGomail(data *EmailData, email, templatePath string, user User) {
 
 	// Send With Gomail
 	m := gomail.NewMessage()
-	m.SetHeader("From", os.Getenv("EMAIL_SENDER"))
+	m.SetHeader("From", cfg.Sender)
 	m.SetHeader("To", email)
 	m.SetHeader("Subject", data.Subject)
 	m.SetBody("text/html", body.String())
 
 	// m.Attach("/home/Alex/lolcat.jpg")
 
-	d := gomail.NewDialer(os.Getenv("EMAIL_SMTP_SERVER"), 587, os.Getenv("EMAIL_SENDER"), "euctetwblhjmlldz")
+	d := gomail.NewDialer(cfg.SMTPServer, cfg.SMTPPort, cfg.Sender, cfg.Password)
 
 	// Send the email
 	if err := d.DialAndSend(m); err != nil {
@@ -228,7 +307,41 @@ func SendForgetGomail(data *EmailData, email, templatePath string, user User) {
 }
 
 // Send Validation Email
-func SendValidationGomail(subject, email, templatePath string, user User) {
+func SendValidationGomail(cfg config.EmailConfig, subject, email, templatePath string, user User) {
+	if err := SendTemplateGomail(cfg, subject, email, templatePath, struct{ FirstName, LastName string }{FirstName: user.FirstName, LastName: user.LastName}); err != nil {
+		fmt.Println("Failed to send email:", err)
+	}
+}
+
+// Send an email from a template filled with data
+func SendTemplateGomail(cfg config.EmailConfig, subject, email, templatePath string, data interface{}) error {
+
+	// Get the HTML template
+	t, err := template.ParseFiles(templatePath)
+	if err != nil {
+		return fmt.Errorf("failed to parse HTML template: %w", err)
+	}
+
+	var body bytes.Buffer
+	if err := t.Execute(&body, data); err != nil {
+		return fmt.Errorf("failed to execute template: %w", err)
+	}
+
+	// Send With Gomail
+	m := gomail.NewMessage()
+	m.SetHeader("From", cfg.Sender)
+	m.SetHeader("To", email)
+	m.SetHeader("Subject", subject)
+	m.SetBody("text/html", body.String())
+
+	d := gomail.NewDialer(cfg.SMTPServer, cfg.SMTPPort, cfg.Sender, cfg.Password)
+
+	// Send the email
+	return d.DialAndSend(m)
+}
+
+// Send New Device Email
+func SendNewDeviceGomail(cfg config.EmailConfig, subject, email, templatePath string, data DeviceEmailData) {
 
 	// Get the HTML template
 	t, err := template.ParseFiles(templatePath)
@@ -238,19 +351,19 @@ func SendValidationGomail(subject, email, templatePath string, user User) {
 	}
 
 	var body bytes.Buffer
-	if err := t.Execute(&body, struct{ FirstName, LastName string }{FirstName: user.FirstName, LastName: user.LastName}); err != nil {
+	if err := t.Execute(&body, data); err != nil {
 		fmt.Println("Failed to execute template:", err)
 		return
 	}
 
 	// Send With Gomail
 	m := gomail.NewMessage()
-	m.SetHeader("From", os.Getenv("EMAIL_SENDER"))
+	m.SetHeader("From", cfg.Sender)
 	m.SetHeader("To", email)
 	m.SetHeader("Subject", subject)
 	m.SetBody("text/html", body.String())
 
-	d := gomail.NewDialer(os.Getenv("EMAIL_SMTP_SERVER"), 587, os.Getenv("EMAIL_SENDER"), "euctetwblhjmlldz")
+	d := gomail.NewDialer(cfg.SMTPServer, cfg.SMTPPort, cfg.Sender, cfg.Password)
 
 	// Send the email
 	if err := d.DialAndSend(m); err != nil {
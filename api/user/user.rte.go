@@ -2,14 +2,15 @@ package user
 
 import (
 	"github.com/casbin/casbin/v2"
+	"github.com/ezzddinne/config"
 	"github.com/ezzddinne/middleware"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
-func RoutesAuth(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.Enforcer) {
+func RoutesAuth(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.SyncedEnforcer, cfg *config.Config) {
 
-	baseInstance := Database{DB: db, Enforcer: enforcer}
+	baseInstance := Database{DB: db, Enforcer: enforcer, Config: cfg}
 
 	// Create leader route
 	router.POST("/new", baseInstance.NewLeader)
@@ -22,17 +23,17 @@ func RoutesAuth(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.Enforcer)
 
 }
 
-func RouteAdmin(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.Enforcer) {
+func RouteAdmin(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.SyncedEnforcer, cfg *config.Config) {
 
-	baseInstance := Database{DB: db, Enforcer: enforcer}
+	baseInstance := Database{DB: db, Enforcer: enforcer, Config: cfg}
 
 	// Change paiment status route
 	router.PATCH("/:id", middleware.Authorize("paiment", "write", enforcer), baseInstance.ChangePaimentStatus)
 }
 
-func RoutesUsersJWT(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.Enforcer) {
+func RoutesUsersJWT(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.SyncedEnforcer, cfg *config.Config) {
 
-	baseInstance := Database{DB: db, Enforcer: enforcer}
+	baseInstance := Database{DB: db, Enforcer: enforcer, Config: cfg}
 
 	// Get all users route
 	router.GET("/allusers", middleware.Authorize("users", "read", enforcer), baseInstance.GetAllUsers)
@@ -40,6 +41,18 @@ func RoutesUsersJWT(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.Enfor
 	// Get user by id route
 	router.GET("/:id", middleware.Authorize("users", "read", enforcer), baseInstance.GetUserByID)
 
+	// Get active sessions route
+	router.GET("/me/sessions", baseInstance.GetMySessions)
+
+	// Revoke all sessions route
+	router.DELETE("/me/sessions", middleware.DenyImpersonation(), baseInstance.RevokeAllMySessions)
+
+	// Revoke session route
+	router.DELETE("/me/sessions/:id", middleware.DenyImpersonation(), baseInstance.RevokeMySession)
+
+	// Impersonate user route
+	router.POST("/:id/impersonate", middleware.Authorize("impersonation", "write", enforcer), middleware.DenyImpersonation(), baseInstance.ImpersonateUser)
+
 	// Get user by id to merge squad
 	router.GET("/id", middleware.Authorize("front", "read", enforcer), baseInstance.GetUserByIDFront)
 
@@ -47,15 +60,15 @@ func RoutesUsersJWT(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.Enfor
 	router.GET("/role/:role", middleware.Authorize("users", "read", enforcer), baseInstance.GetUsersByRole)
 
 	// Get users by squad id
-	router.GET("/squad/:id", middleware.Authorize("users", "read", enforcer), baseInstance.GetUsersBySquadID)
+	router.GET("/squad/:id", middleware.AuthorizeResource("users", "read", enforcer, InSquadParam(db)), baseInstance.GetUsersBySquadID)
 
 	// Delete user route
-	router.DELETE("/:id", middleware.Authorize("users", "write", enforcer), baseInstance.DeleteUser)
+	router.DELETE("/:id", middleware.Authorize("users", "delete", enforcer), middleware.DenyImpersonation(), baseInstance.DeleteUser)
 }
 
-func RoutesUserPassword(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.Enforcer) {
+func RoutesUserPassword(router *gin.RouterGroup, db *gorm.DB, enforcer *casbin.SyncedEnforcer, cfg *config.Config) {
 
-	baseInstance := Database{DB: db, Enforcer: enforcer}
+	baseInstance := Database{DB: db, Enforcer: enforcer, Config: cfg}
 
 	//Forget Password route
 	router.POST("/forgotpassword", baseInstance.ForgetPassword)
@@ -0,0 +1,555 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// configuration of the application, loaded once at startup
+//
+// every field is read from its env tag, the values come from the defaults,
+// the config file, the environment and the flags, the last one wins
+type Config struct {
+	AppPort    string `env:"APP_PORT" default:":1333" desc:"address the api listens on"`
+	EmptyRegex string `env:"EMPTY_REGEX" default:"^\\s*$" desc:"regex of the empty input fields"`
+
+	Database   DatabaseConfig
+	Token      TokenConfig
+	Email      EmailConfig
+	Cloudinary CloudinaryConfig
+	Storage    StorageConfig
+	Upload     UploadConfig
+	Scanner    ScannerConfig
+	Squad      SquadConfig
+	RBAC       RBACConfig
+	Root       RootConfig
+
+	empty_reg *regexp.Regexp
+}
+
+type DatabaseConfig struct {
+	Host     string `env:"DB_HOST" default:"localhost" required:"true"`
+	Port     int    `env:"DB_PORT" default:"5432" required:"true"`
+	User     string `env:"DB_USER" required:"true"`
+	Password string `env:"DB_PASSWORD" secret:"true"`
+	Name     string `env:"DB_NAME" required:"true"`
+}
+
+type TokenConfig struct {
+	Duration              int    `env:"TOKEN_DURATION" default:"24" desc:"session token lifetime in hours"`
+	ResetDuration         int    `env:"RESET_TOKEN_DURATION" default:"15" desc:"reset token lifetime in minutes"`
+	ImpersonationDuration int    `env:"IMPERSONATION_DURATION" default:"15" desc:"impersonation token lifetime in minutes"`
+	KeysDir               string `env:"JWT_KEYS_DIR" default:"config/keys" desc:"pem keys imported into the database at startup"`
+	SigningAlg            string `env:"JWT_SIGNING_ALG" default:"RS256" desc:"RS256 or EdDSA"`
+	KeyRotation           int    `env:"JWT_KEY_ROTATION" default:"0" desc:"signing key rotation in hours, 0 to disable"`
+}
+
+type EmailConfig struct {
+	Sender     string `env:"EMAIL_SENDER"`
+	SMTPServer string `env:"EMAIL_SMTP_SERVER"`
+	SMTPPort   int    `env:"EMAIL_SMTP_PORT" default:"587"`
+	Password   string `env:"EMAIL_SMTP_PASSWORD" secret:"true"`
+}
+
+type CloudinaryConfig struct {
+	CloudName    string `env:"CLOUDINARY_CLOUD_NAME"`
+	APIKey       string `env:"CLOUDINARY_API_KEY" secret:"true"`
+	APISecret    string `env:"CLOUDINARY_API_SECRET" secret:"true"`
+	UploadFolder string `env:"CLOUDINARY_UPLOAD_FOLDER"`
+}
+
+type StorageConfig struct {
+	Backend     string `env:"STORAGE_BACKEND" desc:"local, s3 or cloudinary, cloudinary when CLOUDINARY_CLOUD_NAME is set else local"`
+	LocalDir    string `env:"STORAGE_LOCAL_DIR" default:"uploads" desc:"directory of the local storage"`
+	URLSecret   string `env:"STORAGE_URL_SECRET" secret:"true" required:"true" desc:"key signing the download urls, shared by every instance, 32 characters at least"`
+	URLDuration int    `env:"STORAGE_URL_DURATION" default:"5" desc:"download url lifetime in minutes"`
+	S3Endpoint  string `env:"S3_ENDPOINT" desc:"host[:port] of the s3 compatible server"`
+	S3Region    string `env:"S3_REGION" default:"us-east-1"`
+	S3Bucket    string `env:"S3_BUCKET"`
+	S3AccessKey string `env:"S3_ACCESS_KEY" secret:"true"`
+	S3SecretKey string `env:"S3_SECRET_KEY" secret:"true"`
+	S3UseSSL    bool   `env:"S3_USE_SSL" default:"true"`
+}
+
+type UploadConfig struct {
+	LogoMaxSize int `env:"UPLOAD_LOGO_MAX_SIZE" default:"2048" desc:"max logo size in KB"`
+	CVMaxSize   int `env:"UPLOAD_CV_MAX_SIZE" default:"5120" desc:"max cv size in KB"`
+}
+
+type ScannerConfig struct {
+	Backend      string `env:"SCANNER_BACKEND" default:"clamd" desc:"clamd, or none to mark the uploads clean without scanning them, for development only"`
+	ClamdAddress string `env:"CLAMD_ADDRESS" default:"localhost:3310" desc:"host:port or unix socket path of clamd"`
+	Timeout      int    `env:"SCANNER_TIMEOUT" default:"60" desc:"scan timeout in seconds"`
+	RetryDelay   int    `env:"SCANNER_RETRY" default:"5" desc:"minutes between the scans of the quarantined files, 0 to disable"`
+}
+
+type SquadConfig struct {
+	Capacity         int `env:"SQUAD_CAPACITY" default:"0" desc:"max accepted squads, the next ones are waitlisted, 0 for no limit"`
+	ConfirmDuration  int `env:"SQUAD_CONFIRM_DURATION" default:"48" desc:"hours a squad promoted from the waitlist has to confirm"`
+	PaymentDuration  int `env:"SQUAD_PAYMENT_DURATION" default:"0" desc:"hours an accepted squad has to pay before losing its place, 0 to disable"`
+	WaitlistInterval int `env:"SQUAD_WAITLIST_INTERVAL" default:"5" desc:"minutes between the waitlist checks, 0 to disable"`
+	MaxMembers       int `env:"SQUAD_MAX_MEMBERS" default:"4" desc:"max members of a squad, leader included, 0 for no limit"`
+	MinMembers       int `env:"SQUAD_MIN_MEMBERS" default:"2" desc:"min members of the squads proposed by the matchmaking"`
+	MatchInterval    int `env:"SQUAD_MATCH_INTERVAL" default:"0" desc:"minutes between the matchmaking proposals, 0 to disable"`
+}
+
+type RBACConfig struct {
+	DefaultRoot   string   `env:"DEFAULT_ROOT" default:"root" required:"true"`
+	DefaultUser   string   `env:"DEFAULT_USER" default:"member" required:"true"`
+	DefaultDomain string   `env:"DEFAULT_DOMAIN" default:"default" required:"true"`
+	Actions       []string `env:"PERMISSION_ACTIONS" default:"read,write,delete,approve,export"`
+	PolicyFile    string   `env:"POLICY_FILE" desc:"policy file applied at startup"`
+	PolicyPrune   bool     `env:"POLICY_PRUNE" default:"false"`
+}
+
+type RootConfig struct {
+	Email         string `env:"DEFAULT_EMAIL"`
+	Password      string `env:"DEFAULT_USER_PASSWORD" secret:"true"`
+	FirstName     string `env:"DEFAULT_FIRSTNAME"`
+	LastName      string `env:"DEFAULT_LASTNAME"`
+	University    string `env:"DEFAULT_UNIVERSITY"`
+	Phone         string `env:"DEFAULT_PHONE"`
+	PaimentStatus bool   `env:"DEFAULT_PAIMENT_STATUS" default:"false"`
+	SquadName     string `env:"DEFAULT_SQUAD_NAME"`
+}
+
+// shortest key accepted to sign the download urls
+const minURLSecretLength int = 32
+
+// field of the config bound to an env key
+type field struct {
+	key         string
+	def         string
+	has_default bool
+	desc        string
+	secret      bool
+	required    bool
+	value       reflect.Value
+}
+
+// flags overriding the config, bound with BindFlags
+type Flags struct {
+	file   *string
+	values map[string]*string
+	set    *flag.FlagSet
+}
+
+// config with the default values
+func Default() *Config {
+
+	cfg := &Config{}
+	for _, f := range cfg.fields() {
+		if f.has_default {
+			setValue(f.value, f.def)
+		}
+	}
+	cfg.empty_reg, _ = regexp.Compile(cfg.EmptyRegex)
+
+	return cfg
+}
+
+// add the -config flag and a flag for every key: DB_HOST ==> -db-host
+func BindFlags(set *flag.FlagSet) *Flags {
+
+	flags := &Flags{set: set, values: map[string]*string{}}
+	flags.file = set.String("config", os.Getenv("CONFIG_FILE"), "Config file (.env or .yaml), .env is read if it exists")
+
+	for _, f := range (&Config{}).fields() {
+		name := strings.ToLower(strings.ReplaceAll(f.key, "_", "-"))
+		flags.values[f.key] = set.String(name, "", "Overrides "+f.key+describe(f.desc))
+	}
+
+	return flags
+}
+
+// load the config with the flags once they are parsed
+func (flags *Flags) Load() (*Config, error) {
+
+	overrides := map[string]string{}
+	flags.set.Visit(func(set *flag.Flag) {
+		for key, value := range flags.values {
+			if set.Name == strings.ToLower(strings.ReplaceAll(key, "_", "-")) {
+				overrides[key] = *value
+			}
+		}
+	})
+
+	return Load(*flags.file, overrides)
+}
+
+// load the config from the defaults, the file, the environment and the overrides
+func Load(file string, overrides map[string]string) (*Config, error) {
+
+	values := map[string]string{}
+
+	// the default .env is optional, a file given explicitly must exist
+	explicit := file != ""
+	if !explicit {
+		file = ".env"
+	}
+	if _, err := os.Stat(file); err == nil || explicit {
+		read, err := readFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range read {
+			values[key] = value
+		}
+	}
+
+	cfg := Default()
+	var errs []error
+
+	for _, f := range cfg.fields() {
+		value, ok := values[f.key]
+		if env, set := os.LookupEnv(f.key); set {
+			value, ok = env, true
+		}
+		if override, set := overrides[f.key]; set {
+			value, ok = override, true
+		}
+		if !ok {
+			continue
+		}
+		if err := setValue(f.value, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", f.key, err))
+		}
+	}
+
+	if err := joinErrors(errs); err != nil {
+		return nil, err
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// check the values of the config
+func (cfg *Config) Validate() error {
+
+	var errs []error
+
+	for _, f := range cfg.fields() {
+		if f.required && f.value.IsZero() {
+			errs = append(errs, fmt.Errorf("%s is required", f.key))
+		}
+		if f.value.Kind() == reflect.Int && f.value.Int() < 0 {
+			errs = append(errs, fmt.Errorf("%s can't be negative", f.key))
+		}
+	}
+
+	empty_reg, err := regexp.Compile(cfg.EmptyRegex)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("EMPTY_REGEX: %v", err))
+	}
+	cfg.empty_reg = empty_reg
+
+	if cfg.Token.Duration == 0 {
+		errs = append(errs, fmt.Errorf("TOKEN_DURATION must be positive"))
+	}
+	if cfg.Token.SigningAlg != "RS256" && cfg.Token.SigningAlg != "EdDSA" {
+		errs = append(errs, fmt.Errorf("JWT_SIGNING_ALG must be RS256 or EdDSA"))
+	}
+	switch cfg.Storage.Kind(cfg.Cloudinary) {
+	case "local":
+		if cfg.Storage.LocalDir == "" {
+			errs = append(errs, fmt.Errorf("STORAGE_LOCAL_DIR is required by the local storage"))
+		}
+	case "s3":
+		if cfg.Storage.S3Endpoint == "" || cfg.Storage.S3Bucket == "" {
+			errs = append(errs, fmt.Errorf("S3_ENDPOINT and S3_BUCKET are required by the s3 storage"))
+		}
+	case "cloudinary":
+		if cfg.Cloudinary.CloudName == "" {
+			errs = append(errs, fmt.Errorf("CLOUDINARY_CLOUD_NAME is required by the cloudinary storage"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("STORAGE_BACKEND must be local, s3 or cloudinary"))
+	}
+	if cfg.Upload.LogoMaxSize == 0 || cfg.Upload.CVMaxSize == 0 {
+		errs = append(errs, fmt.Errorf("UPLOAD_LOGO_MAX_SIZE and UPLOAD_CV_MAX_SIZE must be positive"))
+	}
+	if cfg.Scanner.Backend != "none" && cfg.Scanner.Backend != "clamd" {
+		errs = append(errs, fmt.Errorf("SCANNER_BACKEND must be none or clamd"))
+	}
+	if cfg.Scanner.Timeout == 0 {
+		errs = append(errs, fmt.Errorf("SCANNER_TIMEOUT must be positive"))
+	}
+	if cfg.Squad.Capacity < 0 {
+		errs = append(errs, fmt.Errorf("SQUAD_CAPACITY can't be negative"))
+	}
+	if cfg.Squad.MaxMembers < 0 || cfg.Squad.MinMembers < 1 || (cfg.Squad.MaxMembers > 0 && cfg.Squad.MinMembers > cfg.Squad.MaxMembers) {
+		errs = append(errs, fmt.Errorf("SQUAD_MIN_MEMBERS must be between 1 and SQUAD_MAX_MEMBERS"))
+	}
+	if cfg.Squad.ConfirmDuration <= 0 {
+		errs = append(errs, fmt.Errorf("SQUAD_CONFIRM_DURATION must be positive"))
+	}
+	// the urls signed by an instance are checked by the others and after a restart
+	if cfg.Storage.URLSecret != "" && len(cfg.Storage.URLSecret) < minURLSecretLength {
+		errs = append(errs, fmt.Errorf("STORAGE_URL_SECRET must be %d characters at least", minURLSecretLength))
+	}
+	if cfg.Storage.URLDuration == 0 {
+		errs = append(errs, fmt.Errorf("STORAGE_URL_DURATION must be positive"))
+	}
+	if len(cfg.RBAC.Actions) == 0 {
+		errs = append(errs, fmt.Errorf("PERMISSION_ACTIONS can't be empty"))
+	}
+
+	return joinErrors(errs)
+}
+
+// regex of the empty input fields
+func (cfg *Config) EmptyRegexp() *regexp.Regexp {
+	if cfg.empty_reg == nil {
+		cfg.empty_reg, _ = regexp.Compile(cfg.EmptyRegex)
+	}
+	return cfg.empty_reg
+}
+
+// session token lifetime
+func (t TokenConfig) SessionTTL() time.Duration {
+	return time.Hour * time.Duration(t.Duration)
+}
+
+// reset token lifetime
+func (t TokenConfig) ResetTTL() time.Duration {
+	return time.Minute * time.Duration(t.ResetDuration)
+}
+
+// impersonation token lifetime
+func (t TokenConfig) ImpersonationTTL() time.Duration {
+	return time.Minute * time.Duration(t.ImpersonationDuration)
+}
+
+// download url lifetime
+func (s StorageConfig) URLTTL() time.Duration {
+	return time.Minute * time.Duration(s.URLDuration)
+}
+
+// time a promoted squad has to confirm
+func (s SquadConfig) ConfirmTTL() time.Duration {
+	return time.Hour * time.Duration(s.ConfirmDuration)
+}
+
+// time an accepted squad has to pay
+func (s SquadConfig) PaymentTTL() time.Duration {
+	return time.Hour * time.Duration(s.PaymentDuration)
+}
+
+// max logo size in bytes
+func (u UploadConfig) LogoMaxBytes() int64 {
+	return int64(u.LogoMaxSize) * 1024
+}
+
+// max cv size in bytes
+func (u UploadConfig) CVMaxBytes() int64 {
+	return int64(u.CVMaxSize) * 1024
+}
+
+// storage backend, the deployments configured for cloudinary keep using it
+func (s StorageConfig) Kind(cloudinary CloudinaryConfig) string {
+	if s.Backend != "" {
+		return strings.ToLower(s.Backend)
+	}
+	if cloudinary.CloudName != "" {
+		return "cloudinary"
+	}
+	return "local"
+}
+
+// postgres connection string
+func (d DatabaseConfig) DSN() string {
+	return fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=disable", d.Host, d.User, d.Password, d.Name, d.Port)
+}
+
+// values of the config by key, the secrets are hidden
+func (cfg *Config) Redacted() map[string]string {
+
+	values := map[string]string{}
+	for _, f := range cfg.fields() {
+		value := formatValue(f.value)
+		if f.secret && value != "" {
+			value = "******"
+		}
+		values[f.key] = value
+	}
+
+	return values
+}
+
+// config as KEY=value lines, the secrets are hidden
+func (cfg *Config) String() string {
+
+	values := cfg.Redacted()
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, 0, len(keys))
+	for _, key := range keys {
+		lines = append(lines, key+"="+values[key])
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// every field with an env tag, nested structs included
+func (cfg *Config) fields() (fields []field) {
+
+	var walk func(value reflect.Value)
+	walk = func(value reflect.Value) {
+		for i := 0; i < value.NumField(); i++ {
+			struct_field := value.Type().Field(i)
+			if !struct_field.IsExported() {
+				continue
+			}
+			if struct_field.Type.Kind() == reflect.Struct {
+				walk(value.Field(i))
+				continue
+			}
+			key := struct_field.Tag.Get("env")
+			if key == "" {
+				continue
+			}
+			def, has_default := struct_field.Tag.Lookup("default")
+			fields = append(fields, field{
+				key:         key,
+				def:         def,
+				has_default: has_default,
+				desc:        struct_field.Tag.Get("desc"),
+				secret:      struct_field.Tag.Get("secret") == "true",
+				required:    struct_field.Tag.Get("required") == "true",
+				value:       value.Field(i),
+			})
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem())
+
+	return fields
+}
+
+// parse the string into the field
+func setValue(value reflect.Value, raw string) error {
+
+	raw = strings.TrimSpace(raw)
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Int:
+		if raw == "" {
+			value.SetInt(0)
+			return nil
+		}
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		value.SetInt(int64(parsed))
+	case reflect.Bool:
+		if raw == "" {
+			value.SetBool(false)
+			return nil
+		}
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		value.SetBool(parsed)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", value.Kind())
+	}
+
+	return nil
+}
+
+// field value as a string
+func formatValue(value reflect.Value) string {
+	if value.Kind() == reflect.Slice {
+		return strings.Join(value.Interface().([]string), ",")
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+// read a .env or yaml file, the yaml keys are the env keys
+func readFile(file string) (map[string]string, error) {
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var raw map[string]interface{}
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+
+		values := map[string]string{}
+		for key, value := range raw {
+			if value == nil {
+				values[key] = ""
+				continue
+			}
+			if list, ok := value.([]interface{}); ok {
+				items := make([]string, len(list))
+				for i, item := range list {
+					items[i] = fmt.Sprint(item)
+				}
+				values[key] = strings.Join(items, ",")
+				continue
+			}
+			values[key] = fmt.Sprint(value)
+		}
+		return values, nil
+
+	default:
+		return godotenv.Read(file)
+	}
+}
+
+// description appended to the flag usage
+func describe(desc string) string {
+	if desc == "" {
+		return ""
+	}
+	return ": " + desc
+}
+
+// one error listing every message
+func joinErrors(errs []error) error {
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+
+	return errors.New(strings.Join(messages, "; "))
+}
@@ -0,0 +1,374 @@
+package database
+
+import (
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// directory of the sql migrations, used by migrate create
+const MigrationsDir string = "database/migrations"
+
+// key of the postgres advisory lock held while migrating
+const migrationLock int64 = 7365927
+
+// file name of the sql migrations: 0002_add_column.up.sql
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// versioned schema change
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// applied migration
+type SchemaMigration struct {
+	Version   int64     `gorm:"column:version;primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"column:name;not null"`
+	AppliedAt time.Time `gorm:"column:applied_at;not null"`
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// migration and its state
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+}
+
+// migrations written in go, for the backfills that can't be written in sql
+var goMigrations []Migration
+
+// add a go migration, called from the init of the file declaring it
+func RegisterMigration(migration Migration) {
+	goMigrations = append(goMigrations, migration)
+}
+
+// sql and go migrations sorted by version
+func Migrations() ([]Migration, error) {
+
+	files, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	versions := map[int64]*Migration{}
+	for _, file := range files {
+		match := migrationName.FindStringSubmatch(file.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name: %s", file.Name())
+		}
+
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		data, err := migrationFiles.ReadFile("migrations/" + file.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := versions[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			versions[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			migration.Up = sqlMigration(string(data))
+		} else {
+			migration.Down = sqlMigration(string(data))
+		}
+	}
+
+	for i := range goMigrations {
+		if _, ok := versions[goMigrations[i].Version]; ok {
+			return nil, fmt.Errorf("migration %d is declared twice", goMigrations[i].Version)
+		}
+		versions[goMigrations[i].Version] = &goMigrations[i]
+	}
+
+	migrations := make([]Migration, 0, len(versions))
+	for _, migration := range versions {
+		if migration.Up == nil {
+			return nil, fmt.Errorf("migration %d_%s has no up migration", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// run the sql file, the statements are sent in a single query
+func sqlMigration(query string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		return tx.Exec(query).Error
+	}
+}
+
+// run fn on a single connection holding the migration lock
+func withMigrationLock(db *gorm.DB, fn func(conn *gorm.DB) error) error {
+
+	return db.Connection(func(conn *gorm.DB) error {
+
+		// the other instances wait until the migrations are done
+		if err := conn.Exec("SELECT pg_advisory_lock(?)", migrationLock).Error; err != nil {
+			return err
+		}
+		defer conn.Exec("SELECT pg_advisory_unlock(?)", migrationLock)
+
+		if err := conn.AutoMigrate(&SchemaMigration{}); err != nil {
+			return err
+		}
+
+		return fn(conn)
+	})
+}
+
+// versions of the applied migrations
+func appliedMigrations(conn *gorm.DB) (map[int64]SchemaMigration, error) {
+
+	var rows []SchemaMigration
+	if err := conn.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	applied := map[int64]SchemaMigration{}
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+
+	return applied, nil
+}
+
+// apply the pending migrations, up to version if it isn't 0
+func MigrateUp(db *gorm.DB, version int64, out io.Writer) error {
+
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	return withMigrationLock(db, func(conn *gorm.DB) error {
+
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range migrations {
+			if version != 0 && migration.Version > version {
+				break
+			}
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			// the migration and its version are saved together
+			if err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := migration.Up(tx); err != nil {
+					return err
+				}
+				return tx.Create(&SchemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+			}); err != nil {
+				return fmt.Errorf("migration %d_%s: %v", migration.Version, migration.Name, err)
+			}
+
+			fmt.Fprintf(out, "applied %d_%s\n", migration.Version, migration.Name)
+		}
+
+		return nil
+	})
+}
+
+// roll back the last steps applied migrations
+func MigrateDown(db *gorm.DB, steps int, out io.Writer) error {
+
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	return withMigrationLock(db, func(conn *gorm.DB) error {
+
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			migration := migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == nil {
+				return fmt.Errorf("migration %d_%s can't be rolled back", migration.Version, migration.Name)
+			}
+
+			if err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := migration.Down(tx); err != nil {
+					return err
+				}
+				return tx.Delete(&SchemaMigration{}, migration.Version).Error
+			}); err != nil {
+				return fmt.Errorf("migration %d_%s: %v", migration.Version, migration.Name, err)
+			}
+
+			fmt.Fprintf(out, "rolled back %d_%s\n", migration.Version, migration.Name)
+			steps--
+		}
+
+		return nil
+	})
+}
+
+// state of every migration
+func GetMigrationStatus(db *gorm.DB) (status []MigrationStatus, err error) {
+
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	err = withMigrationLock(db, func(conn *gorm.DB) error {
+
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range migrations {
+			row := MigrationStatus{Version: migration.Version, Name: migration.Name}
+			if schema_migration, ok := applied[migration.Version]; ok {
+				row.AppliedAt = &schema_migration.AppliedAt
+			}
+			status = append(status, row)
+		}
+
+		return nil
+	})
+
+	return status, err
+}
+
+// create the up and down files of a new sql migration in dir
+func CreateMigration(dir, name string) (up, down string, err error) {
+
+	name = strings.Trim(regexp.MustCompile(`\W+`).ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" {
+		return "", "", fmt.Errorf("invalid migration name")
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return "", "", err
+	}
+
+	// next version after the last sql or go migration
+	var last int64
+	for _, file := range files {
+		if match := migrationName.FindStringSubmatch(file.Name()); match != nil {
+			if version, _ := strconv.ParseInt(match[1], 10, 64); version > last {
+				last = version
+			}
+		}
+	}
+	for _, migration := range goMigrations {
+		if migration.Version > last {
+			last = migration.Version
+		}
+	}
+
+	prefix := fmt.Sprintf("%04d_%s", last+1, name)
+	up = filepath.Join(dir, prefix+".up.sql")
+	down = filepath.Join(dir, prefix+".down.sql")
+
+	if err := os.WriteFile(up, []byte("-- "+name+"\n"), 0644); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(down, []byte("-- revert "+name+"\n"), 0644); err != nil {
+		return "", "", err
+	}
+
+	return up, down, nil
+}
+
+// run the migrate subcommand: up [version], down [steps], status, create <name>
+func RunMigrateCommand(db *gorm.DB, args []string, out io.Writer) error {
+
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up [version] | down [steps] | status | create <name>")
+	}
+
+	switch args[0] {
+	case "up":
+		var version int64
+		if len(args) > 1 {
+			parsed, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid version: %s", args[1])
+			}
+			version = parsed
+		}
+		return MigrateUp(db, version, out)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			parsed, err := strconv.Atoi(args[1])
+			if err != nil || parsed < 1 {
+				return fmt.Errorf("invalid steps: %s", args[1])
+			}
+			steps = parsed
+		}
+		return MigrateDown(db, steps, out)
+
+	case "status":
+		status, err := GetMigrationStatus(db)
+		if err != nil {
+			return err
+		}
+		for _, row := range status {
+			applied := "pending"
+			if row.AppliedAt != nil {
+				applied = row.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(out, "%04d_%-40s %s\n", row.Version, row.Name, applied)
+		}
+		return nil
+
+	case "create":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: migrate create <name>")
+		}
+		up, down, err := CreateMigration(MigrationsDir, strings.Join(args[1:], "_"))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "created", up)
+		fmt.Fprintln(out, "created", down)
+		return nil
+
+	default:
+		return fmt.Errorf("unknown migrate command: %s", args[0])
+	}
+}
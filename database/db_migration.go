@@ -2,38 +2,20 @@ package database
 
 import (
 	"fmt"
-	"os"
+	"log"
 	"strconv"
 
 	"github.com/casbin/casbin/v2"
-	"github.com/ezzddinne/api/app/permission"
 	"github.com/ezzddinne/api/app/role"
 	"github.com/ezzddinne/api/squad"
 	"github.com/ezzddinne/api/user"
+	"github.com/ezzddinne/config"
+	"github.com/ezzddinne/middleware"
 	"gorm.io/gorm"
 )
 
-// auto migrate dtables
-func _auto_migrate_tables(db *gorm.DB) {
-
-	// auto migrate the casbin table
-	if err := db.Table("casbin_rule").AutoMigrate(&permission.CasbinRule{}); err != nil {
-		panic(fmt.Sprintf("Error while creating the casbin table : %v", err))
-	}
-
-	// auto migrate user, role & squad tables
-	if err := db.AutoMigrate(
-		&role.Role{},
-		&squad.Squad{},
-		&user.User{},
-	); err != nil {
-		panic(err)
-	}
-
-}
-
 // auto create root user
-func _create_root_user(db *gorm.DB, enforcer *casbin.Enforcer) {
+func _create_root_user(db *gorm.DB, enforcer *casbin.SyncedEnforcer, cfg *config.Config) {
 
 	//init vars
 	//root
@@ -46,10 +28,10 @@ func _create_root_user(db *gorm.DB, enforcer *casbin.Enforcer) {
 
 	//create root role ==> root
 	//check root role exists
-	if check := db.Where("name = ?", os.Getenv("DEFAULT_ROOT")).Find(&root_role); check.RowsAffected == 0 && check.Error == nil {
+	if check := db.Where("name = ?", cfg.RBAC.DefaultRoot).Find(&root_role); check.RowsAffected == 0 && check.Error == nil {
 
 		//create root role
-		db_role := role.Role{Name: os.Getenv("DEFAULT_ROOT")}
+		db_role := role.Role{Name: cfg.RBAC.DefaultRoot}
 
 		if err := db.Create(&db_role).Error; err != nil {
 			panic(fmt.Sprintf("[WARNING] error while creating the root role: %v", err))
@@ -58,14 +40,10 @@ func _create_root_user(db *gorm.DB, enforcer *casbin.Enforcer) {
 
 	//create root user ==> Leader
 	//check root user exists
-	if check := db.Where("email = ?", os.Getenv("DEFAULT_EMAIL")).Find(&root_user); check.RowsAffected == 0 && check.Error == nil {
-
-		//convert the paiment status to bool
-		defaultPaymentStatus := os.Getenv("DEFAULT_PAIMENT_STATUS")
-		paymentStatus := defaultPaymentStatus == "true"
+	if check := db.Where("email = ?", cfg.Root.Email).Find(&root_user); check.RowsAffected == 0 && check.Error == nil {
 
 		//create the root user
-		db_user := user.User{FirstName: os.Getenv("DEFAULT_FIRSTNAME"), LastName: os.Getenv("DEFAULT_LASTNAME"), Email: os.Getenv("DEFAULT_EMAIL"), University: os.Getenv("DEFAULT_UNIVERSITY"), Password: os.Getenv("DEFAULT_USER_PASSWORD"), Phone: os.Getenv("DEFAULT_PHONE"), Paiment_Status: paymentStatus, Role: os.Getenv("DEFAULT_ROOT")}
+		db_user := user.User{FirstName: cfg.Root.FirstName, LastName: cfg.Root.LastName, Email: cfg.Root.Email, University: cfg.Root.University, Password: cfg.Root.Password, Phone: cfg.Root.Phone, Paiment_Status: cfg.Root.PaimentStatus, Role: cfg.RBAC.DefaultRoot}
 		user.HashPassword(&db_user.Password)
 
 		if err := db.Create(&db_user).Error; err != nil {
@@ -82,13 +60,13 @@ func _create_root_user(db *gorm.DB, enforcer *casbin.Enforcer) {
 	}
 
 	// add policy
-	enforcer.AddGroupingPolicy(strconv.FormatUint(uint64(user_id), 10), os.Getenv("DEFAULT_ROOT"))
+	enforcer.AddGroupingPolicy(strconv.FormatUint(uint64(user_id), 10), cfg.RBAC.DefaultRoot, middleware.AllDomains)
 
 	// create default user ==> member
-	if check := db.Where("name = ?", os.Getenv("DEFAULT_USER")).Find(&user_role); check.RowsAffected == 0 && check.Error == nil {
+	if check := db.Where("name = ?", cfg.RBAC.DefaultUser).Find(&user_role); check.RowsAffected == 0 && check.Error == nil {
 
 		// create role user
-		db_role := role.Role{Name: os.Getenv("DEFAULT_USER")}
+		db_role := role.Role{Name: cfg.RBAC.DefaultUser}
 
 		if err := db.Create(&db_role).Error; err != nil {
 			panic(fmt.Sprintf("[WARNING] error while creating the user role: %v", err))
@@ -96,18 +74,18 @@ func _create_root_user(db *gorm.DB, enforcer *casbin.Enforcer) {
 	}
 
 	// add policy
-	enforcer.AddGroupingPolicy(strconv.FormatUint(uint64(0), 10), os.Getenv("DEFAULT_USER"))
+	enforcer.AddGroupingPolicy(strconv.FormatUint(uint64(0), 10), cfg.RBAC.DefaultUser, cfg.RBAC.DefaultDomain)
 
 	// create squad
 	//check squad exists
-	if check := db.Where("name = ?", os.Getenv("DEFAULT_SQUAD_NAME")).Find(&root_squad); check.RowsAffected == 0 && check.Error == nil {
+	if check := db.Where("name = ?", cfg.Root.SquadName).Find(&root_squad); check.RowsAffected == 0 && check.Error == nil {
 
 		//init vars
 		var intIDs []int32
 		intIDs = append(intIDs, int32(user_id))
 
 		//create sqaud
-		db_squad := &squad.Squad{Name: os.Getenv("DEFAULT_SQUAD_NAME"), CreatedBy: user_id, SquadMembers: intIDs}
+		db_squad := &squad.Squad{Name: cfg.Root.SquadName, CreatedBy: user_id, SquadMembers: intIDs, Slug: squad.UniqueSlug(db, cfg.Root.SquadName, 0)}
 
 		err := db.Create(&db_squad).Error
 		if err != nil {
@@ -115,7 +93,7 @@ func _create_root_user(db *gorm.DB, enforcer *casbin.Enforcer) {
 		}
 
 		//edit user to add squad id
-		if check := db.Where("email = ?", os.Getenv("DEFAULT_EMAIL")).Find(&root_user); check.RowsAffected == 1 && check.Error == nil {
+		if check := db.Where("email = ?", cfg.Root.Email).Find(&root_user); check.RowsAffected == 1 && check.Error == nil {
 			root_user.SquadID = db_squad.ID
 			if update := db.Where("id = ?", root_user.ID).Updates(&root_user); update.Error != nil {
 				panic(fmt.Sprintf("[WARNING] error while updating the root user: %v", update.Error))
@@ -125,11 +103,114 @@ func _create_root_user(db *gorm.DB, enforcer *casbin.Enforcer) {
 	}
 }
 
-func AutoMigrateDatabase(db *gorm.DB, enforcer *casbin.Enforcer) {
+// default policies granted on the squad resources owned by the user, in every event
+func _create_default_policies(enforcer *casbin.SyncedEnforcer) {
+
+	policies := [][]string{
+		{"leader", "squads" + middleware.OwnScope, "read"},
+		{"leader", "squads" + middleware.OwnScope, "write"},
+		{"leader", "squads" + middleware.OwnScope, "delete"},
+		{"leader", "users" + middleware.OwnScope, "read"},
+		{"member", "squads" + middleware.OwnScope, "read"},
+		{"member", "users" + middleware.OwnScope, "read"},
+		{"leader", "cvs" + middleware.OwnScope, "read"},
+		{"leader", "cvs" + middleware.OwnScope, "write"},
+		{"leader", "cvs" + middleware.OwnScope, "delete"},
+		{"member", "cvs" + middleware.OwnScope, "read"},
+		{"member", "cvs" + middleware.OwnScope, "write"},
+		{"member", "cvs" + middleware.OwnScope, "delete"},
+		{"leader", "answers" + middleware.OwnScope, "read"},
+		{"leader", "answers" + middleware.OwnScope, "write"},
+		{"member", "answers" + middleware.OwnScope, "read"},
+		{"member", "answers" + middleware.OwnScope, "write"},
+		{"leader", "pool" + middleware.OwnScope, "read"},
+		{"leader", "pool" + middleware.OwnScope, "write"},
+		{"member", "pool" + middleware.OwnScope, "read"},
+		{"member", "pool" + middleware.OwnScope, "write"},
+	}
+
+	for _, policy := range policies {
+		if _, err := enforcer.AddPolicy(policy[0], middleware.AllDomains, policy[1], policy[2]); err != nil {
+			panic(fmt.Sprintf("[WARNING] error while adding the default policies: %v", err))
+		}
+	}
+}
+
+// add the casbin grouping of every user from its role column
+func _sync_user_roles(db *gorm.DB, enforcer *casbin.SyncedEnforcer, cfg *config.Config) {
+
+	//get all users
+	users, err := user.GetAllUsers(db)
+	if err != nil {
+		panic(fmt.Sprintf("[WARNING] error while getting the users: %v", err))
+	}
+
+	for _, db_user := range users {
+		if db_user.Role == "" {
+			continue
+		}
+
+		// users keep the roles already assigned through the api
+		subject := strconv.FormatUint(uint64(db_user.ID), 10)
+		if roles := enforcer.GetFilteredGroupingPolicy(0, subject); len(roles) > 0 {
+			continue
+		}
+
+		if _, err := enforcer.AddGroupingPolicy(subject, db_user.Role, cfg.RBAC.DefaultDomain); err != nil {
+			panic(fmt.Sprintf("[WARNING] error while adding the user role: %v", err))
+		}
+	}
+}
+
+func AutoMigrateDatabase(db *gorm.DB, enforcer *casbin.SyncedEnforcer, cfg *config.Config) {
 
 	// create tables
-	_auto_migrate_tables(db)
+	if err := MigrateUp(db, 0, log.Writer()); err != nil {
+		panic(fmt.Sprintf("[WARNING] error while migrating the database: %v", err))
+	}
+
+	// the enforcer was loaded before the policies were migrated
+	if err := enforcer.LoadPolicy(); err != nil {
+		panic(fmt.Sprintf("[WARNING] error while reloading the policies: %v", err))
+	}
+
+	// create root user & default policies
+	SeedDatabase(db, enforcer, cfg)
+}
+
+// create the root user, the default policies and the user roles
+func SeedDatabase(db *gorm.DB, enforcer *casbin.SyncedEnforcer, cfg *config.Config) {
 
 	//create root
-	_create_root_user(db, enforcer)
+	_create_root_user(db, enforcer, cfg)
+
+	// create default policies
+	_create_default_policies(enforcer)
+
+	// sync user roles
+	_sync_user_roles(db, enforcer, cfg)
+}
+
+// move the policies saved before the domains were added to the default domain
+func init() {
+	RegisterMigration(Migration{Version: 13, Name: "policy_domains", Up: migratePolicyDomains})
+}
+
+// the domains come from the config set at startup, the migration can't be rolled back
+func migratePolicyDomains(tx *gorm.DB) error {
+
+	cfg := middleware.Config()
+
+	// p, role, object, action ==> p, role, domain, object, action
+	if err := tx.Exec("UPDATE casbin_rule SET v3 = v2, v2 = v1, v1 = ? WHERE ptype = 'p' AND v2 <> '' AND (v3 = '' OR v3 IS NULL)", middleware.DefaultDomain()).Error; err != nil {
+		return err
+	}
+
+	// the root role applies to every event
+	if err := tx.Exec("UPDATE casbin_rule SET v2 = ? WHERE ptype = 'g' AND v1 = ? AND (v2 = '' OR v2 IS NULL)", middleware.AllDomains, cfg.RBAC.DefaultRoot).Error; err != nil {
+		return err
+	}
+
+	// g, user, role ==> g, user, role, domain
+	return tx.Exec("UPDATE casbin_rule SET v2 = ? WHERE ptype = 'g' AND (v2 = '' OR v2 IS NULL)", middleware.DefaultDomain()).Error
 }
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/ezzddinne/api/app/permission"
+	"gorm.io/gorm"
+)
+
+// apply the policy file, the changes are printed to out before being applied
+func SyncPolicyFile(db *gorm.DB, enforcer *casbin.SyncedEnforcer, path string, prune, dry_run bool, out io.Writer) error {
+
+	file, err := permission.LoadPolicyFile(path)
+	if err != nil {
+		return err
+	}
+
+	diff, err := permission.DiffPolicies(db, enforcer, file, prune)
+	if err != nil {
+		return err
+	}
+
+	if diff.Empty() {
+		fmt.Fprintln(out, "policies are up to date with", path)
+		return nil
+	}
+
+	for _, line := range diff.Lines() {
+		fmt.Fprintln(out, line)
+	}
+
+	if err := permission.ValidatePolicies(enforcer, diff); err != nil {
+		return err
+	}
+
+	if dry_run {
+		return nil
+	}
+
+	return permission.ApplyPolicies(db, enforcer, diff)
+}
+
+// export the policies to path, "-" writes to stdout
+func ExportPolicyFile(db *gorm.DB, enforcer *casbin.SyncedEnforcer, path string) error {
+
+	file, err := permission.ExportPolicies(db, enforcer)
+	if err != nil {
+		return err
+	}
+
+	if path == "-" {
+		return permission.WritePolicyFile(os.Stdout, file, permission.FormatYAML)
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	return permission.WritePolicyFile(out, file, permission.FormatOf(path))
+}
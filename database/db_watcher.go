@@ -0,0 +1,215 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// postgres channel used to sync the policies between instances
+const policyChannel = "casbin_policy"
+
+// notify payloads are limited to 8000 bytes by postgres
+const maxPayload = 7900
+
+// policy change sent to the other instances
+type policyMessage struct {
+	Instance    string     `json:"instance"`
+	Op          string     `json:"op"`
+	Sec         string     `json:"sec,omitempty"`
+	Ptype       string     `json:"ptype,omitempty"`
+	Rules       [][]string `json:"rules,omitempty"`
+	NewRules    [][]string `json:"new_rules,omitempty"`
+	FieldIndex  int        `json:"field_index,omitempty"`
+	FieldValues []string   `json:"field_values,omitempty"`
+}
+
+// casbin watcher based on postgres LISTEN/NOTIFY
+type PolicyWatcher struct {
+	db       *gorm.DB
+	listener *pq.Listener
+	instance string
+
+	// the notifications wait in the listener until the callback is set
+	mu       sync.Mutex
+	callback func(string)
+	started  sync.Once
+}
+
+// create the watcher and listen to the policy channel
+func NewPolicyWatcher(db *gorm.DB, url string) (*PolicyWatcher, error) {
+
+	watcher := &PolicyWatcher{db: db, instance: uuid.New().String()}
+
+	watcher.listener = pq.NewListener(url, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Println("[WARNING] policy watcher:", err)
+		}
+	})
+
+	if err := watcher.listener.Listen(policyChannel); err != nil {
+		watcher.listener.Close()
+		return nil, err
+	}
+
+	return watcher, nil
+}
+
+// apply the changes of the other instances to the enforcer
+func WatchPolicies(enforcer *casbin.SyncedEnforcer, watcher *PolicyWatcher) error {
+
+	if err := enforcer.SetWatcher(watcher); err != nil {
+		return err
+	}
+
+	// replace the default callback, it reloads without locking the synced enforcer
+	return watcher.SetUpdateCallback(func(payload string) {
+		if err := applyPolicyMessage(enforcer, watcher.instance, payload); err != nil {
+			log.Println("[WARNING] policy watcher:", err)
+		}
+	})
+}
+
+// set the callback, the notifications are received once it is set
+func (w *PolicyWatcher) SetUpdateCallback(callback func(string)) error {
+
+	w.mu.Lock()
+	w.callback = callback
+	w.mu.Unlock()
+
+	w.started.Do(func() { go w.listen() })
+	return nil
+}
+
+func (w *PolicyWatcher) Update() error {
+	return w.publish(policyMessage{Op: "reload"})
+}
+
+func (w *PolicyWatcher) Close() {
+	w.listener.Close()
+}
+
+func (w *PolicyWatcher) UpdateForAddPolicy(sec, ptype string, params ...string) error {
+	return w.publish(policyMessage{Op: "add", Sec: sec, Ptype: ptype, Rules: [][]string{params}})
+}
+
+func (w *PolicyWatcher) UpdateForRemovePolicy(sec, ptype string, params ...string) error {
+	return w.publish(policyMessage{Op: "remove", Sec: sec, Ptype: ptype, Rules: [][]string{params}})
+}
+
+func (w *PolicyWatcher) UpdateForRemoveFilteredPolicy(sec, ptype string, fieldIndex int, fieldValues ...string) error {
+	return w.publish(policyMessage{Op: "remove_filtered", Sec: sec, Ptype: ptype, FieldIndex: fieldIndex, FieldValues: fieldValues})
+}
+
+func (w *PolicyWatcher) UpdateForSavePolicy(model model.Model) error {
+	return w.Update()
+}
+
+func (w *PolicyWatcher) UpdateForAddPolicies(sec string, ptype string, rules ...[]string) error {
+	return w.publish(policyMessage{Op: "add", Sec: sec, Ptype: ptype, Rules: rules})
+}
+
+func (w *PolicyWatcher) UpdateForRemovePolicies(sec string, ptype string, rules ...[]string) error {
+	return w.publish(policyMessage{Op: "remove", Sec: sec, Ptype: ptype, Rules: rules})
+}
+
+func (w *PolicyWatcher) UpdateForUpdatePolicy(sec string, ptype string, oldRule, newRule []string) error {
+	return w.publish(policyMessage{Op: "update", Sec: sec, Ptype: ptype, Rules: [][]string{oldRule}, NewRules: [][]string{newRule}})
+}
+
+func (w *PolicyWatcher) UpdateForUpdatePolicies(sec string, ptype string, oldRules, newRules [][]string) error {
+	return w.publish(policyMessage{Op: "update", Sec: sec, Ptype: ptype, Rules: oldRules, NewRules: newRules})
+}
+
+// send the change to the other instances
+func (w *PolicyWatcher) publish(message policyMessage) error {
+
+	message.Instance = w.instance
+
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	// too big for a notification, ask for a full reload
+	if len(payload) > maxPayload {
+		payload, _ = json.Marshal(policyMessage{Instance: w.instance, Op: "reload"})
+	}
+
+	return w.db.Exec("SELECT pg_notify(?, ?)", policyChannel, string(payload)).Error
+}
+
+// receive the notifications
+func (w *PolicyWatcher) listen() {
+
+	for notification := range w.listener.Notify {
+
+		w.mu.Lock()
+		callback := w.callback
+		w.mu.Unlock()
+
+		if callback == nil {
+			continue
+		}
+
+		// the connection was lost, changes could be missed
+		if notification == nil {
+			callback(`{"op":"reload"}`)
+			continue
+		}
+
+		callback(notification.Extra)
+	}
+}
+
+// apply the change to the in memory policies
+func applyPolicyMessage(enforcer *casbin.SyncedEnforcer, instance, payload string) error {
+
+	//init vars
+	var message policyMessage
+
+	if err := json.Unmarshal([]byte(payload), &message); err != nil {
+		return enforcer.LoadPolicy()
+	}
+
+	// sent by this instance, already applied
+	if message.Instance == instance {
+		return nil
+	}
+
+	if message.Op == "reload" {
+		return enforcer.LoadPolicy()
+	}
+
+	// the rules are already saved by the sender, only update the memory
+	lock := enforcer.GetLock()
+	lock.Lock()
+	defer lock.Unlock()
+
+	enforcer.Enforcer.EnableAutoSave(false)
+	defer enforcer.Enforcer.EnableAutoSave(true)
+
+	var err error
+	switch message.Op {
+	case "add":
+		_, err = enforcer.Enforcer.SelfAddPoliciesEx(message.Sec, message.Ptype, message.Rules)
+	case "remove":
+		_, err = enforcer.Enforcer.SelfRemovePolicies(message.Sec, message.Ptype, message.Rules)
+	case "remove_filtered":
+		_, err = enforcer.Enforcer.SelfRemoveFilteredPolicy(message.Sec, message.Ptype, message.FieldIndex, message.FieldValues...)
+	case "update":
+		_, err = enforcer.Enforcer.SelfUpdatePolicies(message.Sec, message.Ptype, message.Rules, message.NewRules)
+	default:
+		err = fmt.Errorf("unknown policy operation: %s", message.Op)
+	}
+
+	return err
+}
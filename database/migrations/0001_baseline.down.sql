@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS audit_events;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS user_sessions;
+DROP TABLE IF EXISTS login_events;
+DROP TABLE IF EXISTS squads;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS roles;
+DROP TABLE IF EXISTS casbin_rule;
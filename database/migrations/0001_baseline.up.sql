@@ -0,0 +1,122 @@
+-- baseline: schema created by the gorm auto migration before the versioned migrations
+-- tables are created only if missing so the existing databases can be migrated
+
+CREATE TABLE IF NOT EXISTS casbin_rule (
+    id bigserial PRIMARY KEY,
+    ptype varchar(100),
+    v0 varchar(100),
+    v1 varchar(100),
+    v2 varchar(100),
+    v3 varchar(100),
+    v4 varchar(100),
+    v5 varchar(100)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_casbin_rule ON casbin_rule (ptype, v0, v1, v2, v3, v4, v5);
+
+CREATE TABLE IF NOT EXISTS roles (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL UNIQUE,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_roles_deleted_at ON roles (deleted_at);
+
+CREATE TABLE IF NOT EXISTS users (
+    id bigserial PRIMARY KEY,
+    firstname text NOT NULL,
+    lastname text NOT NULL,
+    email text NOT NULL,
+    verif_code text NOT NULL,
+    attempts bigint,
+    verif_status boolean DEFAULT false,
+    birth_date text NOT NULL,
+    university text NOT NULL,
+    phone text NOT NULL,
+    password text NOT NULL,
+    paiment_status boolean NOT NULL,
+    paiment_date text,
+    last_login text,
+    role text NOT NULL,
+    squad_id bigint,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
+
+CREATE TABLE IF NOT EXISTS squads (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL UNIQUE,
+    created_by bigint NOT NULL,
+    squad_members integer[],
+    logo_url text NOT NULL,
+    cv_urls varchar[],
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    CONSTRAINT fk_squads_leader_id FOREIGN KEY (created_by) REFERENCES users (id)
+);
+CREATE INDEX IF NOT EXISTS idx_squads_deleted_at ON squads (deleted_at);
+
+CREATE TABLE IF NOT EXISTS login_events (
+    id bigserial PRIMARY KEY,
+    user_id bigint,
+    email text,
+    success boolean NOT NULL,
+    reason text,
+    ip text,
+    user_agent text,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_login_events_user_id ON login_events (user_id);
+CREATE INDEX IF NOT EXISTS idx_login_events_created_at ON login_events (created_at);
+
+CREATE TABLE IF NOT EXISTS user_sessions (
+    id text PRIMARY KEY,
+    user_id bigint NOT NULL,
+    ip text,
+    user_agent text,
+    last_seen_at timestamptz,
+    expires_at timestamptz NOT NULL,
+    revoked_at timestamptz,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_user_sessions_user_id ON user_sessions (user_id);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL,
+    prefix text NOT NULL UNIQUE,
+    hash text NOT NULL UNIQUE,
+    subject text NOT NULL,
+    role text NOT NULL,
+    domain text NOT NULL DEFAULT '*',
+    expires_at timestamptz NOT NULL,
+    last_used_at timestamptz,
+    created_by bigint,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_api_keys_deleted_at ON api_keys (deleted_at);
+
+CREATE TABLE IF NOT EXISTS audit_events (
+    id bigserial PRIMARY KEY,
+    actor_id bigint,
+    actor_subject text,
+    on_behalf_of bigint,
+    action text NOT NULL,
+    target text,
+    before jsonb,
+    after jsonb,
+    status bigint,
+    ip text,
+    request_id text,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor_id ON audit_events (actor_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_on_behalf_of ON audit_events (on_behalf_of);
+CREATE INDEX IF NOT EXISTS idx_audit_events_target ON audit_events (target);
+CREATE INDEX IF NOT EXISTS idx_audit_events_request_id ON audit_events (request_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events (created_at);
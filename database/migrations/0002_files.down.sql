@@ -0,0 +1,3 @@
+-- revert files
+
+DROP TABLE IF EXISTS files;
@@ -0,0 +1,21 @@
+-- files: stored logos and cvs with their storage key, type, size and checksum
+
+CREATE TABLE IF NOT EXISTS files (
+    id bigserial PRIMARY KEY,
+    key text NOT NULL UNIQUE,
+    backend text NOT NULL,
+    url text NOT NULL,
+    kind text NOT NULL,
+    name text,
+    content_type text NOT NULL,
+    size bigint NOT NULL,
+    checksum text NOT NULL,
+    squad_id bigint,
+    uploaded_by bigint,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_files_squad_id ON files (squad_id);
+CREATE INDEX IF NOT EXISTS idx_files_uploaded_by ON files (uploaded_by);
+CREATE INDEX IF NOT EXISTS idx_files_deleted_at ON files (deleted_at);
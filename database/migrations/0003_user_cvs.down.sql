@@ -0,0 +1,4 @@
+-- revert user cvs
+
+DROP INDEX IF EXISTS idx_users_cv_file_id;
+ALTER TABLE users DROP COLUMN IF EXISTS cv_file_id;
@@ -0,0 +1,4 @@
+-- user cvs: the cv of every member is a file record
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS cv_file_id bigint REFERENCES files (id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_users_cv_file_id ON users (cv_file_id);
@@ -0,0 +1,3 @@
+-- revert file accesses
+
+DROP TABLE IF EXISTS file_accesses;
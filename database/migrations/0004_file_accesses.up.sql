@@ -0,0 +1,14 @@
+-- file accesses: signed urls handed out and downloads of the private files
+
+CREATE TABLE IF NOT EXISTS file_accesses (
+    id bigserial PRIMARY KEY,
+    file_id bigint NOT NULL,
+    user_id bigint,
+    action text NOT NULL,
+    ip text,
+    user_agent text,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_file_accesses_file_id ON file_accesses (file_id);
+CREATE INDEX IF NOT EXISTS idx_file_accesses_user_id ON file_accesses (user_id);
+CREATE INDEX IF NOT EXISTS idx_file_accesses_created_at ON file_accesses (created_at);
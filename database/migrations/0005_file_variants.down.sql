@@ -0,0 +1,5 @@
+-- revert file variants
+
+DROP INDEX IF EXISTS idx_files_parent_id;
+ALTER TABLE files DROP COLUMN IF EXISTS parent_id;
+ALTER TABLE files DROP COLUMN IF EXISTS variant;
@@ -0,0 +1,5 @@
+-- file variants: resized logos linked to the main file
+
+ALTER TABLE files ADD COLUMN IF NOT EXISTS variant text;
+ALTER TABLE files ADD COLUMN IF NOT EXISTS parent_id bigint REFERENCES files (id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS idx_files_parent_id ON files (parent_id);
@@ -0,0 +1,6 @@
+-- revert file scans
+
+DROP INDEX IF EXISTS idx_files_scan_status;
+ALTER TABLE files DROP COLUMN IF EXISTS scanned_at;
+ALTER TABLE files DROP COLUMN IF EXISTS scan_result;
+ALTER TABLE files DROP COLUMN IF EXISTS scan_status;
@@ -0,0 +1,7 @@
+-- file scans: the files are in quarantine until they are scanned clean
+-- the files uploaded before are scanned by the scan worker
+
+ALTER TABLE files ADD COLUMN IF NOT EXISTS scan_status text NOT NULL DEFAULT 'pending';
+ALTER TABLE files ADD COLUMN IF NOT EXISTS scan_result text;
+ALTER TABLE files ADD COLUMN IF NOT EXISTS scanned_at timestamptz;
+CREATE INDEX IF NOT EXISTS idx_files_scan_status ON files (scan_status);
@@ -0,0 +1,6 @@
+-- revert squad approval workflow
+
+DROP TABLE IF EXISTS squad_reviews;
+DROP INDEX IF EXISTS idx_squads_status;
+ALTER TABLE squads DROP COLUMN IF EXISTS submitted_at;
+ALTER TABLE squads DROP COLUMN IF EXISTS status;
@@ -0,0 +1,19 @@
+-- squad approval workflow: draft, submitted, under_review, accepted, waitlisted, rejected
+-- the squads created before were accepted implicitly, the new ones start as draft
+
+ALTER TABLE squads ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'accepted';
+ALTER TABLE squads ALTER COLUMN status SET DEFAULT 'draft';
+ALTER TABLE squads ADD COLUMN IF NOT EXISTS submitted_at timestamptz;
+CREATE INDEX IF NOT EXISTS idx_squads_status ON squads (status);
+
+CREATE TABLE IF NOT EXISTS squad_reviews (
+    id bigserial PRIMARY KEY,
+    squad_id bigint NOT NULL REFERENCES squads (id) ON DELETE CASCADE,
+    from_status text NOT NULL,
+    to_status text NOT NULL,
+    comment text,
+    reviewed_by bigint NOT NULL,
+    created_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_squad_reviews_squad_id ON squad_reviews (squad_id);
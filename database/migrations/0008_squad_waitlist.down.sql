@@ -0,0 +1,6 @@
+-- revert squad waitlist
+
+DROP INDEX IF EXISTS idx_squads_waitlisted_at;
+ALTER TABLE squads DROP COLUMN IF EXISTS confirm_by;
+ALTER TABLE squads DROP COLUMN IF EXISTS accepted_at;
+ALTER TABLE squads DROP COLUMN IF EXISTS waitlisted_at;
@@ -0,0 +1,7 @@
+-- squad waitlist: the waitlisted squads are promoted in order when a place frees up
+-- a promoted squad has until confirm_by to confirm, an accepted one can lose its place when unpaid
+
+ALTER TABLE squads ADD COLUMN IF NOT EXISTS waitlisted_at timestamptz;
+ALTER TABLE squads ADD COLUMN IF NOT EXISTS accepted_at timestamptz;
+ALTER TABLE squads ADD COLUMN IF NOT EXISTS confirm_by timestamptz;
+CREATE INDEX IF NOT EXISTS idx_squads_waitlisted_at ON squads (waitlisted_at) WHERE status = 'waitlisted';
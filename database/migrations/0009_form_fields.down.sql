@@ -0,0 +1,4 @@
+-- revert registration questionnaire
+
+DROP TABLE IF EXISTS form_answers;
+DROP TABLE IF EXISTS form_fields;
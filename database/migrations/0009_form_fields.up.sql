@@ -0,0 +1,34 @@
+-- registration questionnaire: the questions of every event and the answers of the users and squads
+
+CREATE TABLE IF NOT EXISTS form_fields (
+    id bigserial PRIMARY KEY,
+    domain text NOT NULL,
+    scope text NOT NULL DEFAULT 'user',
+    key text NOT NULL,
+    label text NOT NULL,
+    type text NOT NULL,
+    required boolean NOT NULL DEFAULT false,
+    options text[],
+    min numeric,
+    max numeric,
+    pattern text,
+    position bigint NOT NULL DEFAULT 0,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_form_fields_deleted_at ON form_fields (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_form_fields_domain_key ON form_fields (domain, key) WHERE deleted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS form_answers (
+    id bigserial PRIMARY KEY,
+    field_id bigint NOT NULL REFERENCES form_fields (id) ON DELETE CASCADE,
+    user_id bigint REFERENCES users (id) ON DELETE CASCADE,
+    squad_id bigint REFERENCES squads (id) ON DELETE CASCADE,
+    "values" text[],
+    created_at timestamptz,
+    updated_at timestamptz,
+    CONSTRAINT chk_form_answers_owner CHECK ((user_id IS NULL) <> (squad_id IS NULL))
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_form_answers_field_user ON form_answers (field_id, user_id) WHERE user_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_form_answers_field_squad ON form_answers (field_id, squad_id) WHERE squad_id IS NOT NULL;
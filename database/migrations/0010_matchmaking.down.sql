@@ -0,0 +1,5 @@
+-- revert looking for team pool
+
+DROP TABLE IF EXISTS match_proposals;
+DROP TABLE IF EXISTS join_requests;
+DROP TABLE IF EXISTS pool_profiles;
@@ -0,0 +1,44 @@
+-- looking for team pool: profiles of the solo users, join requests between users and squads, squads proposed by the matchmaking
+
+CREATE TABLE IF NOT EXISTS pool_profiles (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL UNIQUE REFERENCES users (id) ON DELETE CASCADE,
+    skills text[],
+    interests text[],
+    bio text,
+    active boolean NOT NULL DEFAULT true,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_pool_profiles_deleted_at ON pool_profiles (deleted_at);
+
+CREATE TABLE IF NOT EXISTS join_requests (
+    id bigserial PRIMARY KEY,
+    squad_id bigint NOT NULL REFERENCES squads (id) ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    kind text NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    message text,
+    created_by bigint NOT NULL,
+    responded_at timestamptz,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_join_requests_squad_id ON join_requests (squad_id);
+CREATE INDEX IF NOT EXISTS idx_join_requests_user_id ON join_requests (user_id);
+CREATE INDEX IF NOT EXISTS idx_join_requests_deleted_at ON join_requests (deleted_at);
+
+CREATE TABLE IF NOT EXISTS match_proposals (
+    id bigserial PRIMARY KEY,
+    members integer[],
+    accepted integer[],
+    skills text[],
+    status text NOT NULL DEFAULT 'pending',
+    squad_id bigint REFERENCES squads (id) ON DELETE SET NULL,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_match_proposals_deleted_at ON match_proposals (deleted_at);
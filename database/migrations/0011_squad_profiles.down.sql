@@ -0,0 +1,11 @@
+-- revert public profile of the squads
+
+DROP INDEX IF EXISTS idx_squads_directory;
+DROP INDEX IF EXISTS idx_squads_slug;
+ALTER TABLE squads DROP COLUMN IF EXISTS public;
+ALTER TABLE squads DROP COLUMN IF EXISTS social_links;
+ALTER TABLE squads DROP COLUMN IF EXISTS repository_url;
+ALTER TABLE squads DROP COLUMN IF EXISTS tech_stack;
+ALTER TABLE squads DROP COLUMN IF EXISTS project_idea;
+ALTER TABLE squads DROP COLUMN IF EXISTS description;
+ALTER TABLE squads DROP COLUMN IF EXISTS slug;
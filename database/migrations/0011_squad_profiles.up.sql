@@ -0,0 +1,17 @@
+-- public profile of the squads and their listing in the directory
+
+ALTER TABLE squads ADD COLUMN IF NOT EXISTS slug text;
+ALTER TABLE squads ADD COLUMN IF NOT EXISTS description text;
+ALTER TABLE squads ADD COLUMN IF NOT EXISTS project_idea text;
+ALTER TABLE squads ADD COLUMN IF NOT EXISTS tech_stack text[];
+ALTER TABLE squads ADD COLUMN IF NOT EXISTS repository_url text;
+ALTER TABLE squads ADD COLUMN IF NOT EXISTS social_links text[];
+ALTER TABLE squads ADD COLUMN IF NOT EXISTS public boolean NOT NULL DEFAULT false;
+
+-- the existing squads get the slug of their name, the id keeps it unique
+UPDATE squads
+SET slug = coalesce(nullif(trim(both '-' from regexp_replace(lower(name), '[^a-z0-9]+', '-', 'g')), ''), 'squad') || '-' || id
+WHERE slug IS NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_squads_slug ON squads (slug);
+CREATE INDEX IF NOT EXISTS idx_squads_directory ON squads (status, public);
@@ -0,0 +1,3 @@
+-- revert token signing keys
+
+DROP TABLE IF EXISTS signing_keys;
@@ -0,0 +1,7 @@
+-- token signing keys shared by every instance, the newest one signs once published
+
+CREATE TABLE IF NOT EXISTS signing_keys (
+    id text PRIMARY KEY,
+    private_key text NOT NULL,
+    created_at timestamptz NOT NULL
+);
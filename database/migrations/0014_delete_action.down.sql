@@ -0,0 +1,7 @@
+-- revert delete action
+
+DELETE FROM casbin_rule d
+WHERE d.ptype = 'p' AND d.v3 = 'delete' AND EXISTS (
+    SELECT 1 FROM casbin_rule w
+    WHERE w.ptype = 'p' AND w.v3 = 'write' AND w.v0 = d.v0 AND w.v1 = d.v1 AND w.v2 = d.v2
+);
@@ -0,0 +1,7 @@
+-- delete action: the delete routes required write before, the roles allowed to write keep deleting
+
+INSERT INTO casbin_rule (ptype, v0, v1, v2, v3, v4, v5)
+SELECT 'p', w.v0, w.v1, w.v2, 'delete', '', ''
+FROM casbin_rule w
+WHERE w.ptype = 'p' AND w.v3 = 'write'
+ON CONFLICT DO NOTHING;
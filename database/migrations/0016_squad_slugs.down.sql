@@ -0,0 +1,3 @@
+-- nothing to revert, the slugs are kept
+
+SELECT 1;
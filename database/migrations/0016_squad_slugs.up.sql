@@ -0,0 +1,5 @@
+-- slug of the squads created without one, e.g. the root squad
+
+UPDATE squads
+SET slug = coalesce(nullif(trim(both '-' from regexp_replace(lower(name), '[^a-z0-9]+', '-', 'g')), ''), 'squad') || '-' || id
+WHERE slug IS NULL OR slug = '';
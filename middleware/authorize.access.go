@@ -1,30 +1,54 @@
 package middleware
 
 import (
+	"strconv"
+
 	"github.com/casbin/casbin/v2"
 	"github.com/gin-gonic/gin"
 )
 
+// action verbs routes can require
+const (
+	ActionRead    = "read"
+	ActionWrite   = "write"
+	ActionDelete  = "delete"
+	ActionApprove = "approve"
+	ActionExport  = "export"
+)
+
+// actions allowed in the permissions, set with PERMISSION_ACTIONS="read,write,..."
+func Actions() []string {
+
+	if len(settings.RBAC.Actions) == 0 {
+		return []string{ActionRead, ActionWrite, ActionDelete, ActionApprove, ActionExport}
+	}
+
+	return append([]string{}, settings.RBAC.Actions...)
+}
+
+// check the action is part of the vocabulary
+func IsAction(act string) bool {
+	for _, action := range Actions() {
+		if action == act {
+			return true
+		}
+	}
+	return false
+}
+
 // authorize determines if current user has been authorized to take an action on an object.
-func Authorize(obj string, act string, enforcer *casbin.Enforcer) gin.HandlerFunc {
+func Authorize(obj string, act string, enforcer *casbin.SyncedEnforcer) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 
-		// Get current user/subject
-		sub, existed := ctx.Get("role_name")
+		// Get current user/subject, the user id and never the role trusted from the token
+		sub, existed := ctx.Get("subject")
 		if !existed {
 			ctx.AbortWithStatusJSON(401, gin.H{"message": "User hasn't logged in yet"})
 			return
 		}
 
-		// Load policy from Database
-		err := enforcer.LoadPolicy()
-		if err != nil {
-			ctx.AbortWithStatusJSON(500, gin.H{"message": "Failed to load policy from DB"})
-			return
-		}
-
-		// Casbin enforces policy
-		auth, err := enforcer.Enforce(sub, obj, act)
+		// Casbin enforces policy, policies are kept in memory and synced by the watcher
+		auth, err := enforcer.Enforce(sub, ResourceDomain(ctx), obj, act)
 		if err != nil {
 			ctx.AbortWithStatusJSON(500, gin.H{"message": "Error occurred when authorizing user"})
 			return
@@ -37,3 +61,8 @@ func Authorize(obj string, act string, enforcer *casbin.Enforcer) gin.HandlerFun
 		ctx.Next()
 	}
 }
+
+// casbin subject of the user
+func Subject(user_id uint) string {
+	return strconv.FormatUint(uint64(user_id), 10)
+}
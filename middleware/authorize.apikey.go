@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// header used by machine clients
+const APIKeyHeader string = "X-API-Key"
+
+// api key row used to authenticate a request
+type apiKey struct {
+	ID        uint      `gorm:"column:id"`
+	Subject   string    `gorm:"column:subject"`
+	ExpiresAt time.Time `gorm:"column:expires_at"`
+}
+
+// hash the api key, only the hash is stored
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// authorize the request with the api key and set its casbin subject
+func authorizeAPIKey(ctx *gin.Context, db *gorm.DB, key string) {
+
+	//init vars
+	var api_key apiKey
+
+	// get the key by its hash
+	check := db.Table("api_keys").Where("hash = ? AND deleted_at IS NULL", HashAPIKey(key)).Limit(1).Find(&api_key)
+	if check.Error != nil || check.RowsAffected == 0 {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"Error": "Not Valid API Key"})
+		return
+	}
+
+	// check the key expiry
+	if time.Now().After(api_key.ExpiresAt) {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"Error": "API Key expired"})
+		return
+	}
+
+	// update last used
+	db.Table("api_keys").Where("id = ?", api_key.ID).Update("last_used_at", time.Now())
+
+	ctx.Set("api_key_id", api_key.ID)
+	ctx.Set("role_name", api_key.Subject)
+	ctx.Set("subject", api_key.Subject)
+}
@@ -0,0 +1,16 @@
+package middleware
+
+import "github.com/ezzddinne/config"
+
+// config used by the middlewares, replaced at startup by Configure
+var settings = config.Default()
+
+// set the config used by the middlewares
+func Configure(cfg *config.Config) {
+	settings = cfg
+}
+
+// config used by the middlewares
+func Config() *config.Config {
+	return settings
+}
@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/util"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// domain matching every event
+const AllDomains string = "*"
+
+// header used to select the event of the request
+const DomainHeader string = "X-Event"
+
+// context key of the domain the request is authorized in
+const domainKey string = "domain"
+
+// resolve the domain of the resource targeted by the request
+type DomainResolver func(ctx *gin.Context) (string, error)
+
+// domain used when the request doesn't select one
+func DefaultDomain() string {
+	if domain := settings.RBAC.DefaultDomain; domain != "" {
+		return domain
+	}
+	return "default"
+}
+
+// domain selected by the caller: route param, header, query or the default domain
+// only for the public reads, the authorization uses ResourceDomain
+func RequestDomain(ctx *gin.Context) string {
+
+	if domain := ctx.Param("domain"); domain != "" {
+		return domain
+	}
+	if domain := ctx.GetHeader(DomainHeader); domain != "" {
+		return domain
+	}
+	if domain := ctx.Query("domain"); domain != "" {
+		return domain
+	}
+	return DefaultDomain()
+}
+
+// InDomain -> resolve the domain of the target before Authorize, the request is authorized in that domain
+// the routes without resolver are authorized in the default domain, where the squads and the users live
+func InDomain(resolve DomainResolver) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		domain, err := resolve(ctx)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.AbortWithStatusJSON(404, gin.H{"message": "resource not found"})
+			return
+		}
+		if err != nil {
+			ctx.AbortWithStatusJSON(500, gin.H{"message": "Error occurred when resolving the resource domain"})
+			return
+		}
+
+		ctx.Set(domainKey, domain)
+		ctx.Next()
+	}
+}
+
+// domain the request is authorized in
+func ResourceDomain(ctx *gin.Context) string {
+
+	if domain := ctx.GetString(domainKey); domain != "" {
+		return domain
+	}
+	return DefaultDomain()
+}
+
+// the event selected by the caller, for the routes creating or reading the resources of that event
+func SelectedDomain(ctx *gin.Context) (string, error) {
+	return RequestDomain(ctx), nil
+}
+
+// the domain of the json body, the fallback when it is missing
+// the body is kept for the handler
+func BodyDomain(fallback string) DomainResolver {
+	return func(ctx *gin.Context) (string, error) {
+
+		data, err := ctx.GetRawData()
+		if err != nil {
+			return "", err
+		}
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(data))
+
+		// an invalid body is refused by the handler
+		var body struct {
+			Domain string `json:"domain"`
+		}
+		if err := json.Unmarshal(data, &body); err != nil || body.Domain == "" {
+			return fallback, nil
+		}
+		return body.Domain, nil
+	}
+}
+
+// the domain query, the fallback when it is missing
+func QueryDomain(fallback string) DomainResolver {
+	return func(ctx *gin.Context) (string, error) {
+		return ctx.DefaultQuery("domain", fallback), nil
+	}
+}
+
+// check the subject of the request in another domain, for the handlers touching several domains
+func AuthorizedIn(ctx *gin.Context, enforcer *casbin.SyncedEnforcer, domain, obj, act string) (bool, error) {
+
+	sub, existed := ctx.Get("subject")
+	if !existed {
+		return false, nil
+	}
+	return enforcer.Enforce(sub, domain, obj, act)
+}
+
+// roles granted in the "*" domain apply to every event
+func EnableDomainMatching(enforcer *casbin.SyncedEnforcer) error {
+	enforcer.AddNamedDomainMatchingFunc("g", "KeyMatch", util.KeyMatch)
+	return enforcer.BuildRoleLinks()
+}
+
+// load the casbin model, the root role of the matcher is the configured one
+func LoadModel(path, root string) (model.Model, error) {
+
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return model.NewModelFromString(strings.ReplaceAll(string(text), `"root"`, strconv.Quote(root)))
+}
@@ -1,10 +1,8 @@
 package middleware
 
 import (
-	"fmt"
+	"log"
 	"net/http"
-	"os"
-	"strconv"
 	"strings"
 	"time"
 
@@ -14,62 +12,105 @@ import (
 )
 
 type Session struct {
-	UserID   uint
-	RoleName string
-	SquadID  uint
+	UserID    uint
+	RoleName  string
+	SquadID   uint
+	SessionID string
+	// admin acting as the user, 0 when not impersonating
+	ActorID uint
+}
+
+// session row used to check the token was not revoked
+type userSession struct {
+	ID         string    `gorm:"column:id"`
+	LastSeenAt time.Time `gorm:"column:last_seen_at"`
 }
 
 // Generate token
-func GenerateToken(id, squad uint, role string) string {
+func GenerateToken(id, squad uint, role, session_id string) (string, error) {
+
+	claims := jwt.MapClaims{
+		"exp":       time.Now().Add(settings.Token.SessionTTL()).Unix(),
+		"iat":       time.Now().Unix(),
+		"user_id":   id,
+		"role_name": role,
+		"squad_id":  squad,
+		"sid":       session_id,
+	}
+
+	return Keys.Sign(claims)
+}
 
-	duration, _ := strconv.Atoi(os.Getenv("TOKEN_DURATION"))
+// Generate a short lived token to act as the user
+// it carries the session of the admin, revoking that session ends the impersonation
+func GenerateImpersonationToken(id, squad uint, role string, actor uint, session_id string) (string, error) {
+
+	duration := settings.Token.ImpersonationTTL()
+	if duration <= 0 {
+		duration = 15 * time.Minute
+	}
 
 	claims := jwt.MapClaims{
-		"exp":       time.Now().Add(time.Hour * time.Duration(duration)).Unix(),
+		"exp":       time.Now().Add(duration).Unix(),
 		"iat":       time.Now().Unix(),
 		"user_id":   id,
 		"role_name": role,
 		"squad_id":  squad,
+		"act":       map[string]interface{}{"sub": actor},
+		"sid":       session_id,
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	auth, _ := token.SignedString([]byte(os.Getenv("TOKEN_SECRET")))
+	return Keys.Sign(claims)
+}
+
+// get the admin id from the act claim
+func actorFromClaims(claims jwt.MapClaims) uint {
 
-	return auth
+	act, ok := claims["act"].(map[string]interface{})
+	if !ok {
+		return 0
+	}
 
+	sub, _ := act["sub"].(float64)
+	return uint(sub)
 }
 
 // Extract the token value
 func extractToken(ctx *gin.Context) string {
 
-	bearerToken := strings.Fields(ctx.Request.Header["Authorization"][0])[1]
+	bearerToken := strings.Fields(ctx.GetHeader("Authorization"))
 
-	if len(bearerToken) == 0 {
+	if len(bearerToken) < 2 {
 		return ""
 	} else {
-		return bearerToken
+		return bearerToken[1]
 	}
 }
 
 // extract values from token
 func ExtractTokenValues(ctx *gin.Context) Session {
 
+	// requests made with an api key have no user
+	if _, ok := ctx.Get("api_key_id"); ok {
+		return Session{RoleName: ctx.GetString("role_name")}
+	}
+
 	//init vars
 	session := Session{}
 
 	tokenString := extractToken(ctx)
-	token, _ := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("TOKEN_SECRET")), nil
-	})
+	token, err := jwt.Parse(tokenString, Keys.Keyfunc)
+	if err != nil {
+		return Session{}
+	}
 
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
 
 		session.UserID = uint(claims["user_id"].(float64))
 		session.SquadID = uint(claims["squad_id"].(float64))
 		session.RoleName, _ = claims["role_name"].(string)
+		session.SessionID, _ = claims["sid"].(string)
+		session.ActorID = actorFromClaims(claims)
 		return session
 	}
 	return Session{}
@@ -77,36 +118,60 @@ func ExtractTokenValues(ctx *gin.Context) Session {
 
 // validate the given token
 func validateToken(token string) (*jwt.Token, error) {
-	//2nd arg function return secret key after checking if the signing method is HMAC and returned key is used by 'Parse' to decode the token)
-	return jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			//nil secret key
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("TOKEN_SECRET")), nil
-	})
+	//2nd arg function return the public key matching the kid header after checking the signing method
+	return jwt.Parse(token, Keys.Keyfunc)
 }
 
-// AuthorizeJWT -> to authorize JWT Token
-func AuthorizeJWT() gin.HandlerFunc {
+// AuthorizeJWT -> to authorize JWT Token or API Key
+func AuthorizeJWT(db *gorm.DB) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
+
+		// machine clients authenticate with an api key
+		if key := ctx.GetHeader(APIKeyHeader); key != "" {
+			authorizeAPIKey(ctx, db, key)
+			return
+		}
+
 		const BearerSchema string = "Bearer "
 		authHeader := ctx.GetHeader("Authorization")
-		if authHeader == "" {
+		if len(authHeader) <= len(BearerSchema) {
 			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"Error": "No Authorization header found"})
+			return
 		}
 		tokenString := authHeader[len(BearerSchema):]
 		if token, err := validateToken(tokenString); err != nil {
-			fmt.Println("token", tokenString, err.Error())
+			log.Println("[WARNING] invalid token:", err)
 			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"Error": "Not Valid Token"})
 		} else {
 			if claims, ok := token.Claims.(jwt.MapClaims); !ok {
 				ctx.AbortWithStatus(http.StatusUnauthorized)
 			} else {
 				if token.Valid {
+
+					// reset tokens can only be used to reset the password
+					if purpose, _ := claims["purpose"].(string); purpose != "" {
+						ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"Error": "Not Valid Token"})
+						return
+					}
+
+					// check the session was not revoked, the tokens issued without a session are refused
+					if sid, _ := claims["sid"].(string); sid == "" || !checkSession(db, sid) {
+						ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"Error": "Session revoked"})
+						return
+					}
+
 					ctx.Set("user_id", claims["user_id"])
 					ctx.Set("squad_id", claims["squad_id"])
 					ctx.Set("role_name", claims["role_name"])
+
+					// casbin subject
+					user_id, _ := claims["user_id"].(float64)
+					ctx.Set("subject", Subject(uint(user_id)))
+
+					// admin acting as the user
+					if actor := actorFromClaims(claims); actor != 0 {
+						ctx.Set("act", actor)
+					}
 				} else {
 					ctx.AbortWithStatus(http.StatusUnauthorized)
 				}
@@ -115,6 +180,36 @@ func AuthorizeJWT() gin.HandlerFunc {
 	}
 }
 
+// DenyImpersonation -> block sensitive actions while acting as another user
+func DenyImpersonation() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if _, ok := ctx.Get("act"); ok {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "This action is not allowed while impersonating"})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// check the session is still active
+func checkSession(db *gorm.DB, session_id string) bool {
+
+	//init vars
+	var session userSession
+
+	check := db.Table("user_sessions").Where("id = ? AND revoked_at IS NULL", session_id).Limit(1).Find(&session)
+	if check.Error != nil || check.RowsAffected == 0 {
+		return false
+	}
+
+	// update last seen, at most once a minute
+	if time.Since(session.LastSeenAt) > time.Minute {
+		db.Table("user_sessions").Where("id = ?", session_id).Update("last_seen_at", time.Now())
+	}
+
+	return true
+}
+
 func DeleteSession(db *gorm.DB, squad_id uint) error {
 	return db.Where("squad_id = ?").Delete(&Session{}).Error
 }
@@ -0,0 +1,404 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// key of the postgres advisory lock held while creating or rotating the keys
+const keysLock int64 = 7365930
+
+// the instances reload the keys from the database every interval
+const KeyReloadInterval = time.Minute
+
+// a new key signs once every instance had time to load it
+const keyPublishDelay = 2 * KeyReloadInterval
+
+// signing key shared by every instance
+type StoredKey struct {
+	ID         string    `gorm:"column:id;primaryKey"`
+	PrivateKey string    `gorm:"column:private_key;not null"`
+	CreatedAt  time.Time `gorm:"column:created_at;not null"`
+}
+
+func (StoredKey) TableName() string {
+	return "signing_keys"
+}
+
+// signing key loaded from the database
+type SigningKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	Private   crypto.Signer
+	CreatedAt time.Time
+}
+
+// set of active signing keys
+type KeySet struct {
+	mu     sync.RWMutex
+	db     *gorm.DB
+	alg    string
+	keys   map[string]*SigningKey
+	active *SigningKey
+}
+
+// json web key
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// json web key set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// keys used to sign and verify every token
+var Keys = &KeySet{keys: map[string]*SigningKey{}}
+
+// load the signing keys from the database, the pem files of dir are imported first
+// the first instance to start creates the first key
+func LoadKeys(db *gorm.DB, dir, alg string) error {
+
+	if alg == "" {
+		alg = jwt.SigningMethodRS256.Alg()
+	}
+	if alg != jwt.SigningMethodRS256.Alg() && alg != jwt.SigningMethodEdDSA.Alg() {
+		return fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+
+	Keys.mu.Lock()
+	Keys.db = db
+	Keys.alg = alg
+	Keys.mu.Unlock()
+
+	if err := importKeys(db, dir); err != nil {
+		return err
+	}
+
+	err := withKeysLock(db, func(tx *gorm.DB) error {
+
+		var count int64
+		if err := tx.Model(&StoredKey{}).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+
+		_, err := Keys.generate(tx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return Keys.Reload()
+}
+
+// run fn in a transaction holding the keys lock, the instances create and rotate the keys one at a time
+func withKeysLock(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", keysLock).Error; err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+// save the pem keys of the directory used before the keys were stored in the database
+func importKeys(db *gorm.DB, dir string) error {
+
+	if dir == "" {
+		return nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+
+		stored := StoredKey{ID: strings.TrimSuffix(filepath.Base(file), ".pem"), PrivateKey: string(data), CreatedAt: info.ModTime()}
+		if _, err := parseKey(stored); err != nil {
+			return err
+		}
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&stored).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// read the keys from the database, the keys removed by a rotation are dropped
+func (ks *KeySet) Reload() error {
+
+	ks.mu.RLock()
+	db := ks.db
+	ks.mu.RUnlock()
+
+	var stored []StoredKey
+	if err := db.Order("created_at").Find(&stored).Error; err != nil {
+		return err
+	}
+	if len(stored) == 0 {
+		return fmt.Errorf("no signing key in the database")
+	}
+
+	keys := map[string]*SigningKey{}
+	var loaded []*SigningKey
+	for _, record := range stored {
+		key, err := parseKey(record)
+		if err != nil {
+			return err
+		}
+		keys[key.ID] = key
+		loaded = append(loaded, key)
+	}
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.active = activeKey(loaded, time.Now())
+	ks.mu.Unlock()
+
+	return nil
+}
+
+// newest key published for keyPublishDelay, the oldest one when none is
+func activeKey(keys []*SigningKey, now time.Time) *SigningKey {
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+
+	for i := len(keys) - 1; i >= 0; i-- {
+		if !keys[i].CreatedAt.After(now.Add(-keyPublishDelay)) {
+			return keys[i]
+		}
+	}
+	return keys[0]
+}
+
+// add a new key once the newest one is older than interval
+// keys older than retention are removed, the tokens they signed have expired
+func (ks *KeySet) Rotate(interval, retention time.Duration) error {
+
+	ks.mu.RLock()
+	db := ks.db
+	ks.mu.RUnlock()
+
+	return withKeysLock(db, func(tx *gorm.DB) error {
+
+		// another instance may have rotated already
+		var newest StoredKey
+		if err := tx.Order("created_at desc").First(&newest).Error; err != nil {
+			return err
+		}
+		if time.Since(newest.CreatedAt) < interval {
+			return nil
+		}
+
+		key, err := ks.generate(tx)
+		if err != nil {
+			return err
+		}
+
+		// the previous key signs until the new one is published
+		cutoff := time.Now().Add(-retention - keyPublishDelay)
+		return tx.Where("created_at < ? AND id NOT IN ?", cutoff, []string{key.ID, newest.ID}).Delete(&StoredKey{}).Error
+	})
+}
+
+// reload the keys every KeyReloadInterval and rotate them every interval
+func StartKeyRotation(interval, retention time.Duration) {
+
+	go func() {
+		ticker := time.NewTicker(KeyReloadInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if interval > 0 {
+				if err := Keys.Rotate(interval, retention); err != nil {
+					log.Println("[WARNING] key rotation:", err)
+				}
+			}
+			if err := Keys.Reload(); err != nil {
+				log.Println("[WARNING] key reload:", err)
+			}
+		}
+	}()
+}
+
+// sign the claims with the active key
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+
+	ks.mu.RLock()
+	key := ks.active
+	ks.mu.RUnlock()
+
+	if key == nil {
+		return "", fmt.Errorf("no signing key loaded")
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.Private)
+}
+
+// return the public key matching the kid header of the token
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+
+	kid, _ := token.Header["kid"].(string)
+
+	ks.mu.RLock()
+	key, ok := ks.keys[kid]
+	ks.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown key id: %v", token.Header["kid"])
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.Private.Public(), nil
+}
+
+// public keys as a json web key set
+func (ks *KeySet) JWKS() JWKS {
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range ks.keys {
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+
+		switch public := key.Private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
+
+// serve the public keys
+func GetJWKS(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "public, max-age=300")
+	ctx.JSON(http.StatusOK, Keys.JWKS())
+}
+
+// generate a new key and save it in the database
+func (ks *KeySet) generate(tx *gorm.DB) (*SigningKey, error) {
+
+	var private crypto.Signer
+	var err error
+
+	if ks.alg == jwt.SigningMethodEdDSA.Alg() {
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	} else {
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	kid := now.UTC().Format("20060102T150405")
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	if err := tx.Create(&StoredKey{ID: kid, PrivateKey: string(data), CreatedAt: now}).Error; err != nil {
+		return nil, err
+	}
+
+	return newSigningKey(kid, private, now)
+}
+
+// parse the PKCS8 private key of a stored key
+func parseKey(stored StoredKey) (*SigningKey, error) {
+
+	block, _ := pem.Decode([]byte(stored.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("invalid pem key: %s", stored.ID)
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", stored.ID, err)
+	}
+
+	private, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type: %s", stored.ID)
+	}
+
+	return newSigningKey(stored.ID, private, stored.CreatedAt)
+}
+
+// pick the signing method matching the key type
+func newSigningKey(kid string, private crypto.Signer, created time.Time) (*SigningKey, error) {
+
+	key := &SigningKey{ID: kid, Private: private, CreatedAt: created}
+
+	switch private.(type) {
+	case *rsa.PrivateKey:
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type for %s", kid)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"github.com/casbin/casbin/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// suffix of the objects granted only on the resources owned by the subject
+const OwnScope string = ":own"
+
+// resolve if the user owns the resource targeted by the request
+type OwnerResolver func(ctx *gin.Context, user_id uint) (bool, error)
+
+// AuthorizeResource -> authorize on the object, or on the owned object when the user owns the target
+// e.g. "p, leader, squads:own, write" lets a leader write only his own squad
+func AuthorizeResource(obj string, act string, enforcer *casbin.SyncedEnforcer, owns OwnerResolver) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		// Get current user/subject
+		sub, existed := ctx.Get("subject")
+		if !existed {
+			ctx.AbortWithStatusJSON(401, gin.H{"message": "User hasn't logged in yet"})
+			return
+		}
+
+		// granted on every resource
+		auth, err := enforcer.Enforce(sub, ResourceDomain(ctx), obj, act)
+		if err != nil {
+			ctx.AbortWithStatusJSON(500, gin.H{"message": "Error occurred when authorizing user"})
+			return
+		}
+
+		// granted on the owned resources only
+		if !auth {
+			if auth, err = enforcer.Enforce(sub, ResourceDomain(ctx), obj+OwnScope, act); err != nil {
+				ctx.AbortWithStatusJSON(500, gin.H{"message": "Error occurred when authorizing user"})
+				return
+			}
+
+			if auth {
+				session := ExtractTokenValues(ctx)
+				if auth, err = owns(ctx, session.UserID); err != nil {
+					ctx.AbortWithStatusJSON(500, gin.H{"message": "Error occurred when resolving the resource owner"})
+					return
+				}
+			}
+		}
+
+		if !auth {
+			ctx.AbortWithStatusJSON(403, gin.H{"message": "You are not authorized"})
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// header carrying the request id
+const RequestIDHeader string = "X-Request-ID"
+
+// RequestID -> tag every request with an id, kept from the client if sent
+func RequestID() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		request_id := ctx.GetHeader(RequestIDHeader)
+		if request_id == "" || len(request_id) > 64 {
+			request_id = uuid.New().String()
+		}
+
+		ctx.Set("request_id", request_id)
+		ctx.Header(RequestIDHeader, request_id)
+		ctx.Next()
+	}
+}
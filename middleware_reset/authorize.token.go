@@ -1,13 +1,12 @@
 package middleware_reset
 
 import (
-	"fmt"
+	"log"
 	"net/http"
-	"os"
-	"strconv"
 	"strings"
 	"time"
 
+	"github.com/ezzddinne/middleware"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -20,34 +19,29 @@ type Session struct {
 }
 
 // Generate token
-func GenerateResetToken(id, squad uint, role string) string {
-
-	duration, _ := strconv.Atoi(os.Getenv("RESET_TOKEN_DURATION"))
+func GenerateResetToken(id, squad uint, role string) (string, error) {
 
 	claims := jwt.MapClaims{
-		"exp":       time.Now().Add(time.Minute * time.Duration(duration)).Unix(),
+		"exp":       time.Now().Add(middleware.Config().Token.ResetTTL()).Unix(),
 		"iat":       time.Now().Unix(),
 		"user_id":   id,
 		"role_name": role,
 		"squad_id":  squad,
+		"purpose":   "reset",
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	auth, _ := token.SignedString([]byte(os.Getenv("TOKEN_SECRET")))
-
-	return auth
-
+	return middleware.Keys.Sign(claims)
 }
 
 // Extract the token value
 func extractResetToken(ctx *gin.Context) string {
 
-	bearerToken := strings.Fields(ctx.Request.Header["Authorization"][0])[1]
+	bearerToken := strings.Fields(ctx.GetHeader("Authorization"))
 
-	if len(bearerToken) == 0 {
+	if len(bearerToken) < 2 {
 		return ""
 	} else {
-		return bearerToken
+		return bearerToken[1]
 	}
 }
 
@@ -58,15 +52,18 @@ func ExtractResetTokenValues(ctx *gin.Context) Session {
 	session := Session{}
 
 	tokenString := extractResetToken(ctx)
-	token, _ := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("TOKEN_SECRET")), nil
-	})
+	token, err := jwt.Parse(tokenString, middleware.Keys.Keyfunc)
+	if err != nil {
+		return Session{}
+	}
 
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
 
+		// only reset tokens can change the password, never an impersonation token
+		if purpose, _ := claims["purpose"].(string); purpose != "reset" || claims["act"] != nil {
+			return Session{}
+		}
+
 		session.UserID = uint(claims["user_id"].(float64))
 		session.SquadID = uint(claims["squad_id"].(float64))
 		session.RoleName, _ = claims["role_name"].(string)
@@ -78,14 +75,8 @@ func ExtractResetTokenValues(ctx *gin.Context) Session {
 
 // validate the given token
 func validateResetToken(token string) (*jwt.Token, error) {
-	//2nd arg function return secret key after checking if the signing method is HMAC and returned key is used by 'Parse' to decode the token)
-	return jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			//nil secret key
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("TOKEN_SECRET")), nil
-	})
+	//2nd arg function return the public key matching the kid header after checking the signing method
+	return jwt.Parse(token, middleware.Keys.Keyfunc)
 }
 
 // AuthorizeJWT -> to authorize JWT Token
@@ -93,12 +84,13 @@ func AuthorizeResetJWT() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		const BearerSchema string = "Bearer "
 		authHeader := ctx.GetHeader("Authorization")
-		if authHeader == "" {
+		if len(authHeader) <= len(BearerSchema) {
 			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"Error": "No Authorization header found"})
+			return
 		}
 		tokenString := authHeader[len(BearerSchema):]
 		if token, err := validateResetToken(tokenString); err != nil {
-			fmt.Println("token", tokenString, err.Error())
+			log.Println("[WARNING] invalid reset token:", err)
 			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"Error": "Not Valid Token"})
 		} else {
 			if claims, ok := token.Claims.(jwt.MapClaims); !ok {
@@ -0,0 +1,138 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// size of the chunks streamed to clamd, below its StreamMaxLength
+const clamdChunkSize int = 64 * 1024
+
+// client of the clamd protocol, over tcp (host:port) or a unix socket (/path)
+type clamd struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+func NewClamd(address string, timeout time.Duration) Scanner {
+
+	network := "tcp"
+	if strings.HasPrefix(address, "unix:") || strings.HasPrefix(address, "/") {
+		network, address = "unix", strings.TrimPrefix(address, "unix:")
+	}
+
+	return &clamd{network: network, address: address, timeout: timeout}
+}
+
+func (c *clamd) Name() string {
+	return "clamd"
+}
+
+// check clamd answers
+func (c *clamd) Ping(ctx context.Context) error {
+
+	reply, err := c.command(ctx, "zPING\x00", nil)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("clamd: unexpected reply %q", reply)
+	}
+
+	return nil
+}
+
+// stream the content with INSTREAM: <length><chunk>... <0>
+func (c *clamd) Scan(ctx context.Context, content io.Reader) (Result, error) {
+
+	reply, err := c.command(ctx, "zINSTREAM\x00", content)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return parseScanReply(reply)
+}
+
+// stream: OK | stream: Eicar-Signature FOUND | ... ERROR
+func parseScanReply(reply string) (Result, error) {
+
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(reply, " FOUND")}, nil
+	default:
+		return Result{}, fmt.Errorf("clamd: %s", reply)
+	}
+}
+
+// send the command, the content in chunks if any, and read the reply
+func (c *clamd) command(ctx context.Context, command string, content io.Reader) (string, error) {
+
+	dialer := net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return "", fmt.Errorf("clamd: %v", err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(c.timeout)
+	if ctx_deadline, ok := ctx.Deadline(); ok && ctx_deadline.Before(deadline) {
+		deadline = ctx_deadline
+	}
+	conn.SetDeadline(deadline)
+
+	if _, err := io.WriteString(conn, command); err != nil {
+		return "", fmt.Errorf("clamd: %v", err)
+	}
+
+	if content != nil {
+		writer := bufio.NewWriterSize(conn, clamdChunkSize+4)
+		chunk := make([]byte, clamdChunkSize)
+		size := make([]byte, 4)
+		for {
+			n, err := content.Read(chunk)
+			if n > 0 {
+				binary.BigEndian.PutUint32(size, uint32(n))
+				if _, err := writer.Write(size); err != nil {
+					return "", fmt.Errorf("clamd: %v", err)
+				}
+				if _, err := writer.Write(chunk[:n]); err != nil {
+					return "", fmt.Errorf("clamd: %v", err)
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", err
+			}
+		}
+
+		// a zero length chunk ends the stream
+		binary.BigEndian.PutUint32(size, 0)
+		if _, err := writer.Write(size); err != nil {
+			return "", fmt.Errorf("clamd: %v", err)
+		}
+		if err := writer.Flush(); err != nil {
+			return "", fmt.Errorf("clamd: %v", err)
+		}
+	}
+
+	// the z commands reply ends with a null byte
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("clamd: %v", err)
+	}
+
+	return string(bytes.TrimRight(reply, "\x00\n")), nil
+}
@@ -0,0 +1,159 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ezzddinne/config"
+)
+
+func TestParseScanReply(t *testing.T) {
+
+	tests := []struct {
+		reply     string
+		infected  bool
+		signature string
+		fails     bool
+	}{
+		{reply: "stream: OK"},
+		{reply: "stream: Eicar-Test-Signature FOUND", infected: true, signature: "Eicar-Test-Signature"},
+		{reply: "stream: Win.Test.EICAR_HDB-1 FOUND", infected: true, signature: "Win.Test.EICAR_HDB-1"},
+		{reply: "INSTREAM size limit exceeded. ERROR", fails: true},
+		{reply: "", fails: true},
+	}
+
+	for _, test := range tests {
+		result, err := parseScanReply(test.reply)
+		if (err != nil) != test.fails {
+			t.Errorf("%q: error %v, want error %v", test.reply, err, test.fails)
+			continue
+		}
+		if result.Infected != test.infected || result.Signature != test.signature {
+			t.Errorf("%q: got %+v, want infected %v signature %q", test.reply, result, test.infected, test.signature)
+		}
+	}
+}
+
+// fake clamd answering reply to one INSTREAM command, the streamed content is sent to received
+func fakeClamd(t *testing.T, reply string, received chan<- []byte) string {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		command := make([]byte, len("zINSTREAM\x00"))
+		if _, err := io.ReadFull(conn, command); err != nil || string(command) != "zINSTREAM\x00" {
+			received <- nil
+			return
+		}
+
+		var content bytes.Buffer
+		size := make([]byte, 4)
+		for {
+			if _, err := io.ReadFull(conn, size); err != nil {
+				received <- nil
+				return
+			}
+			length := binary.BigEndian.Uint32(size)
+			if length == 0 {
+				break
+			}
+			if _, err := io.CopyN(&content, conn, int64(length)); err != nil {
+				received <- nil
+				return
+			}
+		}
+
+		received <- content.Bytes()
+		io.WriteString(conn, reply+"\x00")
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestClamdScan(t *testing.T) {
+
+	tests := []struct {
+		reply    string
+		infected bool
+		fails    bool
+	}{
+		{reply: "stream: OK"},
+		{reply: "stream: Eicar-Test-Signature FOUND", infected: true},
+		{reply: "stream: INSTREAM size limit exceeded. ERROR", fails: true},
+	}
+
+	// larger than a chunk to check the content is streamed in several chunks
+	content := strings.Repeat("x", clamdChunkSize*2+10)
+
+	for _, test := range tests {
+		received := make(chan []byte, 1)
+		sc := NewClamd(fakeClamd(t, test.reply, received), time.Second)
+
+		result, err := sc.Scan(context.Background(), strings.NewReader(content))
+		if (err != nil) != test.fails {
+			t.Errorf("%q: error %v, want error %v", test.reply, err, test.fails)
+		}
+		if result.Infected != test.infected {
+			t.Errorf("%q: infected %v, want %v", test.reply, result.Infected, test.infected)
+		}
+		if got := <-received; string(got) != content {
+			t.Errorf("%q: clamd received %d bytes, want %d", test.reply, len(got), len(content))
+		}
+	}
+}
+
+func TestClamdUnreachable(t *testing.T) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	// the file stays in quarantine when clamd is down
+	if _, err := NewClamd(address, time.Second).Scan(context.Background(), strings.NewReader("content")); err == nil {
+		t.Error("scan succeeded without clamd")
+	}
+}
+
+func TestNew(t *testing.T) {
+
+	tests := []struct {
+		backend string
+		name    string
+		fails   bool
+	}{
+		{backend: "", name: "clamd"},
+		{backend: "clamd", name: "clamd"},
+		{backend: "none", name: "noop"},
+		{backend: "unknown", fails: true},
+	}
+
+	for _, test := range tests {
+		sc, err := New(config.ScannerConfig{Backend: test.backend, ClamdAddress: "localhost:3310", Timeout: 1})
+		if (err != nil) != test.fails {
+			t.Errorf("%q: error %v, want error %v", test.backend, err, test.fails)
+			continue
+		}
+		if err == nil && sc.Name() != test.name {
+			t.Errorf("%q: scanner %s, want %s", test.backend, sc.Name(), test.name)
+		}
+	}
+}
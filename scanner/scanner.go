@@ -0,0 +1,50 @@
+// malware scanning of the uploaded files
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ezzddinne/config"
+)
+
+// outcome of a scan
+type Result struct {
+	Infected  bool
+	Signature string
+}
+
+// scanner checking the uploaded content before it is served
+type Scanner interface {
+	// name saved with the scan results
+	Name() string
+
+	// scan the content, an error leaves the file in quarantine
+	Scan(ctx context.Context, content io.Reader) (Result, error)
+}
+
+// scanner selected by the config
+func New(cfg config.ScannerConfig) (Scanner, error) {
+
+	switch cfg.Backend {
+	case "clamd", "":
+		return NewClamd(cfg.ClamdAddress, time.Second*time.Duration(cfg.Timeout)), nil
+	case "none":
+		return NewNoop(), nil
+	default:
+		return nil, fmt.Errorf("unknown scanner backend: %s", cfg.Backend)
+	}
+}
+
+// scanner of a function, used by the tests to fake the results
+type Func func(ctx context.Context, content io.Reader) (Result, error)
+
+func (f Func) Name() string {
+	return "func"
+}
+
+func (f Func) Scan(ctx context.Context, content io.Reader) (Result, error) {
+	return f(ctx, content)
+}
@@ -0,0 +1,21 @@
+package scanner
+
+import (
+	"context"
+	"io"
+)
+
+// scanner accepting every file, used when no scanner is deployed
+type noop struct{}
+
+func NewNoop() Scanner {
+	return noop{}
+}
+
+func (noop) Name() string {
+	return "noop"
+}
+
+func (noop) Scan(ctx context.Context, content io.Reader) (Result, error) {
+	return Result{}, nil
+}
@@ -0,0 +1,587 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/ezzddinne/api/app/audit"
+	"github.com/ezzddinne/api/app/permission"
+	"github.com/ezzddinne/api/app/role"
+	"github.com/ezzddinne/api/form"
+	"github.com/ezzddinne/api/squad"
+	apiuser "github.com/ezzddinne/api/user"
+	"github.com/ezzddinne/config"
+	"github.com/ezzddinne/database"
+	"github.com/ezzddinne/middleware"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// output formats of the commands
+const (
+	OutputTable string = "table"
+	OutputJSON  string = "json"
+)
+
+// subcommand of the command line
+type command struct {
+	usage string
+	run   func(cfg *config.Config, args []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"serve":   {"serve [-database] [-policy-file file] [-policy-export file]", Serve},
+		"migrate": {"migrate up [version] | down [steps] | status | create <name>", RunMigrate},
+		"seed":    {"seed", runSeed},
+		"user":    {"user create | reset-password | grant-role", runUser},
+		"squad":   {"squad list [-o table|json]", runSquad},
+		"payment": {"payment mark -id <user id>", runPayment},
+		"export":  {"export users | squads | policies [-o table|json] [-format yaml|csv] [-domain event]", runExport},
+		"config":  {"config", runConfig},
+	}
+}
+
+// run the subcommand, the server is started without subcommand
+func RunCommand(cfg *config.Config, args []string) error {
+
+	if len(args) == 0 {
+		return Serve(cfg, args)
+	}
+
+	if args[0] == "help" {
+		printUsage(os.Stdout)
+		return nil
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		printUsage(os.Stderr)
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+
+	return cmd.run(cfg, args[1:])
+}
+
+// list the subcommands
+func printUsage(out io.Writer) {
+	fmt.Fprintln(out, "usage:")
+	for _, name := range []string{"serve", "migrate", "seed", "user", "squad", "payment", "export", "config"} {
+		fmt.Fprintln(out, "  "+commands[name].usage)
+	}
+}
+
+// database and enforcer used by the commands, the queries are not logged
+func openDatabase(cfg *config.Config) (*gorm.DB, *casbin.SyncedEnforcer, func(), error) {
+
+	db, err := dbConnection(cfg, logger.Warn)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// the policies changed here are sent to the running instances
+	enforcer, watcher := NewEnforcer(db, cfg)
+
+	return db, enforcer, watcher.Close, nil
+}
+
+// print the rows as a table or the value as json
+func printOutput(format string, headers []string, rows [][]string, value interface{}) error {
+
+	switch format {
+	case OutputJSON:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case OutputTable:
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, strings.Join(headers, "\t"))
+		for _, row := range rows {
+			fmt.Fprintln(writer, strings.Join(row, "\t"))
+		}
+		return writer.Flush()
+	default:
+		return fmt.Errorf("unknown output format: %s", format)
+	}
+}
+
+// save the change made from the command line in the audit log
+func auditCommand(db *gorm.DB, action, target string, before, after interface{}) {
+
+	operator := "cli"
+	if current, err := user.Current(); err == nil {
+		operator = "cli:" + current.Username
+	}
+
+	event := audit.AuditEvent{
+		ActorSubject: operator,
+		Action:       action,
+		Target:       target,
+		CreatedAt:    time.Now(),
+	}
+	if before != nil {
+		event.Before, _ = json.Marshal(before)
+	}
+	if after != nil {
+		event.After, _ = json.Marshal(after)
+	}
+
+	if err := audit.NewAuditEvent(db, event); err != nil {
+		fmt.Fprintln(os.Stderr, "[WARNING] audit:", err)
+	}
+}
+
+// find the user by id or email
+func findUser(db *gorm.DB, id uint, email string) (apiuser.User, error) {
+
+	if id != 0 {
+		return apiuser.GetUserByID(db, id)
+	}
+	if email != "" {
+		return apiuser.GetUserByEmail(db, email)
+	}
+
+	return apiuser.User{}, fmt.Errorf("-id or -email is required")
+}
+
+// random password printed once to the operator
+func randomPassword() (string, error) {
+	bytes := make([]byte, 12)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// seed the root user and the default policies
+func runSeed(cfg *config.Config, args []string) error {
+
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	flags.Parse(args)
+
+	db, enforcer, closer, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	database.SeedDatabase(db, enforcer, cfg)
+	fmt.Println("database seeded")
+
+	return nil
+}
+
+// user subcommands
+func runUser(cfg *config.Config, args []string) error {
+
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s", commands["user"].usage)
+	}
+
+	switch args[0] {
+	case "create":
+		return runUserCreate(cfg, args[1:])
+	case "reset-password":
+		return runUserResetPassword(cfg, args[1:])
+	case "grant-role":
+		return runUserGrantRole(cfg, args[1:])
+	default:
+		return fmt.Errorf("unknown user command: %s", args[0])
+	}
+}
+
+// create a verified user
+func runUserCreate(cfg *config.Config, args []string) error {
+
+	flags := flag.NewFlagSet("user create", flag.ExitOnError)
+	firstname := flags.String("firstname", "", "First name")
+	lastname := flags.String("lastname", "", "Last name")
+	email := flags.String("email", "", "Email")
+	password := flags.String("password", "", "Password, generated if empty")
+	phone := flags.String("phone", "", "Phone")
+	university := flags.String("university", "", "University")
+	birth_date := flags.String("birth-date", "", "Birth date")
+	role_name := flags.String("role", "leader", "Role")
+	squad_id := flags.Uint("squad", 0, "Squad ID")
+	output := flags.String("o", OutputTable, "Output format: table or json")
+	flags.Parse(args)
+
+	if *firstname == "" || *lastname == "" || *email == "" {
+		return fmt.Errorf("-firstname, -lastname and -email are required")
+	}
+
+	db, enforcer, closer, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	// check the email is free and the role exists
+	if existing, err := apiuser.GetUserByEmail(db, *email); err == nil && existing.ID != 0 {
+		return fmt.Errorf("email already used by user %d", existing.ID)
+	}
+	if _, err := role.GetRoleByName(db, *role_name); err != nil {
+		return fmt.Errorf("role %s: %v", *role_name, err)
+	}
+
+	// generate the password if not given
+	generated := ""
+	if *password == "" {
+		if generated, err = randomPassword(); err != nil {
+			return err
+		}
+		*password = generated
+	}
+	apiuser.HashPassword(password)
+
+	new_user, err := apiuser.NewUser(db, apiuser.User{
+		FirstName:      *firstname,
+		LastName:       *lastname,
+		Email:          *email,
+		IsVerified:     true,
+		University:     *university,
+		Phone:          *phone,
+		BirthDate:      *birth_date,
+		Password:       *password,
+		Paiment_Status: false,
+		Paiment_Date:   "0",
+		Role:           *role_name,
+		SquadID:        *squad_id,
+	})
+	if err != nil {
+		return err
+	}
+
+	// add the user to the role
+	if _, err := enforcer.AddGroupingPolicy(middleware.Subject(new_user.ID), new_user.Role, cfg.RBAC.DefaultDomain); err != nil {
+		return err
+	}
+
+	auditCommand(db, "user.create", "user:"+strconv.Itoa(int(new_user.ID)), nil, user_fields(new_user))
+
+	result := map[string]interface{}{"id": new_user.ID, "email": new_user.Email, "role": new_user.Role}
+	if generated != "" {
+		result["password"] = generated
+	}
+
+	row := []string{strconv.Itoa(int(new_user.ID)), new_user.Email, new_user.Role, generated}
+	return printOutput(*output, []string{"ID", "EMAIL", "ROLE", "PASSWORD"}, [][]string{row}, result)
+}
+
+// set a new password and revoke the sessions
+func runUserResetPassword(cfg *config.Config, args []string) error {
+
+	flags := flag.NewFlagSet("user reset-password", flag.ExitOnError)
+	id := flags.Uint("id", 0, "User ID")
+	email := flags.String("email", "", "User email")
+	password := flags.String("password", "", "New password, generated if empty")
+	flags.Parse(args)
+
+	db, _, closer, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	db_user, err := findUser(db, *id, *email)
+	if err != nil {
+		return err
+	}
+
+	generated := ""
+	if *password == "" {
+		if generated, err = randomPassword(); err != nil {
+			return err
+		}
+		*password = generated
+	}
+	apiuser.HashPassword(password)
+
+	if err := apiuser.UpdateUser(db, apiuser.User{ID: db_user.ID, Password: *password}); err != nil {
+		return err
+	}
+
+	// the old tokens can't be used anymore
+	if err := apiuser.RevokeAllSessions(db, db_user.ID); err != nil {
+		return err
+	}
+
+	auditCommand(db, "user.reset_password", "user:"+strconv.Itoa(int(db_user.ID)), nil, nil)
+
+	fmt.Printf("password of %s changed\n", db_user.Email)
+	if generated != "" {
+		fmt.Println("new password:", generated)
+	}
+
+	return nil
+}
+
+// grant a role to the user in a domain
+func runUserGrantRole(cfg *config.Config, args []string) error {
+
+	flags := flag.NewFlagSet("user grant-role", flag.ExitOnError)
+	id := flags.Uint("id", 0, "User ID")
+	email := flags.String("email", "", "User email")
+	role_name := flags.String("role", "", "Role")
+	domain := flags.String("domain", cfg.RBAC.DefaultDomain, "Domain, * for every event")
+	flags.Parse(args)
+
+	if *role_name == "" {
+		return fmt.Errorf("-role is required")
+	}
+
+	db, enforcer, closer, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	db_user, err := findUser(db, *id, *email)
+	if err != nil {
+		return err
+	}
+	if _, err := role.GetRoleByName(db, *role_name); err != nil {
+		return fmt.Errorf("role %s: %v", *role_name, err)
+	}
+
+	added, err := enforcer.AddGroupingPolicy(middleware.Subject(db_user.ID), *role_name, *domain)
+	if err != nil {
+		return err
+	}
+	if !added {
+		fmt.Printf("%s already has the role %s in %s\n", db_user.Email, *role_name, *domain)
+		return nil
+	}
+
+	// the next token carries the new role
+	if err := apiuser.RevokeAllSessions(db, db_user.ID); err != nil {
+		return err
+	}
+
+	auditCommand(db, "user.roles", "user:"+strconv.Itoa(int(db_user.ID)), nil, map[string]string{"role": *role_name, "domain": *domain})
+
+	fmt.Printf("role %s granted to %s in %s\n", *role_name, db_user.Email, *domain)
+
+	return nil
+}
+
+// squad subcommands
+func runSquad(cfg *config.Config, args []string) error {
+
+	if len(args) == 0 || args[0] != "list" {
+		return fmt.Errorf("usage: %s", commands["squad"].usage)
+	}
+
+	flags := flag.NewFlagSet("squad list", flag.ExitOnError)
+	output := flags.String("o", OutputTable, "Output format: table or json")
+	flags.Parse(args[1:])
+
+	db, _, closer, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	rows, values, err := exportSquads(db, cfg.RBAC.DefaultDomain)
+	if err != nil {
+		return err
+	}
+
+	return printOutput(*output, []string{"ID", "NAME", "LEADER", "MEMBERS", "CREATED"}, rows, values)
+}
+
+// mark the payment of the user
+func runPayment(cfg *config.Config, args []string) error {
+
+	if len(args) == 0 || args[0] != "mark" {
+		return fmt.Errorf("usage: %s", commands["payment"].usage)
+	}
+
+	flags := flag.NewFlagSet("payment mark", flag.ExitOnError)
+	id := flags.Uint("id", 0, "User ID")
+	email := flags.String("email", "", "User email")
+	flags.Parse(args[1:])
+
+	db, _, closer, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	db_user, err := findUser(db, *id, *email)
+	if err != nil {
+		return err
+	}
+
+	paiment_status := apiuser.User{
+		ID:             db_user.ID,
+		Paiment_Status: true,
+		Paiment_Date:   time.Now().Format("2006-01-02 15:04:05"),
+	}
+
+	if err := apiuser.UpdateUser(db, paiment_status); err != nil {
+		return err
+	}
+
+	auditCommand(db, "paiment.update", "user:"+strconv.Itoa(int(db_user.ID)),
+		map[string]interface{}{"paiment_status": db_user.Paiment_Status, "paiment_date": db_user.Paiment_Date},
+		map[string]interface{}{"paiment_status": paiment_status.Paiment_Status, "paiment_date": paiment_status.Paiment_Date})
+
+	fmt.Printf("payment of %s marked on %s\n", db_user.Email, paiment_status.Paiment_Date)
+
+	return nil
+}
+
+// export the users, the squads or the policies
+func runExport(cfg *config.Config, args []string) error {
+
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s", commands["export"].usage)
+	}
+
+	flags := flag.NewFlagSet("export "+args[0], flag.ExitOnError)
+	output := flags.String("o", OutputJSON, "Output format: table or json")
+	format := flags.String("format", permission.FormatYAML, "Policy file format: yaml or csv")
+	domain := flags.String("domain", cfg.RBAC.DefaultDomain, "Event of the registration form answers")
+	flags.Parse(args[1:])
+
+	db, enforcer, closer, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	switch args[0] {
+	case "users":
+		rows, values, err := exportUsers(db, *domain)
+		if err != nil {
+			return err
+		}
+		return printOutput(*output, []string{"ID", "FIRSTNAME", "LASTNAME", "EMAIL", "ROLE", "SQUAD", "VERIFIED", "PAID", "PAID AT"}, rows, values)
+
+	case "squads":
+		rows, values, err := exportSquads(db, *domain)
+		if err != nil {
+			return err
+		}
+		return printOutput(*output, []string{"ID", "NAME", "LEADER", "MEMBERS", "CREATED"}, rows, values)
+
+	case "policies":
+		file, err := permission.ExportPolicies(db, enforcer)
+		if err != nil {
+			return err
+		}
+		return permission.WritePolicyFile(os.Stdout, file, *format)
+
+	default:
+		return fmt.Errorf("unknown export: %s", args[0])
+	}
+}
+
+// user fields shown by the commands, without the password and the codes
+func user_fields(db_user apiuser.User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":             db_user.ID,
+		"firstname":      db_user.FirstName,
+		"lastname":       db_user.LastName,
+		"email":          db_user.Email,
+		"university":     db_user.University,
+		"phone":          db_user.Phone,
+		"role":           db_user.Role,
+		"squad_id":       db_user.SquadID,
+		"verified":       db_user.IsVerified,
+		"paiment_status": db_user.Paiment_Status,
+		"paiment_date":   db_user.Paiment_Date,
+	}
+}
+
+// users as table rows and json values with their answers to the registration form of the domain
+func exportUsers(db *gorm.DB, domain string) (rows [][]string, values []map[string]interface{}, err error) {
+
+	users, err := apiuser.GetAllUsers(db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	answers, err := form.AnswersByOwner(db, domain, form.ScopeUser)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values = []map[string]interface{}{}
+	for _, db_user := range users {
+		rows = append(rows, []string{
+			strconv.Itoa(int(db_user.ID)),
+			db_user.FirstName,
+			db_user.LastName,
+			db_user.Email,
+			db_user.Role,
+			strconv.Itoa(int(db_user.SquadID)),
+			strconv.FormatBool(db_user.IsVerified),
+			strconv.FormatBool(db_user.Paiment_Status),
+			db_user.Paiment_Date,
+		})
+		fields := user_fields(db_user)
+		fields["answers"] = answers[db_user.ID]
+		values = append(values, fields)
+	}
+
+	return rows, values, nil
+}
+
+// squads as table rows and json values with their answers to the registration form of the domain
+func exportSquads(db *gorm.DB, domain string) (rows [][]string, values []map[string]interface{}, err error) {
+
+	squads, err := squad.GetAllSquads(db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	answers, err := form.AnswersByOwner(db, domain, form.ScopeSquad)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values = []map[string]interface{}{}
+	for _, db_squad := range squads {
+		rows = append(rows, []string{
+			strconv.Itoa(int(db_squad.ID)),
+			db_squad.Name,
+			db_squad.LeaderID.Email,
+			strconv.Itoa(len(db_squad.SquadMembers)),
+			db_squad.CreatedAt.Format("2006-01-02"),
+		})
+		values = append(values, map[string]interface{}{
+			"id":            db_squad.ID,
+			"name":          db_squad.Name,
+			"created_by":    db_squad.CreatedBy,
+			"leader":        db_squad.LeaderID.Email,
+			"squad_members": db_squad.SquadMembers,
+			"logo_url":      db_squad.LogoURL,
+			"status":        db_squad.Status,
+			"created_at":    db_squad.CreatedAt,
+			"answers":       answers[db_squad.ID],
+		})
+	}
+
+	return rows, values, nil
+}
+
+// print the loaded config, the secrets are hidden
+func runConfig(cfg *config.Config, args []string) error {
+	fmt.Println(cfg.String())
+	return nil
+}
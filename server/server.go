@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -10,10 +11,16 @@ import (
 	"github.com/casbin/casbin/v2"
 	gormadapter "github.com/casbin/gorm-adapter/v3"
 	"github.com/ezzddinne/api"
+	"github.com/ezzddinne/api/file"
+	"github.com/ezzddinne/api/match"
+	"github.com/ezzddinne/api/squad"
+	"github.com/ezzddinne/config"
 	"github.com/ezzddinne/database"
+	"github.com/ezzddinne/middleware"
+	"github.com/ezzddinne/scanner"
+	"github.com/ezzddinne/storage"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -23,14 +30,13 @@ type Database struct {
 	DB *gorm.DB
 }
 
-func init() {
-	if err := godotenv.Load(); err != nil {
-		log.Fatal("[WARNING]", err)
-	}
+// database connection
+func DBConnection(cfg *config.Config) (*gorm.DB, error) {
+	return dbConnection(cfg, logger.Info)
 }
 
-// database connection
-func DBConnection() (*gorm.DB, error) {
+// database connection logging the queries from level
+func dbConnection(cfg *config.Config, level logger.LogLevel) (*gorm.DB, error) {
 
 	// create database logger
 	newLogger := logger.New(
@@ -38,23 +44,41 @@ func DBConnection() (*gorm.DB, error) {
 		logger.Config{
 			SlowThreshold: time.Second,
 			Colorful:      true,
-			LogLevel:      logger.Info,
+			LogLevel:      level,
 		},
 	)
 
-	// database url
-	url := fmt.Sprintf("host=" + os.Getenv("DB_HOST") + " user=" + os.Getenv("DB_USER") + " password=" + os.Getenv("DB_PASSWORD") + " dbname=" + os.Getenv("DB_NAME") + " port=" + os.Getenv("DB_PORT") + " sslmode=disable")
-
-	return gorm.Open(postgres.Open(url), &gorm.Config{Logger: newLogger})
+	return gorm.Open(postgres.Open(cfg.Database.DSN()), &gorm.Config{Logger: newLogger})
 }
 
-// run database
+// run the subcommand of the command line, serve by default
 func RunServer() {
-	// database connection
-	db, err := DBConnection()
+
+	// config flags go before the subcommand: -config file -db-host host serve
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	config_flags := config.BindFlags(flags)
+	database_flag := flags.Bool("database", false, "Same as serve -database")
+	flags.Parse(os.Args[1:])
+
+	// load the config once, it is passed to everything that needs it
+	cfg, err := config_flags.Load()
 	if err != nil {
-		panic(fmt.Sprintf("[WARNING] database connection: %v", err))
+		log.Fatal("[WARNING] config: ", err)
 	}
+	middleware.Configure(cfg)
+
+	args := flags.Args()
+	if *database_flag {
+		args = append([]string{"serve", "-database"}, args...)
+	}
+
+	if err := RunCommand(cfg, args); err != nil {
+		log.Fatal("[WARNING] ", err)
+	}
+}
+
+// casbin enforcer loaded from the database, synced with the other instances by the watcher
+func NewEnforcer(db *gorm.DB, cfg *config.Config) (*casbin.SyncedEnforcer, *database.PolicyWatcher) {
 
 	// initialize casbin adapter
 	adapter, err := gormadapter.NewAdapterByDB(db)
@@ -62,26 +86,141 @@ func RunServer() {
 		panic(fmt.Sprintf("[WARNING] failed to initialize casbin adapter: %v", err))
 	}
 
-	// load model configuration file and policy store adapter
-	enforcer, err := casbin.NewEnforcer("config/rbac_model.conf", adapter)
+	// load model configuration file with the configured root role
+	rbac_model, err := middleware.LoadModel("config/rbac_model.conf", cfg.RBAC.DefaultRoot)
+	if err != nil {
+		panic(fmt.Sprintf("[WARNING] failed to load casbin model: %v", err))
+	}
+
+	// load model and policy store adapter
+	enforcer, err := casbin.NewSyncedEnforcer(rbac_model, adapter)
 	if err != nil {
 		panic(fmt.Sprintf("[WARNING] failed to create casbin enforcer: %v", err))
 	}
 
+	// roles granted in the "*" domain apply to every event
+	if err := middleware.EnableDomainMatching(enforcer); err != nil {
+		panic(fmt.Sprintf("[WARNING] failed to enable domain matching: %v", err))
+	}
+
+	// sync the policies changed by the other instances
+	watcher, err := database.NewPolicyWatcher(db, cfg.Database.DSN())
+	if err != nil {
+		panic(fmt.Sprintf("[WARNING] failed to create policy watcher: %v", err))
+	}
+
+	if err := database.WatchPolicies(enforcer, watcher); err != nil {
+		panic(fmt.Sprintf("[WARNING] failed to set policy watcher: %v", err))
+	}
+
+	return enforcer, watcher
+}
+
+// run the api server
+func Serve(cfg *config.Config, args []string) error {
+
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+
 	// check database migration
 	// define a bool flag
-	database_flag := flag.Bool("database", false, "Bool variable to create database")
-	flag.Parse()
+	database_flag := flags.Bool("database", false, "Bool variable to create database")
+
+	// policy file flags
+	policy_file := flags.String("policy-file", "", "Apply the yaml or csv policy file and quit")
+	policy_dry_run := flags.Bool("policy-dry-run", false, "Only print the changes of the policy file")
+	policy_prune := flags.Bool("policy-prune", false, "Remove the rules missing from the policy file")
+	policy_export := flags.String("policy-export", "", "Export the policies to a yaml or csv file and quit, - for stdout")
+	flags.Parse(args)
+
+	// database connection
+	db, err := DBConnection(cfg)
+	if err != nil {
+		panic(fmt.Sprintf("[WARNING] database connection: %v", err))
+	}
+
+	// load the policies
+	enforcer, watcher := NewEnforcer(db, cfg)
+	defer watcher.Close()
 
 	// just create database and quit
 	if *database_flag {
-		// auto migrate tables & create root user
-		database.AutoMigrateDatabase(db, enforcer)
-		return
+		// migrate tables & create root user
+		database.AutoMigrateDatabase(db, enforcer, cfg)
+		return nil
+	}
+
+	// export the policies and quit
+	if *policy_export != "" {
+		if err := database.ExportPolicyFile(db, enforcer, *policy_export); err != nil {
+			panic(fmt.Sprintf("[WARNING] failed to export policies: %v", err))
+		}
+		return nil
+	}
+
+	// apply the policy file and quit
+	if *policy_file != "" {
+		if err := database.SyncPolicyFile(db, enforcer, *policy_file, *policy_prune, *policy_dry_run, os.Stdout); err != nil {
+			panic(fmt.Sprintf("[WARNING] failed to apply policy file: %v", err))
+		}
+		return nil
+	}
+
+	// keep the policies in sync with the version controlled file
+	if path := cfg.RBAC.PolicyFile; path != "" {
+		if err := database.SyncPolicyFile(db, enforcer, path, cfg.RBAC.PolicyPrune, false, log.Writer()); err != nil {
+			panic(fmt.Sprintf("[WARNING] failed to apply policy file: %v", err))
+		}
+	}
+
+	// load the token signing keys shared by the instances
+	if err := middleware.LoadKeys(db, cfg.Token.KeysDir, cfg.Token.SigningAlg); err != nil {
+		panic(fmt.Sprintf("[WARNING] failed to load signing keys: %v", err))
 	}
 
+	// reload and rotate the signing keys, old keys are kept until the tokens they signed expire
+	rotation := time.Hour * time.Duration(cfg.Token.KeyRotation)
+	middleware.StartKeyRotation(rotation, rotation+cfg.Token.SessionTTL())
+
+	// storage of the uploaded logos and cvs
+	store, err := storage.New(cfg)
+	if err != nil {
+		panic(fmt.Sprintf("[WARNING] failed to create storage: %v", err))
+	}
+
+	// scanner of the uploads, the quarantined files are scanned again until they are clean
+	sc, err := scanner.New(cfg.Scanner)
+	if err != nil {
+		panic(fmt.Sprintf("[WARNING] failed to create scanner: %v", err))
+	}
+	if cfg.Scanner.Backend == "none" {
+		log.Println("[WARNING] SCANNER_BACKEND is none, the uploads are marked clean without being scanned")
+	}
+	if pinger, ok := sc.(interface{ Ping(context.Context) error }); ok {
+		if err := pinger.Ping(context.Background()); err != nil {
+			log.Println("[WARNING] scanner is unreachable, the uploads stay in quarantine:", err)
+		}
+	}
+	file.StartScanWorker(db, store, sc, time.Minute*time.Duration(cfg.Scanner.RetryDelay))
+
+	// the cvs uploaded before the private files are still public
+	go func() {
+		if err := squad.PurgeLegacyCVs(db, cfg.Cloudinary); err != nil {
+			log.Println("[WARNING] legacy cvs:", err)
+		}
+	}()
+
+	// expire the late squads and promote the waitlist
+	squad.StartWaitlistWorker(db, cfg, time.Minute*time.Duration(cfg.Squad.WaitlistInterval))
+
+	// squad proposals from the pool
+	match.StartMatchWorker(db, cfg, time.Minute*time.Duration(cfg.Squad.MatchInterval))
+
 	// declare api routes
 	router := gin.Default()
+	router.Use(middleware.RequestID())
+
+	// public keys used to verify the tokens
+	router.GET("/.well-known/jwks.json", middleware.GetJWKS)
 
 	// create api routes group
 	router_api := router.Group("/api")
@@ -99,10 +238,26 @@ func RunServer() {
 		}))
 
 		// call API routes by adding /api as a prefix
-		api.RoutesApis(router_api, db, enforcer)
+		api.RoutesApis(router_api, db, enforcer, cfg, store, sc)
 
 	}
 
 	// run the server
-	router.Run(os.Getenv("APP_PORT"))
+	return router.Run(cfg.AppPort)
+}
+
+// run the migrate subcommand
+func RunMigrate(cfg *config.Config, args []string) error {
+
+	// creating a migration doesn't need the database
+	var db *gorm.DB
+	if len(args) == 0 || args[0] != "create" {
+		connection, err := dbConnection(cfg, logger.Warn)
+		if err != nil {
+			return err
+		}
+		db = connection
+	}
+
+	return database.RunMigrateCommand(db, args, os.Stdout)
 }
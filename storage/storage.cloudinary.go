@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/cloudinary/cloudinary-go"
+	"github.com/cloudinary/cloudinary-go/api"
+	"github.com/cloudinary/cloudinary-go/api/uploader"
+	"github.com/ezzddinne/config"
+)
+
+// files stored in cloudinary as authenticated assets, the key is the public id under the upload folder
+type cloudinaryStorage struct {
+	cld    *cloudinary.Cloudinary
+	folder string
+}
+
+func NewCloudinary(cfg config.CloudinaryConfig) (Storage, error) {
+
+	cld, err := cloudinary.NewFromParams(cfg.CloudName, cfg.APIKey, cfg.APISecret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cloudinaryStorage{cld: cld, folder: cfg.UploadFolder}, nil
+}
+
+func (c *cloudinaryStorage) Name() string {
+	return "cloudinary"
+}
+
+func (c *cloudinaryStorage) Put(ctx context.Context, key string, content io.Reader, size int64, content_type string) (string, error) {
+
+	public_id, err := c.publicID(key)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := c.cld.Upload.Upload(ctx, content, uploader.UploadParams{PublicID: public_id, Type: api.Authenticated, Overwrite: true})
+	if err != nil {
+		return "", err
+	}
+	if result.Error.Message != "" {
+		return "", errors.New(result.Error.Message)
+	}
+
+	return result.SecureURL, nil
+}
+
+func (c *cloudinaryStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+
+	public_id, err := c.publicID(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// signed delivery url of the authenticated asset
+	asset, err := c.cld.Image(public_id + path.Ext(key))
+	if err != nil {
+		return nil, err
+	}
+	asset.DeliveryType = api.Authenticated
+	asset.Config.URL.SignURL = true
+	asset.Config.URL.Secure = true
+
+	url, err := asset.String()
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("cloudinary: %s", response.Status)
+	}
+
+	return response.Body, nil
+}
+
+func (c *cloudinaryStorage) Delete(ctx context.Context, key string) error {
+
+	public_id, err := c.publicID(key)
+	if err != nil {
+		return err
+	}
+
+	result, err := c.cld.Upload.Destroy(ctx, uploader.DestroyParams{PublicID: public_id, Type: string(api.Authenticated), Invalidate: true})
+	if err != nil {
+		return err
+	}
+	if result.Error.Message != "" {
+		return errors.New(result.Error.Message)
+	}
+
+	return nil
+}
+
+// public id of the key, cloudinary adds the extension of the format
+func (c *cloudinaryStorage) publicID(key string) (string, error) {
+
+	key, err := CleanKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	public_id := strings.TrimSuffix(key, path.Ext(key))
+	if c.folder != "" {
+		public_id = c.folder + "/" + public_id
+	}
+
+	return public_id, nil
+}
+
+// version segment of the delivery urls: v1690000000
+var cloudinaryVersion = regexp.MustCompile(`^v\d+$`)
+
+// delete a public asset uploaded before the private storage, from its delivery url
+// https://res.cloudinary.com/<cloud>/<resource type>/upload/v<version>/<public id>.<format>
+func DeletePublicURL(ctx context.Context, cfg config.CloudinaryConfig, delivery_url string) error {
+
+	public_id, resource_type, err := publicAsset(delivery_url)
+	if err != nil {
+		return err
+	}
+
+	cld, err := cloudinary.NewFromParams(cfg.CloudName, cfg.APIKey, cfg.APISecret)
+	if err != nil {
+		return err
+	}
+
+	result, err := cld.Upload.Destroy(ctx, uploader.DestroyParams{PublicID: public_id, Type: string(api.Upload), ResourceType: resource_type, Invalidate: true})
+	if err != nil {
+		return err
+	}
+	if result.Error.Message != "" {
+		return errors.New(result.Error.Message)
+	}
+
+	// already deleted assets are "not found"
+	if result.Result != "ok" && result.Result != "not found" {
+		return fmt.Errorf("cloudinary: %s", result.Result)
+	}
+
+	return nil
+}
+
+// public id and resource type of a delivery url
+func publicAsset(delivery_url string) (string, string, error) {
+
+	parsed, err := url.Parse(delivery_url)
+	if err != nil {
+		return "", "", err
+	}
+
+	segments := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	if len(segments) < 4 || segments[2] != string(api.Upload) {
+		return "", "", fmt.Errorf("not a cloudinary upload url: %s", delivery_url)
+	}
+
+	resource_type, rest := segments[1], segments[3:]
+	if cloudinaryVersion.MatchString(rest[0]) && len(rest) > 1 {
+		rest = rest[1:]
+	}
+
+	// the raw assets keep their extension in the public id
+	public_id := strings.Join(rest, "/")
+	if resource_type != "raw" {
+		public_id = strings.TrimSuffix(public_id, path.Ext(public_id))
+	}
+
+	return public_id, resource_type, nil
+}
@@ -0,0 +1,54 @@
+// storage of the uploaded files: logos, cvs
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/ezzddinne/config"
+)
+
+// backend storing the uploaded files by key, the files are private
+// and only served through the signed download urls of the api
+type Storage interface {
+	// name saved with the file records
+	Name() string
+
+	// store the content under key and return its location in the backend
+	Put(ctx context.Context, key string, content io.Reader, size int64, content_type string) (string, error)
+
+	// read the content stored under key
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// remove the content stored under key
+	Delete(ctx context.Context, key string) error
+}
+
+// storage selected by the config, built once at startup
+func New(cfg *config.Config) (Storage, error) {
+
+	switch cfg.Storage.Kind(cfg.Cloudinary) {
+	case "local":
+		return NewLocal(cfg.Storage.LocalDir)
+	case "s3":
+		return NewS3(cfg.Storage)
+	case "cloudinary":
+		return NewCloudinary(cfg.Cloudinary)
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", cfg.Storage.Backend)
+	}
+}
+
+// keys are relative slash separated paths: logos/1/name.png
+func CleanKey(key string) (string, error) {
+
+	cleaned := path.Clean("/" + strings.ReplaceAll(key, "\\", "/"))[1:]
+	if cleaned == "" || cleaned != key {
+		return "", fmt.Errorf("invalid storage key: %s", key)
+	}
+
+	return cleaned, nil
+}
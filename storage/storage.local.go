@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// files stored on the local disk, used in development and tests
+type local struct {
+	dir string
+}
+
+func NewLocal(dir string) (Storage, error) {
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &local{dir: dir}, nil
+}
+
+func (l *local) Name() string {
+	return "local"
+}
+
+func (l *local) Put(ctx context.Context, key string, content io.Reader, size int64, content_type string) (string, error) {
+
+	file_path, err := l.path(key)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file_path), 0755); err != nil {
+		return "", err
+	}
+
+	// write a temporary file so a failed upload never leaves a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(file_path), ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, content); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tmp.Name(), file_path); err != nil {
+		return "", err
+	}
+
+	return filepath.ToSlash(file_path), nil
+}
+
+func (l *local) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+
+	file_path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(file_path)
+}
+
+func (l *local) Delete(ctx context.Context, key string) error {
+
+	file_path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(file_path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// path of the key inside the storage directory
+func (l *local) path(key string) (string, error) {
+
+	key, err := CleanKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(l.dir, filepath.FromSlash(key)), nil
+}
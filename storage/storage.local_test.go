@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+// store, read back and delete a file in the backend
+func testRoundTrip(t *testing.T, store Storage) {
+
+	ctx := context.Background()
+	key := "cvs/1/round-trip.pdf"
+	content := "%PDF-1.4 round trip"
+
+	location, err := store.Put(ctx, key, strings.NewReader(content), int64(len(content)), "application/pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location == "" {
+		t.Error("empty location")
+	}
+
+	reader, err := store.Open(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != content {
+		t.Errorf("read %q, want %q", data, content)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if reader, err := store.Open(ctx, key); err == nil {
+		reader.Close()
+		t.Error("deleted file still opens")
+	}
+
+	// deleting a missing file is not an error
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("delete missing file: %v", err)
+	}
+}
+
+func TestLocalRoundTrip(t *testing.T) {
+
+	store, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testRoundTrip(t, store)
+}
+
+func TestLocalRejectsInvalidKeys(t *testing.T) {
+
+	store, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"", "../outside.pdf", "cvs/../../outside.pdf", "/cvs/1/cv.pdf", "cvs//cv.pdf"} {
+		if _, err := store.Put(context.Background(), key, strings.NewReader("content"), 7, "application/pdf"); err == nil {
+			t.Errorf("%q: stored outside the storage directory", key)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/ezzddinne/config"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// files stored in a private s3 compatible bucket: aws, minio
+type s3 struct {
+	client *minio.Client
+	bucket string
+}
+
+func NewS3(cfg config.StorageConfig) (Storage, error) {
+
+	client, err := minio.New(cfg.S3Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.S3AccessKey, cfg.S3SecretKey, ""),
+		Secure: cfg.S3UseSSL,
+		Region: cfg.S3Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// create the bucket of a fresh minio
+	exists, err := client.BucketExists(ctx, cfg.S3Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, cfg.S3Bucket, minio.MakeBucketOptions{Region: cfg.S3Region}); err != nil {
+			return nil, err
+		}
+	}
+
+	return &s3{client: client, bucket: cfg.S3Bucket}, nil
+}
+
+func (s *s3) Name() string {
+	return "s3"
+}
+
+func (s *s3) Put(ctx context.Context, key string, content io.Reader, size int64, content_type string) (string, error) {
+
+	key, err := CleanKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := s.client.PutObject(ctx, s.bucket, key, content, size, minio.PutObjectOptions{ContentType: content_type}); err != nil {
+		return "", err
+	}
+
+	return "s3://" + s.bucket + "/" + key, nil
+}
+
+func (s *s3) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	// the request is sent on the first read, check the object exists
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return nil, err
+	}
+
+	return object, nil
+}
+
+func (s *s3) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
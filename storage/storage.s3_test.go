@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"os"
+	"testing"
+
+	"github.com/ezzddinne/config"
+)
+
+// run against a minio server when S3_TEST_ENDPOINT is set:
+// S3_TEST_ENDPOINT=localhost:9000 S3_TEST_ACCESS_KEY=minioadmin S3_TEST_SECRET_KEY=minioadmin go test ./storage
+func TestS3RoundTrip(t *testing.T) {
+
+	endpoint := os.Getenv("S3_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_TEST_ENDPOINT is not set")
+	}
+
+	bucket := os.Getenv("S3_TEST_BUCKET")
+	if bucket == "" {
+		bucket = "storage-test"
+	}
+
+	store, err := NewS3(config.StorageConfig{
+		S3Endpoint:  endpoint,
+		S3Region:    "us-east-1",
+		S3Bucket:    bucket,
+		S3AccessKey: os.Getenv("S3_TEST_ACCESS_KEY"),
+		S3SecretKey: os.Getenv("S3_TEST_SECRET_KEY"),
+		S3UseSSL:    os.Getenv("S3_TEST_USE_SSL") == "true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testRoundTrip(t, store)
+}